@@ -0,0 +1,307 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Har2yQn78/social_back.git/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type accountConfig struct {
+	deletionGracePeriod time.Duration // how long a deleted account can still be recovered by logging in
+	exportDir           string        // where data exports are written, shared by every instance of the API
+	exportExp           time.Duration // how long the download link of an export works
+	exportTimeout       time.Duration
+	exportWorkers       int           // exports prepared at the same time by one instance
+	exportQueue         int           // exports waiting for a worker before new requests are turned away
+	exportPendingExp    time.Duration // after which an export still being prepared is considered abandoned
+	exportsPerUser      int           // exports one user can ask for per window
+	exportWindow        time.Duration
+}
+
+var errExportPending = errors.New("a data export is already being prepared")
+
+// requestExportHandler queues an archive of the user's data to be built. The
+// download link is emailed once it's ready.
+func (app *application) requestExportHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	if allow, retryAfter := app.exportLimiter.Allow(strconv.FormatInt(user.ID, 10)); !allow {
+		app.rateLimitExceededResponse(w, r, retryAfter.String())
+		return
+	}
+
+	if err := app.store.Exports.Start(r.Context(), user.ID, app.config.account.exportPendingExp); err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			app.badRequestResponse(w, r, errExportPending)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	select {
+	case app.exports <- user:
+	default:
+		// Every worker is busy and the queue is full.
+		app.cancelExport(r.Context(), user.ID)
+		app.rateLimitExceededResponse(w, r, app.config.account.exportTimeout.String())
+		return
+	}
+
+	app.jsonResponse(w, http.StatusAccepted, map[string]string{"message": "your data is being exported, you will receive an email with a download link"})
+}
+
+// runExportWorkers sets up the queue of data exports and prepares them with a
+// fixed number of workers, until ctx is done. It must be called before the
+// server starts.
+func (app *application) runExportWorkers(ctx context.Context) {
+	app.exports = make(chan *store.User, app.config.account.exportQueue)
+	for i := 0; i < app.config.account.exportWorkers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case user := <-app.exports:
+					app.runExport(ctx, user)
+				}
+			}
+		}()
+	}
+}
+
+// runExport prepares one data export, giving up on it after the export timeout.
+func (app *application) runExport(ctx context.Context, user *store.User) {
+	defer func() {
+		if r := recover(); r != nil {
+			app.logger.Errorw("recovered in data export worker", "panic", r)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, app.config.account.exportTimeout)
+	defer cancel()
+
+	if err := app.exportUserData(ctx, user); err != nil {
+		app.logger.Errorw("failed to export user data", "user_id", user.ID, "error", err)
+		app.cancelExport(context.Background(), user.ID)
+	}
+}
+
+// cancelExport lets the user ask for a new export after theirs failed.
+func (app *application) cancelExport(ctx context.Context, userID int64) {
+	if err := app.store.Exports.Cancel(ctx, userID); err != nil {
+		app.logger.Errorw("failed to cancel data export", "user_id", userID, "error", err)
+	}
+}
+
+// exportUserData writes the archive of the user's data and emails its download link.
+func (app *application) exportUserData(ctx context.Context, user *store.User) error {
+	data, err := app.store.Exports.GetUserData(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	// Exports are kept per user, so deleting the account can remove them all at once.
+	fileName := filepath.Join(strconv.FormatInt(user.ID, 10), uuid.New().String()+".zip")
+	path := filepath.Join(app.config.account.exportDir, fileName)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	files := []exportFile{
+		{"profile.json", user},
+		{"posts.json", data.Posts},
+		{"comments.json", data.Comments},
+		{"followers.json", data.Followers},
+		{"following.json", data.Following},
+	}
+	if err := writeZip(path, files); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	plainToken := uuid.New().String()
+	if err := app.store.Exports.Create(ctx, user.ID, fileName, plainToken, app.config.account.exportExp); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	app.sendEmail(user.Email, "data_export.tmpl", map[string]any{
+		"downloadURL": app.config.apiURL + "/v1/users/export/" + plainToken,
+		"username":    user.Username,
+		"expiry":      app.config.account.exportExp.String(),
+	})
+	return nil
+}
+
+// exportFile is a file of a data export archive, content is written as JSON.
+type exportFile struct {
+	name    string
+	content any
+}
+
+// writeZip writes the files as indented JSON into a new zip archive at path.
+func writeZip(path string, files []exportFile) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for _, file := range files {
+		w, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.content); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// downloadExportHandler serves the archive of a data export. The token in the
+// URL is the only credential, the link is opened from an email.
+func (app *application) downloadExportHandler(w http.ResponseWriter, r *http.Request) {
+	fileName, err := app.store.Exports.GetFileName(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	f, err := os.Open(filepath.Join(app.config.account.exportDir, fileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="gophersocial-export.zip"`)
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+type DeleteAccountPayload struct {
+	Password string `json:"password" validate:"required,min=3,max=72"`
+}
+
+// deleteMeHandler schedules the deletion of the authenticated user's account and
+// logs them out everywhere. Logging in again before the grace period is over
+// keeps the account.
+func (app *application) deleteMeHandler(w http.ResponseWriter, r *http.Request) {
+	var payload DeleteAccountPayload
+	if err := readJSON(w, r, &payload); err != nil { app.badRequestResponse(w, r, err); return }
+	if err := Validate.Struct(payload); err != nil { app.badRequestResponse(w, r, err); return }
+
+	// The cached user doesn't carry the password hash, read it from the database.
+	user, err := app.store.Users.GetByID(r.Context(), getUserFromContext(r).ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := user.Password.Compare(payload.Password); err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+
+	deleteAt, err := app.store.Users.ScheduleDeletion(r.Context(), user.ID, app.config.account.deletionGracePeriod)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	app.audit(r, store.AuditDeletionScheduled, &user.ID, user.Email)
+
+	// The sessions and tokens were revoked with the scheduling, the access
+	// tokens already issued are denied until they expire.
+	if err := app.denylist.RevokeUser(r.Context(), user.ID, app.config.auth.token.exp); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	app.invalidateUser(r.Context(), user.ID)
+
+	app.sendEmail(user.Email, "account_deletion.tmpl", map[string]any{
+		"username": user.Username,
+		"deleteAt": deleteAt.Format("January 2, 2006"),
+		"loginURL": app.config.frontendURL + "/login",
+	})
+
+	app.jsonResponse(w, http.StatusAccepted, map[string]string{
+		"message":               fmt.Sprintf("your account will be deleted, log in again before %s to keep it", deleteAt.Format(time.RFC3339)),
+		"deletion_scheduled_at": deleteAt.Format(time.RFC3339),
+	})
+}
+
+// cancelDeletion keeps the account of a user who logged in during the grace period of its deletion.
+func (app *application) cancelDeletion(r *http.Request, userID int64) error {
+	canceled, err := app.store.Users.CancelDeletion(r.Context(), userID)
+	if err != nil || !canceled {
+		return err
+	}
+	app.audit(r, store.AuditDeletionCanceled, &userID, "")
+
+	return nil
+}
+
+// purgeAccounts deletes the accounts whose grace period is over, with their exports.
+func (app *application) purgeAccounts(ctx context.Context) (int, error) {
+	userIDs, err := app.store.Users.DeleteScheduled(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, userID := range userIDs {
+		app.invalidateUser(ctx, userID)
+		app.invalidateTimeline(ctx, userID)
+		if err := os.RemoveAll(filepath.Join(app.config.account.exportDir, strconv.FormatInt(userID, 10))); err != nil {
+			app.logger.Errorw("failed to remove data exports", "user_id", userID, "error", err)
+		}
+	}
+	return len(userIDs), nil
+}
+
+// deleteExpiredExports removes the exports whose download link expired.
+func (app *application) deleteExpiredExports(ctx context.Context) (int, error) {
+	fileNames, err := app.store.Exports.DeleteExpired(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, fileName := range fileNames {
+		if err := os.Remove(filepath.Join(app.config.account.exportDir, fileName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			app.logger.Errorw("failed to remove data export", "file", fileName, "error", err)
+		}
+	}
+	return len(fileNames), nil
+}
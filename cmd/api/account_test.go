@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Har2yQn78/social_back.git/internal/ratelimiter"
+	"github.com/Har2yQn78/social_back.git/internal/store"
+)
+
+// fakeExports keeps the users whose export is being prepared.
+type fakeExports struct {
+	*store.ExportStore
+	pending map[int64]bool
+}
+
+func (s fakeExports) Start(_ context.Context, userID int64, _ time.Duration) error {
+	if s.pending[userID] {
+		return store.ErrConflict
+	}
+	s.pending[userID] = true
+	return nil
+}
+
+func (s fakeExports) Cancel(_ context.Context, userID int64) error {
+	delete(s.pending, userID)
+	return nil
+}
+
+func TestRequestExport(t *testing.T) {
+	app := newTestApplication(t)
+	exports := fakeExports{pending: make(map[int64]bool)}
+	app.store.Exports = exports
+	app.exportLimiter = ratelimiter.NewFixedWindowLimiter(2, time.Hour)
+	// No worker takes the exports off the queue, it's full after one.
+	app.exports = make(chan *store.User, 1)
+
+	alice := newTestUser(1, "user")
+	bob := newTestUser(2, "user")
+	carol := newTestUser(3, "user")
+
+	request := func(user *store.User) int {
+		r := withUser(httptest.NewRequest(http.MethodGet, "/v1/users/me/export", nil), user)
+		return executeRequest(r, http.HandlerFunc(app.requestExportHandler)).Code
+	}
+
+	if code := request(alice); code != http.StatusAccepted {
+		t.Fatalf("first export: got %d, want %d", code, http.StatusAccepted)
+	}
+	if code := request(alice); code != http.StatusBadRequest {
+		t.Fatalf("export while one is pending: got %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := request(alice); code != http.StatusTooManyRequests {
+		t.Fatalf("export over the limit: got %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	if code := request(bob); code != http.StatusTooManyRequests {
+		t.Fatalf("export with a full queue: got %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if exports.pending[bob.ID] {
+		t.Fatal("the export turned away is still pending")
+	}
+
+	<-app.exports
+	if code := request(carol); code != http.StatusAccepted {
+		t.Fatalf("export once the queue has room: got %d, want %d", code, http.StatusAccepted)
+	}
+}
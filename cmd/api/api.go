@@ -12,6 +12,8 @@ import (
 	"github.com/Har2yQn78/social_back.git/internal/store/cache"
 	"github.com/Har2yQn78/social_back.git/internal/ratelimiter"
 	"github.com/Har2yQn78/social_back.git/internal/mailer"
+	"github.com/Har2yQn78/social_back.git/internal/notifier"
+	"github.com/Har2yQn78/social_back.git/internal/cursor"
 	"github.com/go-chi/cors"
 )
 
@@ -23,15 +25,42 @@ type application struct {
 	cacheStorage  *cache.UserStore
 	rateLimiter   ratelimiter.Limiter
 	mailer 		  mailer.Mailer
+	denylist      auth.Denylist
+	totp          *auth.TOTP
+	secretBox     *auth.SecretBox
+	accountLockout ratelimiter.FailureTracker
+	ipLockout      ratelimiter.FailureTracker
+	magicLinkLimiter ratelimiter.Limiter
+	emailLimiter     ratelimiter.Limiter // account emails sent to one address
+	emailIPLimiter   ratelimiter.Limiter // account emails asked for by one client
+	providers      map[string]auth.Provider
+	notifier       notifier.Notifier
+	cursors        *cursor.Codec
+	timelines      *cache.TimelineStore // nil when Redis is disabled
+	exportLimiter  ratelimiter.Limiter
+	exports        chan *store.User // data exports waiting for a worker
 }
 
 type config struct {
 	addr        string
+	frontendURL string
+	apiURL      string // where the API is reached from outside, for links to it in emails
+	cursorSecret string
 	db          dbConfig
 	auth        authConfig
 	redisCfg    redisConfig
 	rateLimiter ratelimiter.Config
 	mailer      mailerConfig
+	loginLockout loginLockoutConfig
+	janitor     janitorConfig
+	magicLink   magicLinkConfig
+	emailLimit  emailLimitConfig
+	oidc        oidcConfig
+	oauth       oauthConfig
+	account     accountConfig
+	suggestions suggestionsConfig
+	timeline    timelineConfig
+	search      searchConfig
 }
 
 
@@ -54,11 +83,27 @@ type dbConfig struct {
 
 type authConfig struct {
 	token tokenConfig
+	totp  totpConfig
+}
+
+type totpConfig struct {
+	issuer      string
+	key         string        // passphrase the TOTP secrets are encrypted with
+	pendingExp  time.Duration // lifetime of the token between the two login steps
+	maxAttempts int           // wrong codes an mfa token survives
 }
 
 type tokenConfig struct {
-	secret string
-	iss    string
+	secret     string
+	iss        string
+	aud        string
+	leeway     time.Duration // clock skew tolerated when validating tokens
+	exp        time.Duration // lifetime of access tokens
+	refreshExp time.Duration // lifetime of refresh tokens
+	// When keysDir is set, tokens are signed with the PEM keys it holds
+	// instead of the shared secret, and the directory is reloaded every keysReload.
+	keysDir    string
+	keysReload time.Duration
 }
 
 func (app *application) mount() *chi.Mux {
@@ -80,33 +125,130 @@ func (app *application) mount() *chi.Mux {
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
+	r.Get("/.well-known/jwks.json", app.jwksHandler)
+
 	r.Route("/v1", func(r chi.Router) {
 		r.Get("/health", app.healthCheckHandler)
 		
 		r.Put("/users/activate/{token}", app.activateUserHandler)
+		r.Post("/users/activation/resend", app.resendActivationHandler)
+		r.Put("/users/email/confirm/{token}", app.confirmEmailHandler)
+		r.Get("/users/export/{token}", app.downloadExportHandler)
 
 		r.Route("/authentication", func(r chi.Router) {
 			r.Post("/user", app.registerUserHandler)
 			r.Post("/token", app.createTokenHandler)
+			r.Post("/token/2fa", app.createTokenTwoFactorHandler)
+			r.Post("/refresh", app.refreshTokenHandler)
+			r.With(app.AuthTokenMiddleware, app.requireSession).Post("/logout", app.logoutHandler)
+			r.Post("/password-reset", app.requestPasswordResetHandler)
+			r.Put("/password-reset/{token}", app.resetPasswordHandler)
+			r.Put("/unlock/{token}", app.unlockAccountHandler)
+			r.Post("/magic-link", app.requestMagicLinkHandler)
+			r.Post("/magic-link/{token}", app.consumeMagicLinkHandler)
+			r.Get("/oidc/{provider}", app.startOIDCLoginHandler)
+			r.Post("/oidc/{provider}/callback", app.oidcCallbackHandler)
+		})
+
+		// The authorization server third-party apps get their access tokens from.
+		r.Route("/oauth", func(r chi.Router) {
+			r.Post("/token", app.oauthTokenHandler)
+			r.Post("/introspect", app.oauthIntrospectHandler)
+			r.Post("/revoke", app.oauthRevokeHandler)
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware, app.requireSession)
+
+				r.Get("/authorize", app.getConsentHandler)
+				r.Post("/authorize", app.consentHandler)
+				r.Route("/clients", func(r chi.Router) {
+					r.Get("/", app.getOAuthClientsHandler)
+					r.Post("/", app.createOAuthClientHandler)
+					r.Delete("/{clientID}", app.deleteOAuthClientHandler)
+				})
+			})
 		})
 
 		r.Group(func(r chi.Router) {
 			r.Use(app.AuthTokenMiddleware)
-			r.Post("/posts", app.createPostHandler)
+			r.With(app.requireScope(scopePostsWrite)).Post("/posts", app.createPostHandler)
 			r.Route("/posts/{postID}", func(r chi.Router) {
 				r.Use(app.postsContextMiddleware)
 
-				r.Get("/", app.getPostHandler)
-				r.Patch("/", app.updatePostHandler)
-				r.Delete("/", app.deletePostHandler)
+				r.With(app.requireScope(scopePostsRead)).Get("/", app.getPostHandler)
+				r.With(app.requireScope(scopePostsWrite)).Patch("/", app.checkPermission("moderator", postOwner, app.updatePostHandler))
+				r.With(app.requireScope(scopePostsWrite)).Delete("/", app.checkPermission("moderator", postOwner, app.deletePostHandler))
 				
-				r.Post("/comments", app.createCommentHandler)
+				r.With(app.requireScope(scopeCommentsWrite)).Post("/comments", app.createCommentHandler)
+				r.Route("/comments/{commentID}", func(r chi.Router) {
+					r.Use(app.commentsContextMiddleware)
+					r.Use(app.requireScope(scopeCommentsWrite))
+
+					r.Patch("/", app.checkPermission("moderator", commentOwner, app.updateCommentHandler))
+					r.Delete("/", app.checkPermission("moderator", commentOwner, app.deleteCommentHandler))
+				})
+			})
+			r.With(app.requireScope(scopeUsersRead)).Get("/users/me", app.getMeHandler)
+			r.With(app.requireScope(scopeUsersWrite)).Patch("/users/me", app.updateMeHandler)
+			r.Route("/users/me/follow-requests", func(r chi.Router) {
+				r.With(app.requireScope(scopeUsersRead)).Get("/", app.getFollowRequestsHandler)
+				r.With(app.requireScope(scopeUsersWrite)).Put("/{userID}", app.approveFollowRequestHandler)
+				r.With(app.requireScope(scopeUsersWrite)).Delete("/{userID}", app.rejectFollowRequestHandler)
 			})
+			r.With(app.requireScope(scopeUsersRead)).Get("/users/search", app.searchUsersHandler)
+			r.With(app.requireScope(scopeUsersRead)).Get("/users/by-username/{username}", app.getUserByUsernameHandler)
+			r.With(app.requireScope(scopeUsersRead)).Get("/users/me/suggestions", app.getSuggestionsHandler)
+			r.With(app.requireScope(scopeUsersWrite)).Delete("/users/me/suggestions/{userID}", app.dismissSuggestionHandler)
+			r.With(app.requireScope(scopeUsersRead)).Get("/users/me/blocks", app.getBlockedHandler)
+			r.With(app.requireScope(scopeUsersRead)).Get("/users/me/mutes", app.getMutedHandler)
 			r.Route("/users/{userID}", func(r chi.Router) {
-	            r.Put("/follow", app.followUserHandler)
-	            r.Put("/unfollow", app.unfollowUserHandler)
+	            r.With(app.requireScope(scopeUsersRead)).Get("/", app.getUserHandler)
+	            r.With(app.requireScope(scopeUsersRead)).Get("/followers", app.getFollowersHandler)
+	            r.With(app.requireScope(scopeUsersRead)).Get("/following", app.getFollowingHandler)
+	            r.With(app.requireScope(scopePostsRead)).Get("/posts", app.getUserPostsHandler)
+	            r.With(app.requireScope(scopeUsersWrite)).Put("/follow", app.followUserHandler)
+	            r.With(app.requireScope(scopeUsersWrite)).Put("/unfollow", app.unfollowUserHandler)
+	            r.With(app.requireScope(scopeUsersWrite)).Put("/block", app.blockUserHandler)
+	            r.With(app.requireScope(scopeUsersWrite)).Delete("/block", app.unblockUserHandler)
+	            r.With(app.requireScope(scopeUsersWrite)).Put("/mute", app.muteUserHandler)
+	            r.With(app.requireScope(scopeUsersWrite)).Delete("/mute", app.unmuteUserHandler)
+	            r.With(app.requireSession, app.RequireRole("admin")).Put("/role", app.updateUserRoleHandler)
         	})
-			r.Get("/users/feed", app.getUserFeedHandler)
+			r.With(app.requireScope(scopeFeedRead)).Get("/users/feed", app.getUserFeedHandler)
+			r.Route("/search", func(r chi.Router) {
+				r.Use(app.requireScope(scopePostsRead))
+
+				r.Get("/posts", app.searchPostsHandler)
+				r.Get("/comments", app.searchCommentsHandler)
+			})
+
+			// Account management is only available to logged-in sessions.
+			r.Group(func(r chi.Router) {
+				r.Use(app.requireSession)
+
+				r.Patch("/users/me/email", app.changeEmailHandler)
+				r.Delete("/users/me", app.deleteMeHandler)
+				r.Get("/users/me/export", app.requestExportHandler)
+				r.Route("/users/me/2fa", func(r chi.Router) {
+					r.Post("/", app.enrollTwoFactorHandler)
+					r.Post("/confirm", app.confirmTwoFactorHandler)
+					r.Delete("/", app.disableTwoFactorHandler)
+				})
+				r.Route("/users/me/tokens", func(r chi.Router) {
+					r.Get("/", app.getPersonalTokensHandler)
+					r.Post("/", app.createPersonalTokenHandler)
+					r.Delete("/{tokenID}", app.deletePersonalTokenHandler)
+				})
+				r.Route("/users/me/sessions", func(r chi.Router) {
+					r.Get("/", app.getSessionsHandler)
+					r.Delete("/", app.deleteAllSessionsHandler)
+					r.Delete("/{sessionID}", app.deleteSessionHandler)
+				})
+				r.Route("/users/me/apps", func(r chi.Router) {
+					r.Get("/", app.getConnectedAppsHandler)
+					r.Delete("/{clientID}", app.revokeConnectedAppHandler)
+				})
+			})
 		})
 	})
 
@@ -5,8 +5,8 @@ import (
 	"time"
 
 	"github.com/Har2yQn78/social_back.git/internal/store"
-	"github.com/golang-jwt/jwt/v5" 
 	"github.com/google/uuid"
+	"github.com/go-chi/chi/v5"
 	"errors"
 )
 
@@ -29,7 +29,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
     plainToken := uuid.New().String()
 
-    err := app.store.Users.CreateAndInvite(r.Context(), user, plainToken, time.Hour*24*3)
+    err := app.store.Users.CreateAndInvite(r.Context(), user, plainToken, invitationExp)
     if err != nil {
         switch {
         case errors.Is(err, store.ErrDuplicateEmail), errors.Is(err, store.ErrDuplicateUsername):
@@ -40,30 +40,39 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
         return
     }
 
-    go func() {
-        defer func() {
-            if r := recover(); r != nil {
-                app.logger.Errorw("recovered in send email goroutine", "panic", r)
-            }
-        }()
-        data := map[string]any{
-            "activationURL": "http://localhost:8080/v1/users/activate/" + plainToken,
-            "username":      user.Username,
-        }
-        if err := app.mailer.Send(user.Email, "user_welcome.tmpl", data); err != nil {
-            app.logger.Errorw("failed to send welcome email", "error", err)
-        }
-    }()
+    app.sendActivationEmail(user, plainToken)
 
     app.jsonResponse(w, http.StatusAccepted, map[string]string{"message": "please check your email to activate your account"})
 }
 
+// invitationExp is how long an activation link stays valid.
+const invitationExp = time.Hour * 24 * 3
+
+// sendActivationEmail sends the welcome email with the link to the activation page.
+func (app *application) sendActivationEmail(user *store.User, plainToken string) {
+	data := map[string]any{
+		"activationURL": app.config.frontendURL + "/activate/" + plainToken,
+		"username":      user.Username,
+	}
+	app.sendEmail(user.Email, "user_welcome.tmpl", data)
+}
+
 // CreateUserTokenPayload defines the JSON we expect for a login request.
 type CreateUserTokenPayload struct {
 	Email    string `json:"email" validate:"required,email,max=255"`
 	Password string `json:"password" validate:"required,min=3,max=72"`
 }
 
+// dummyUser has a password hashed like the users' ones, for logins with an
+// unknown email to take as long as the others.
+var dummyUser = func() *store.User {
+	user := &store.User{}
+	if err := user.Password.Set("not the password of anyone"); err != nil {
+		panic(err)
+	}
+	return user
+}()
+
 // createTokenHandler handles user login and token generation.
 func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Request) {
 	var payload CreateUserTokenPayload
@@ -77,13 +86,29 @@ func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// 2. Fetch the user from the database by email.
+	// 2. Refuse the attempt while the account or the client is locked out. This is
+	// checked before looking the user up, so unknown emails behave the same way.
+	retryAfter, err := app.loginLockout(r.Context(), payload.Email, clientIP(r))
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.audit(r, store.AuditLoginBlocked, nil, payload.Email)
+		app.rateLimitExceededResponse(w, r, retryAfter.Round(time.Second).String())
+		return
+	}
+
+	// 3. Fetch the user from the database by email.
 	user, err := app.store.Users.GetByEmail(r.Context(), payload.Email)
 	if err != nil {
 		switch err {
 		case store.ErrNotFound:
 			// We send a generic "unauthorized" error to avoid revealing
-			// which emails are registered in our system.
+			// which emails are registered in our system, after comparing
+			// the password all the same so the response time doesn't either.
+			dummyUser.Password.Compare(payload.Password)
+			app.loginFailed(r, payload.Email, nil)
 			app.unauthorizedErrorResponse(w, r, err)
 		default:
 			app.internalServerError(w, r, err)
@@ -91,29 +116,254 @@ func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// 3. Compare the provided password with the stored hash.
+	// 4. Compare the provided password with the stored hash.
 	if err := user.Password.Compare(payload.Password); err != nil {
+		app.loginFailed(r, payload.Email, user)
 		app.unauthorizedErrorResponse(w, r, err)
 		return
 	}
+	// With two-factor authentication the failures are only cleared once the
+	// second factor checks out too, wrong codes count against the account.
+	if !user.TwoFactorEnabled {
+		app.loginSucceeded(r, user)
+	}
+
+	// 5. If credentials are correct, log the user in (or ask for their second factor).
+	app.completeLogin(w, r, user)
+}
+
+// completeLogin finishes a login once the user's first factor checked out. Users
+// with two-factor authentication get a short-lived mfa token to exchange at
+// /authentication/token/2fa, everyone else gets their tokens right away.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *store.User) {
+	if user.TwoFactorEnabled {
+		claims := app.authenticator.NewClaims(user.ID, app.config.auth.totp.pendingExp)
+		claims.MFAPending = true
 
-	// 4. If credentials are correct, create the JWT claims.
-	claims := jwt.MapClaims{
-		"sub": user.ID,                                 // Subject (the user's ID)
-		"exp": time.Now().Add(time.Hour * 24 * 3).Unix(), // Expiration (3 days)
-		"iat": time.Now().Unix(),                         // Issued At
-		"iss": "gophersocial",                            // Issuer
+		token, err := app.authenticator.GenerateToken(claims)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		challenge := MFAChallengeResponse{MFARequired: true, MFAToken: token}
+		if err := app.jsonResponse(w, http.StatusOK, challenge); err != nil {
+			app.internalServerError(w, r, err)
+		}
+		return
 	}
 
-	// 5. Generate the token.
-	token, err := app.authenticator.GenerateToken(claims)
+	tokens, err := app.issueTokens(r, user.ID)
 	if err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
 
-	// 6. Send the token to the client.
-	if err := app.jsonResponse(w, http.StatusCreated, token); err != nil {
+	if err := app.jsonResponse(w, http.StatusCreated, tokens); err != nil {
 		app.internalServerError(w, r, err)
 	}
-}
\ No newline at end of file
+}
+
+// TokenResponse is returned by every endpoint that logs a user in.
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // access token lifetime in seconds
+}
+
+// generateAccessToken signs a short-lived access token for the given user's session.
+func (app *application) generateAccessToken(userID int64, sessionID string) (string, error) {
+	claims := app.authenticator.NewClaims(userID, app.config.auth.token.exp)
+	claims.SessionID = sessionID
+	return app.authenticator.GenerateToken(claims)
+}
+
+// issueTokens starts a new session for the device the request comes from, and
+// returns its first refresh token and a matching access token.
+func (app *application) issueTokens(r *http.Request, userID int64) (*TokenResponse, error) {
+	session := &store.Session{
+		ID:        uuid.New().String(), // also the family of the session's refresh tokens
+		UserID:    userID,
+		Device:    deviceLabel(r.UserAgent()),
+		UserAgent: truncate(r.UserAgent(), 512),
+		IP:        clientIP(r),
+	}
+	if err := app.store.Sessions.Create(r.Context(), session, app.config.auth.token.refreshExp); err != nil {
+		return nil, err
+	}
+	// Logging in during the grace period of a deletion keeps the account.
+	if err := app.cancelDeletion(r, userID); err != nil {
+		return nil, err
+	}
+
+	refreshToken := uuid.New().String()
+	if err := app.store.RefreshTokens.Create(r.Context(), userID, session.ID, refreshToken, app.config.auth.token.refreshExp); err != nil {
+		return nil, err
+	}
+
+	token, err := app.generateAccessToken(userID, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(app.config.auth.token.exp.Seconds()),
+	}, nil
+}
+
+type RefreshTokenPayload struct {
+	RefreshToken string `json:"refresh_token" validate:"required,max=255"`
+}
+
+// refreshTokenHandler rotates a refresh token and returns a fresh access token.
+func (app *application) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var payload RefreshTokenPayload
+	if err := readJSON(w, r, &payload); err != nil { app.badRequestResponse(w, r, err); return }
+	if err := Validate.Struct(payload); err != nil { app.badRequestResponse(w, r, err); return }
+
+	// Rotate checks the account is still active before it issues the new token.
+	refreshToken := uuid.New().String()
+	userID, sessionID, err := app.store.RefreshTokens.Rotate(r.Context(), payload.RefreshToken, refreshToken, app.config.auth.token.refreshExp)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedErrorResponse(w, r, err)
+		case errors.Is(err, store.ErrTokenReused):
+			app.logger.Warnw("refresh token reuse detected, token family revoked", "error", err)
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	// Refreshes are how we know a session is still in use.
+	if err := app.store.Sessions.Touch(r.Context(), sessionID, clientIP(r), app.config.auth.token.refreshExp); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	token, err := app.generateAccessToken(userID, sessionID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	tokens := &TokenResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(app.config.auth.token.exp.Seconds()),
+	}
+	if err := app.jsonResponse(w, http.StatusCreated, tokens); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type LogoutPayload struct {
+	RefreshToken string `json:"refresh_token" validate:"omitempty,max=255"`
+}
+
+// logoutHandler ends the session the request was made from. A refresh token
+// can still be given, to revoke the family of tokens issued before sessions existed.
+func (app *application) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	var payload LogoutPayload
+	if r.ContentLength != 0 {
+		if err := readJSON(w, r, &payload); err != nil { app.badRequestResponse(w, r, err); return }
+		if err := Validate.Struct(payload); err != nil { app.badRequestResponse(w, r, err); return }
+	}
+
+	if payload.RefreshToken != "" {
+		if err := app.store.RefreshTokens.RevokeFamily(r.Context(), getUserFromContext(r).ID, payload.RefreshToken); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
+	claims := getClaimsFromContext(r)
+	if claims.SessionID != "" {
+		if err := app.revokeSession(r.Context(), claims.SessionID, getUserFromContext(r).ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+	if claims.ID != "" && claims.ExpiresAt != nil {
+		if err := app.denylist.Revoke(r.Context(), claims.ID, claims.ExpiresAt.Time); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type PasswordResetRequestPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// requestPasswordResetHandler emails a single-use reset link to the owner of the given email.
+func (app *application) requestPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	var payload PasswordResetRequestPayload
+	if err := readJSON(w, r, &payload); err != nil { app.badRequestResponse(w, r, err); return }
+	if err := Validate.Struct(payload); err != nil { app.badRequestResponse(w, r, err); return }
+
+	if !app.allowAccountEmail(w, r, payload.Email) {
+		return
+	}
+
+	user, err := app.store.Users.GetByEmail(r.Context(), payload.Email)
+	switch {
+	case err == nil:
+		plainToken := uuid.New().String()
+		if err := app.store.Users.CreatePasswordReset(r.Context(), user.ID, plainToken, time.Hour); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		data := map[string]any{
+			"resetURL": app.config.frontendURL + "/password-reset/" + plainToken,
+			"username": user.Username,
+		}
+		app.sendEmail(user.Email, "password_reset.tmpl", data)
+	case errors.Is(err, store.ErrNotFound):
+		// We answer exactly as if the email was found, to avoid revealing
+		// which emails are registered in our system.
+	default:
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusAccepted, map[string]string{"message": "if an account with that email exists, a password reset link has been sent"})
+}
+
+type ResetPasswordPayload struct {
+	Password string `json:"password" validate:"required,min=3,max=72"`
+}
+
+// resetPasswordHandler sets a new password using a reset token and logs the user out everywhere.
+func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	var payload ResetPasswordPayload
+	if err := readJSON(w, r, &payload); err != nil { app.badRequestResponse(w, r, err); return }
+	if err := Validate.Struct(payload); err != nil { app.badRequestResponse(w, r, err); return }
+
+	userID, err := app.store.Users.ResetPassword(r.Context(), token, payload.Password)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// Refresh tokens were revoked together with the password change, the access
+	// tokens still in circulation are denied until they expire.
+	if err := app.denylist.RevokeUser(r.Context(), userID, app.config.auth.token.exp); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusOK, map[string]string{"message": "password has been reset"})
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Har2yQn78/social_back.git/internal/auth"
+	"github.com/Har2yQn78/social_back.git/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// tokenState holds the sessions and refresh tokens of memSessions and
+// memRefreshTokens, which revoke each other's like the stores do.
+type tokenState struct {
+	sessions map[string]*store.Session
+	revoked  map[string]bool // revoked sessions
+	tokens   map[string]*memRefreshToken
+}
+
+type memRefreshToken struct {
+	userID  int64
+	family  string
+	expiry  time.Time
+	revoked bool
+}
+
+func newTokenState() *tokenState {
+	return &tokenState{
+		sessions: make(map[string]*store.Session),
+		revoked:  make(map[string]bool),
+		tokens:   make(map[string]*memRefreshToken),
+	}
+}
+
+func (s *tokenState) revokeFamily(family string) {
+	for _, t := range s.tokens {
+		if t.family == family {
+			t.revoked = true
+		}
+	}
+}
+
+type memSessions struct {
+	*store.SessionStore
+	state *tokenState
+}
+
+func (s memSessions) Create(_ context.Context, session *store.Session, _ time.Duration) error {
+	s.state.sessions[session.ID] = session
+	return nil
+}
+
+func (s memSessions) Touch(context.Context, string, string, time.Duration) error { return nil }
+
+func (s memSessions) Revoke(_ context.Context, sessionID string, userID int64) error {
+	session, ok := s.state.sessions[sessionID]
+	if !ok || session.UserID != userID || s.state.revoked[sessionID] {
+		return store.ErrNotFound
+	}
+	s.state.revoked[sessionID] = true
+	s.state.revokeFamily(sessionID)
+	return nil
+}
+
+type memRefreshTokens struct {
+	*store.RefreshTokenStore
+	state *tokenState
+}
+
+func (s memRefreshTokens) Create(_ context.Context, userID int64, familyID, token string, exp time.Duration) error {
+	s.state.tokens[token] = &memRefreshToken{userID: userID, family: familyID, expiry: time.Now().Add(exp)}
+	return nil
+}
+
+func (s memRefreshTokens) Rotate(ctx context.Context, oldToken, newToken string, exp time.Duration) (int64, string, error) {
+	t, ok := s.state.tokens[oldToken]
+	switch {
+	case !ok:
+		return 0, "", store.ErrNotFound
+	case t.revoked:
+		s.state.revokeFamily(t.family)
+		return 0, "", store.ErrTokenReused
+	case time.Now().After(t.expiry):
+		return 0, "", store.ErrNotFound
+	}
+	t.revoked = true
+	return t.userID, t.family, s.Create(ctx, t.userID, t.family, newToken, exp)
+}
+
+func (s memRefreshTokens) RevokeFamily(_ context.Context, userID int64, token string) error {
+	if t, ok := s.state.tokens[token]; ok && t.userID == userID {
+		s.state.revokeFamily(t.family)
+	}
+	return nil
+}
+
+// newAuthTestApplication returns an application with the active user 1, whose
+// sessions and refresh tokens are kept in state.
+func newAuthTestApplication(t *testing.T) (*application, *tokenState) {
+	t.Helper()
+
+	state := newTokenState()
+	app := newTestApplication(t)
+	app.authenticator = auth.NewJWTAuthenticator("test", "test", "test", 0)
+	app.denylist = auth.NewInMemoryDenylist()
+	app.config.auth.token.exp = time.Minute
+	app.config.auth.token.refreshExp = time.Hour
+	app.store.Users = fakeUsers{users: map[int64]*store.User{1: newTestUser(1, "user")}}
+	app.store.Sessions = memSessions{state: state}
+	app.store.RefreshTokens = memRefreshTokens{state: state}
+
+	return app, state
+}
+
+func login(t *testing.T, app *application) *TokenResponse {
+	t.Helper()
+
+	tokens, err := app.issueTokens(httptest.NewRequest(http.MethodPost, "/v1/authentication/token", nil), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+// refresh presents a refresh token and returns the answer and, on success, the new tokens.
+func refresh(t *testing.T, app *application, refreshToken string) (int, *TokenResponse) {
+	t.Helper()
+
+	body := `{"refresh_token":"` + refreshToken + `"}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/authentication/refresh", strings.NewReader(body))
+	rr := executeRequest(r, http.HandlerFunc(app.refreshTokenHandler))
+	if rr.Code != http.StatusCreated {
+		return rr.Code, nil
+	}
+
+	var res struct{ Data TokenResponse }
+	if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	return rr.Code, &res.Data
+}
+
+// authenticated runs a request with the access token through the auth
+// middleware and returns the status it's answered with.
+func authenticated(app *application, accessToken string, h http.HandlerFunc, body string) int {
+	r := httptest.NewRequest(http.MethodPost, "/v1/authentication/logout", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+accessToken)
+	return executeRequest(r, app.AuthTokenMiddleware(h)).Code
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+func TestRefreshTokenRotation(t *testing.T) {
+	app, _ := newAuthTestApplication(t)
+	first := login(t, app)
+
+	code, second := refresh(t, app, first.RefreshToken)
+	if code != http.StatusCreated {
+		t.Fatalf("refresh: got status %d, want %d", code, http.StatusCreated)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("the refresh token wasn't rotated")
+	}
+	if code := authenticated(app, second.Token, okHandler, ""); code != http.StatusOK {
+		t.Fatalf("new access token: got status %d, want %d", code, http.StatusOK)
+	}
+
+	// The rotated token is presented again: it was stolen, the whole family goes.
+	if code, _ := refresh(t, app, first.RefreshToken); code != http.StatusUnauthorized {
+		t.Fatalf("reused refresh token: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := refresh(t, app, second.RefreshToken); code != http.StatusUnauthorized {
+		t.Fatalf("latest token of a revoked family: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	// Other logins are left alone.
+	other := login(t, app)
+	if code, _ := refresh(t, app, other.RefreshToken); code != http.StatusCreated {
+		t.Fatalf("refresh of another session: got status %d, want %d", code, http.StatusCreated)
+	}
+}
+
+func TestRefreshTokenExpired(t *testing.T) {
+	app, state := newAuthTestApplication(t)
+	tokens := login(t, app)
+
+	state.tokens[tokens.RefreshToken].expiry = time.Now().Add(-time.Second)
+	if code, _ := refresh(t, app, tokens.RefreshToken); code != http.StatusUnauthorized {
+		t.Fatalf("expired refresh token: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := refresh(t, app, "unknown"); code != http.StatusUnauthorized {
+		t.Fatalf("unknown refresh token: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	t.Run("session", func(t *testing.T) {
+		app, _ := newAuthTestApplication(t)
+		tokens := login(t, app)
+
+		if code := authenticated(app, tokens.Token, app.logoutHandler, ""); code != http.StatusNoContent {
+			t.Fatalf("logout: got status %d, want %d", code, http.StatusNoContent)
+		}
+		if code := authenticated(app, tokens.Token, okHandler, ""); code != http.StatusUnauthorized {
+			t.Fatalf("access token after logout: got status %d, want %d", code, http.StatusUnauthorized)
+		}
+		if code, _ := refresh(t, app, tokens.RefreshToken); code != http.StatusUnauthorized {
+			t.Fatalf("refresh token after logout: got status %d, want %d", code, http.StatusUnauthorized)
+		}
+	})
+
+	// Tokens issued before sessions existed carry no session: the refresh
+	// token is given to revoke its family, the access token is denylisted.
+	t.Run("token without a session", func(t *testing.T) {
+		app, state := newAuthTestApplication(t)
+		refreshToken := "pre-session"
+		state.tokens[refreshToken] = &memRefreshToken{userID: 1, family: "family", expiry: time.Now().Add(time.Hour)}
+		accessToken, err := app.generateAccessToken(1, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body := `{"refresh_token":"` + refreshToken + `"}`
+		if code := authenticated(app, accessToken, app.logoutHandler, body); code != http.StatusNoContent {
+			t.Fatalf("logout: got status %d, want %d", code, http.StatusNoContent)
+		}
+		if code := authenticated(app, accessToken, okHandler, ""); code != http.StatusUnauthorized {
+			t.Fatalf("access token after logout: got status %d, want %d", code, http.StatusUnauthorized)
+		}
+		if code, _ := refresh(t, app, refreshToken); code != http.StatusUnauthorized {
+			t.Fatalf("refresh token after logout: got status %d, want %d", code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("someone else's refresh token", func(t *testing.T) {
+		app, state := newAuthTestApplication(t)
+		tokens := login(t, app)
+		state.tokens["bob"] = &memRefreshToken{userID: 2, family: "bob", expiry: time.Now().Add(time.Hour)}
+
+		body := `{"refresh_token":"bob"}`
+		if code := authenticated(app, tokens.Token, app.logoutHandler, body); code != http.StatusNoContent {
+			t.Fatalf("logout: got status %d, want %d", code, http.StatusNoContent)
+		}
+		if state.tokens["bob"].revoked {
+			t.Fatal("another user's refresh token was revoked")
+		}
+	})
+}
+
+// singleUseUsers consumes the tokens emailed to users like the store does.
+type singleUseUsers struct {
+	fakeUsers
+	tokens map[string]int64 // token to user
+}
+
+func (s singleUseUsers) consume(token string) (int64, error) {
+	userID, ok := s.tokens[token]
+	if !ok {
+		return 0, store.ErrNotFound
+	}
+	delete(s.tokens, token)
+	return userID, nil
+}
+
+func (s singleUseUsers) ResetPassword(_ context.Context, token, _ string) (int64, error) {
+	return s.consume(token)
+}
+
+func (s singleUseUsers) ConsumeMagicLink(_ context.Context, token string) (int64, error) {
+	return s.consume(token)
+}
+
+func (s singleUseUsers) ConfirmEmailChange(_ context.Context, token string) (int64, error) {
+	return s.consume(token)
+}
+
+func TestResetPasswordSingleUse(t *testing.T) {
+	app, _ := newAuthTestApplication(t)
+	app.store.Users = singleUseUsers{fakeUsers: app.store.Users.(fakeUsers), tokens: map[string]int64{"reset": 1}}
+
+	mux := chi.NewRouter()
+	mux.Put("/v1/authentication/password-reset/{token}", app.resetPasswordHandler)
+	reset := func() int {
+		body := `{"password":"new password"}`
+		return executeRequest(httptest.NewRequest(http.MethodPut, "/v1/authentication/password-reset/reset", strings.NewReader(body)), mux).Code
+	}
+
+	if code := reset(); code != http.StatusOK {
+		t.Fatalf("reset: got status %d, want %d", code, http.StatusOK)
+	}
+	if code := reset(); code != http.StatusNotFound {
+		t.Fatalf("reset token used again: got status %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestMagicLinkSingleUse(t *testing.T) {
+	app, _ := newAuthTestApplication(t)
+	app.store.Users = singleUseUsers{fakeUsers: app.store.Users.(fakeUsers), tokens: map[string]int64{"link": 1}}
+
+	mux := chi.NewRouter()
+	mux.Post("/v1/authentication/magic-link/{token}", app.consumeMagicLinkHandler)
+	consume := func() int {
+		return executeRequest(httptest.NewRequest(http.MethodPost, "/v1/authentication/magic-link/link", nil), mux).Code
+	}
+
+	if code := consume(); code != http.StatusCreated {
+		t.Fatalf("login: got status %d, want %d", code, http.StatusCreated)
+	}
+	if code := consume(); code != http.StatusUnauthorized {
+		t.Fatalf("magic link used again: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestConfirmEmailSingleUse(t *testing.T) {
+	app, _ := newAuthTestApplication(t)
+	app.store.Users = singleUseUsers{fakeUsers: app.store.Users.(fakeUsers), tokens: map[string]int64{"change": 1}}
+
+	mux := chi.NewRouter()
+	mux.Put("/v1/users/email/confirm/{token}", app.confirmEmailHandler)
+	confirm := func() int {
+		return executeRequest(httptest.NewRequest(http.MethodPut, "/v1/users/email/confirm/change", nil), mux).Code
+	}
+
+	if code := confirm(); code != http.StatusOK {
+		t.Fatalf("confirmation: got status %d, want %d", code, http.StatusOK)
+	}
+	if code := confirm(); code != http.StatusNotFound {
+		t.Fatalf("email change confirmed again: got status %d, want %d", code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/Har2yQn78/social_back.git/internal/store"
+)
+
+// blockUserHandler blocks the user in the URL. Blocking is idempotent.
+func (app *application) blockUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	blockedID, err := parseUserIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if blockedID == user.ID {
+		app.badRequestResponse(w, r, errors.New("you cannot block yourself"))
+		return
+	}
+
+	err = app.store.Blocks.Block(r.Context(), user.ID, blockedID)
+	if err != nil && !errors.Is(err, store.ErrConflict) {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+	// The follows between them are gone, so are their counts and timelines.
+	app.invalidateUser(r.Context(), user.ID)
+	app.invalidateUser(r.Context(), blockedID)
+	app.invalidateTimeline(r.Context(), user.ID)
+	app.invalidateTimeline(r.Context(), blockedID)
+
+	app.jsonResponse(w, http.StatusOK, map[string]string{"message": "user blocked"})
+}
+
+func (app *application) unblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	blockedID, err := parseUserIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.Blocks.Unblock(r.Context(), getUserFromContext(r).ID, blockedID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// muteUserHandler mutes the user in the URL. Muting is idempotent.
+func (app *application) muteUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	mutedID, err := parseUserIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if mutedID == user.ID {
+		app.badRequestResponse(w, r, errors.New("you cannot mute yourself"))
+		return
+	}
+
+	err = app.store.Blocks.Mute(r.Context(), user.ID, mutedID)
+	if err != nil && !errors.Is(err, store.ErrConflict) {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusOK, map[string]string{"message": "user muted"})
+}
+
+func (app *application) unmuteUserHandler(w http.ResponseWriter, r *http.Request) {
+	mutedID, err := parseUserIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.Blocks.Unmute(r.Context(), getUserFromContext(r).ID, mutedID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getBlockedHandler lists the users the authenticated user blocked.
+func (app *application) getBlockedHandler(w http.ResponseWriter, r *http.Request) {
+	app.listOwnUsers(w, r, app.store.Blocks.GetBlocked)
+}
+
+// getMutedHandler lists the users the authenticated user muted.
+func (app *application) getMutedHandler(w http.ResponseWriter, r *http.Request) {
+	app.listOwnUsers(w, r, app.store.Blocks.GetMuted)
+}
+
+func (app *application) listOwnUsers(w http.ResponseWriter, r *http.Request, list func(ctx context.Context, userID int64, pq store.PaginatedQuery) ([]store.PublicUser, store.Page, error)) {
+	var pq store.PaginatedQuery
+	pq.Parse(r)
+	if err := Validate.Struct(pq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	cursor, err := app.readCursor(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	pq.Cursor = cursor
+
+	users, page, err := list(r.Context(), getUserFromContext(r).ID, pq)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.paginatedResponse(w, r, http.StatusOK, users, page); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/Har2yQn78/social_back.git/internal/notifier"
+	"github.com/Har2yQn78/social_back.git/internal/store"
+)
+
+// notify hands a notification to the notifier. Failing to deliver it must not fail the request.
+func (app *application) notify(ctx context.Context, notificationType string, userID, actorID int64) {
+	n := notifier.Notification{Type: notificationType, UserID: userID, ActorID: actorID}
+	if err := app.notifier.Notify(ctx, n); err != nil {
+		app.logger.Errorw("failed to send notification", "type", notificationType, "error", err)
+	}
+}
+
+// canSeePosts reports whether the viewer may see the posts of the author: the
+// posts of private accounts are only shown to their approved followers, and
+// users who blocked each other don't see each other's posts.
+// Moderators see everything, they have to be able to moderate it.
+func (app *application) canSeePosts(ctx context.Context, viewer *store.User, authorID int64) (bool, error) {
+	if viewer.ID == authorID {
+		return true, nil
+	}
+
+	author, err := app.getUser(ctx, authorID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	blocked, err := app.store.Blocks.IsBlocked(ctx, viewer.ID, authorID)
+	if err != nil {
+		return false, err
+	}
+	if !blocked {
+		if !author.IsPrivate {
+			return true, nil
+		}
+
+		following, err := app.store.Followers.IsFollowing(ctx, authorID, viewer.ID)
+		if err != nil || following {
+			return following, err
+		}
+	}
+
+	return app.checkRolePrecedence(ctx, viewer, "moderator")
+}
+
+// getFollowRequestsHandler lists the users waiting for the approval of the authenticated user.
+func (app *application) getFollowRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	app.listOwnUsers(w, r, app.store.Followers.GetRequests)
+}
+
+func (app *application) approveFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	requesterID, err := parseUserIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.Followers.ApproveRequest(r.Context(), user.ID, requesterID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+	app.invalidateUser(r.Context(), user.ID)
+	app.invalidateUser(r.Context(), requesterID)
+	app.invalidateTimeline(r.Context(), requesterID)
+	app.notify(r.Context(), notifier.FollowApproved, requesterID, user.ID)
+
+	app.jsonResponse(w, http.StatusOK, map[string]string{"message": "follow request approved"})
+}
+
+func (app *application) rejectFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
+	requesterID, err := parseUserIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.Followers.RejectRequest(r.Context(), getUserFromContext(r).ID, requesterID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// approveAllFollowRequests lets everyone who asked follow an account that just went public.
+func (app *application) approveAllFollowRequests(ctx context.Context, userID int64) error {
+	requesterIDs, err := app.store.Followers.ApproveAllRequests(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	app.invalidateUser(ctx, userID)
+	for _, requesterID := range requesterIDs {
+		app.invalidateUser(ctx, requesterID)
+		app.invalidateTimeline(ctx, requesterID)
+		app.notify(ctx, notifier.FollowApproved, requesterID, userID)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/Har2yQn78/social_back.git/internal/notifier"
+	"github.com/Har2yQn78/social_back.git/internal/store"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// memFollowers keeps the follows and follow requests as [followed, follower] pairs.
+type memFollowers struct {
+	*store.FollowersStore
+	follows  map[[2]int64]bool
+	requests map[[2]int64]bool
+}
+
+func (s memFollowers) IsFollowing(_ context.Context, followedID, followerID int64) (bool, error) {
+	return s.follows[[2]int64{followedID, followerID}], nil
+}
+
+func (s memFollowers) Request(_ context.Context, followedID, followerID int64) error {
+	pair := [2]int64{followedID, followerID}
+	if s.follows[pair] || s.requests[pair] {
+		return store.ErrConflict
+	}
+	s.requests[pair] = true
+	return nil
+}
+
+func (s memFollowers) ApproveRequest(_ context.Context, userID, requesterID int64) error {
+	pair := [2]int64{userID, requesterID}
+	if !s.requests[pair] {
+		return store.ErrNotFound
+	}
+	delete(s.requests, pair)
+	s.follows[pair] = true
+	return nil
+}
+
+func (s memFollowers) RejectRequest(_ context.Context, userID, requesterID int64) error {
+	pair := [2]int64{userID, requesterID}
+	if !s.requests[pair] {
+		return store.ErrNotFound
+	}
+	delete(s.requests, pair)
+	return nil
+}
+
+// TestFollowRequests has bob and carol ask to follow the private account of
+// alice, who approves bob and rejects carol: only bob gets to see her posts.
+func TestFollowRequests(t *testing.T) {
+	const aliceID, bobID, carolID = 1, 2, 3
+	alice := newTestUser(aliceID, "user")
+	alice.IsPrivate = true
+
+	followers := memFollowers{follows: make(map[[2]int64]bool), requests: make(map[[2]int64]bool)}
+	app := newTestApplication(t)
+	app.notifier = notifier.NewLogNotifier(zap.NewNop().Sugar())
+	app.store.Users = fakeUsers{users: map[int64]*store.User{aliceID: alice}}
+	app.store.Followers = followers
+	app.store.Blocks = &fakeBlocks{}
+	app.store.Posts = fakePosts{}
+
+	mux := chi.NewRouter()
+	mux.Put("/v1/users/{userID}/follow", app.followUserHandler)
+	mux.Get("/v1/users/{userID}/posts", app.getUserPostsHandler)
+	mux.Put("/v1/users/me/follow-requests/{userID}", app.approveFollowRequestHandler)
+	mux.Delete("/v1/users/me/follow-requests/{userID}", app.rejectFollowRequestHandler)
+	do := func(user *store.User, method, path string) int {
+		return executeRequest(withUser(httptest.NewRequest(method, path, nil), user), mux).Code
+	}
+	alicePath := "/v1/users/" + strconv.Itoa(aliceID)
+
+	for _, id := range []int64{bobID, carolID} {
+		if code := do(newTestUser(id, "user"), http.MethodPut, alicePath+"/follow"); code != http.StatusAccepted {
+			t.Fatalf("user %d asking to follow: got status %d, want %d", id, code, http.StatusAccepted)
+		}
+		if code := do(newTestUser(id, "user"), http.MethodPut, alicePath+"/follow"); code != http.StatusConflict {
+			t.Fatalf("user %d asking twice: got status %d, want %d", id, code, http.StatusConflict)
+		}
+		if code := do(newTestUser(id, "user"), http.MethodGet, alicePath+"/posts"); code != http.StatusNotFound {
+			t.Fatalf("posts seen by user %d while waiting: got status %d, want %d", id, code, http.StatusNotFound)
+		}
+	}
+
+	if code := do(alice, http.MethodPut, "/v1/users/me/follow-requests/"+strconv.Itoa(bobID)); code != http.StatusOK {
+		t.Fatalf("approving bob: got status %d, want %d", code, http.StatusOK)
+	}
+	if code := do(alice, http.MethodDelete, "/v1/users/me/follow-requests/"+strconv.Itoa(carolID)); code != http.StatusNoContent {
+		t.Fatalf("rejecting carol: got status %d, want %d", code, http.StatusNoContent)
+	}
+
+	// The requests were answered, they can't be answered again.
+	if code := do(alice, http.MethodPut, "/v1/users/me/follow-requests/"+strconv.Itoa(carolID)); code != http.StatusNotFound {
+		t.Fatalf("approving a rejected request: got status %d, want %d", code, http.StatusNotFound)
+	}
+	if code := do(alice, http.MethodDelete, "/v1/users/me/follow-requests/"+strconv.Itoa(bobID)); code != http.StatusNotFound {
+		t.Fatalf("rejecting an approved request: got status %d, want %d", code, http.StatusNotFound)
+	}
+
+	if code := do(newTestUser(bobID, "user"), http.MethodGet, alicePath+"/posts"); code != http.StatusOK {
+		t.Fatalf("posts seen by an approved follower: got status %d, want %d", code, http.StatusOK)
+	}
+	if code := do(newTestUser(carolID, "user"), http.MethodGet, alicePath+"/posts"); code != http.StatusNotFound {
+		t.Fatalf("posts seen by a rejected user: got status %d, want %d", code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+type janitorConfig struct {
+	interval    time.Duration
+	gracePeriod time.Duration // how long never-activated accounts are kept around
+}
+
+// runJanitor periodically removes the data nobody can use anymore, until ctx is done.
+func (app *application) runJanitor(ctx context.Context) {
+	ticker := time.NewTicker(app.config.janitor.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.cleanup(ctx)
+		}
+	}
+}
+
+func (app *application) cleanup(ctx context.Context) {
+	invitations, err := app.store.Users.DeleteExpiredInvitations(ctx)
+	if err != nil {
+		app.logger.Errorw("failed to delete expired invitations", "error", err)
+	}
+
+	tokens, err := app.store.Users.DeleteExpiredTokens(ctx)
+	if err != nil {
+		app.logger.Errorw("failed to delete expired emailed tokens", "error", err)
+	}
+
+	refreshTokens, err := app.store.RefreshTokens.DeleteExpired(ctx)
+	if err != nil {
+		app.logger.Errorw("failed to delete expired refresh tokens", "error", err)
+	}
+
+	// Deleting the accounts frees their email and username for a new registration.
+	users, err := app.store.Users.DeleteUnactivated(ctx, app.config.janitor.gracePeriod)
+	if err != nil {
+		app.logger.Errorw("failed to delete unactivated users", "error", err)
+	}
+
+	states, err := app.store.Identities.DeleteExpiredStates(ctx)
+	if err != nil {
+		app.logger.Errorw("failed to delete expired login states", "error", err)
+	}
+
+	codes, err := app.store.OAuth.DeleteExpiredCodes(ctx)
+	if err != nil {
+		app.logger.Errorw("failed to delete expired authorization codes", "error", err)
+	}
+
+	sessions, err := app.store.Sessions.DeleteExpired(ctx)
+	if err != nil {
+		app.logger.Errorw("failed to delete ended sessions", "error", err)
+	}
+
+	// Deleted accounts leave for good once their grace period is over.
+	deleted, err := app.purgeAccounts(ctx)
+	if err != nil {
+		app.logger.Errorw("failed to delete accounts", "error", err)
+	}
+
+	exports, err := app.deleteExpiredExports(ctx)
+	if err != nil {
+		app.logger.Errorw("failed to delete expired data exports", "error", err)
+	}
+
+	app.logger.Infow("janitor run completed",
+		"expired_invitations", invitations,
+		"expired_emailed_tokens", tokens,
+		"expired_refresh_tokens", refreshTokens,
+		"unactivated_users", users,
+		"expired_login_states", states,
+		"expired_authorization_codes", codes,
+		"ended_sessions", sessions,
+		"deleted_accounts", deleted,
+		"expired_data_exports", exports,
+	)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Har2yQn78/social_back.git/internal/auth"
+)
+
+// jwksMaxAge is how long other services may cache our public keys.
+const jwksMaxAge = 5 * time.Minute
+
+// jwksHandler publishes the public signing keys so other services can verify our
+// tokens. The key set is written as is, without our usual data envelope, because
+// that is the format JWT libraries expect.
+func (app *application) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.authenticator.(auth.JWKSProvider)
+	if !ok {
+		app.notFoundResponse(w, r, errors.New("authenticator does not use asymmetric keys"))
+		return
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(jwksMaxAge.Seconds())))
+	if err := writeJSON(w, http.StatusOK, provider.JWKS()); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
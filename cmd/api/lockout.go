@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Har2yQn78/social_back.git/internal/ratelimiter"
+	"github.com/Har2yQn78/social_back.git/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type loginLockoutConfig struct {
+	enabled bool
+	account ratelimiter.LockoutConfig
+	ip      ratelimiter.LockoutConfig
+}
+
+// clientIP returns the address of the client, without the port. RealIP has
+// already replaced RemoteAddr when the request came through a proxy.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// The account key is derived from the email as typed, whether or not such an
+// account exists, so a lockout tells nothing about registered emails.
+func accountLockoutKey(email string) string { return "account:" + strings.ToLower(email) }
+
+func ipLockoutKey(ip string) string { return "ip:" + ip }
+
+// audit records a security event. Failing to write it must not fail the request.
+func (app *application) audit(r *http.Request, event string, userID *int64, email string) {
+	entry := &store.AuditEntry{
+		UserID:    userID,
+		Event:     event,
+		Email:     email,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	if err := app.store.Audit.Log(r.Context(), entry); err != nil {
+		app.logger.Errorw("failed to write audit entry", "event", event, "error", err)
+	}
+}
+
+// loginLockout returns how long logins for the email, or from the client, are still refused.
+func (app *application) loginLockout(ctx context.Context, email, ip string) (time.Duration, error) {
+	if !app.config.loginLockout.enabled {
+		return 0, nil
+	}
+
+	accountLock, err := app.accountLockout.Locked(ctx, accountLockoutKey(email))
+	if err != nil {
+		return 0, err
+	}
+	ipLock, err := app.clientLockout(ctx, ip)
+	if err != nil {
+		return 0, err
+	}
+
+	return max(accountLock, ipLock), nil
+}
+
+// clientLockout returns how long attempts from the client are still refused,
+// for the steps of a login that aren't tied to an email.
+func (app *application) clientLockout(ctx context.Context, ip string) (time.Duration, error) {
+	if !app.config.loginLockout.enabled {
+		return 0, nil
+	}
+
+	return app.ipLockout.Locked(ctx, ipLockoutKey(ip))
+}
+
+// clientFailed counts a failed attempt against the client only.
+func (app *application) clientFailed(r *http.Request) {
+	if !app.config.loginLockout.enabled {
+		return
+	}
+
+	if _, _, err := app.ipLockout.Fail(r.Context(), ipLockoutKey(clientIP(r))); err != nil {
+		app.logger.Errorw("failed to record failed login", "error", err)
+	}
+}
+
+// loginFailed counts a failed login against the account and the client. When
+// this locks the account, its owner gets an email with an unlock link.
+func (app *application) loginFailed(r *http.Request, email string, user *store.User) {
+	var userID *int64
+	if user != nil {
+		userID = &user.ID
+	}
+	app.audit(r, store.AuditLoginFailed, userID, email)
+
+	if !app.config.loginLockout.enabled {
+		return
+	}
+
+	app.clientFailed(r)
+	failures, lockout, err := app.accountLockout.Fail(r.Context(), accountLockoutKey(email))
+	if err != nil {
+		app.logger.Errorw("failed to record failed login", "error", err)
+		return
+	}
+	if lockout == 0 {
+		return
+	}
+	app.audit(r, store.AuditAccountLocked, userID, email)
+
+	// Only mail the first lockout of a streak, and only to an existing account.
+	if user == nil || failures != app.config.loginLockout.account.MaxAttempts {
+		return
+	}
+
+	plainToken := uuid.New().String()
+	if err := app.store.Users.CreateUnlockToken(r.Context(), user.ID, plainToken, time.Hour*24); err != nil {
+		app.logger.Errorw("failed to create unlock token", "error", err)
+		return
+	}
+	data := map[string]any{
+		"username":  user.Username,
+		"lockout":   lockout.String(),
+		"unlockURL": app.config.frontendURL + "/unlock/" + plainToken,
+	}
+	app.sendEmail(user.Email, "account_locked.tmpl", data)
+}
+
+// loginSucceeded clears the failures of the account. The client's failures are
+// kept: a single valid account must not let an attacker reset its IP counter.
+func (app *application) loginSucceeded(r *http.Request, user *store.User) {
+	app.audit(r, store.AuditLoginSucceeded, &user.ID, user.Email)
+
+	if !app.config.loginLockout.enabled {
+		return
+	}
+	if err := app.accountLockout.Reset(r.Context(), accountLockoutKey(user.Email)); err != nil {
+		app.logger.Errorw("failed to reset failed logins", "error", err)
+	}
+}
+
+// unlockAccountHandler lifts a lockout with the token emailed to the account owner.
+func (app *application) unlockAccountHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.store.Users.ConsumeUnlockToken(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.accountLockout.Reset(r.Context(), accountLockoutKey(user.Email)); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	app.audit(r, store.AuditAccountUnlocked, &user.ID, user.Email)
+
+	app.jsonResponse(w, http.StatusOK, map[string]string{"message": "account unlocked"})
+}
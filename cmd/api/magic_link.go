@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Har2yQn78/social_back.git/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type magicLinkConfig struct {
+	exp      time.Duration // lifetime of a link
+	perEmail int           // links that can be asked for one email per window
+	window   time.Duration
+}
+
+type MagicLinkPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// requestMagicLinkHandler emails a single-use link that logs the user in without a password.
+func (app *application) requestMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var payload MagicLinkPayload
+	if err := readJSON(w, r, &payload); err != nil { app.badRequestResponse(w, r, err); return }
+	if err := Validate.Struct(payload); err != nil { app.badRequestResponse(w, r, err); return }
+
+	// The limit applies to the email as typed, whether or not such an account
+	// exists, so it doesn't tell anything about registered emails either.
+	if allow, retryAfter := app.magicLinkLimiter.Allow(strings.ToLower(payload.Email)); !allow {
+		app.rateLimitExceededResponse(w, r, retryAfter.String())
+		return
+	}
+	// A link logs the user in, it must not get around a lockout of the account.
+	retryAfter, err := app.loginLockout(r.Context(), payload.Email, clientIP(r))
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.audit(r, store.AuditLoginBlocked, nil, payload.Email)
+		app.rateLimitExceededResponse(w, r, retryAfter.Round(time.Second).String())
+		return
+	}
+
+	user, err := app.store.Users.GetByEmail(r.Context(), payload.Email)
+	switch {
+	case err == nil:
+		plainToken := uuid.New().String()
+		if err := app.store.Users.CreateMagicLink(r.Context(), user.ID, plainToken, app.config.magicLink.exp); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		data := map[string]any{
+			"loginURL": app.config.frontendURL + "/magic-link/" + plainToken,
+			"username": user.Username,
+			"expiry":   app.config.magicLink.exp.String(),
+		}
+		app.sendEmail(user.Email, "magic_link.tmpl", data)
+	case errors.Is(err, store.ErrNotFound):
+		// We answer exactly as if the email was found, to avoid revealing
+		// which emails are registered in our system.
+	default:
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusAccepted, map[string]string{"message": "if an account with that email exists, a sign-in link has been sent"})
+}
+
+// consumeMagicLinkHandler trades a magic link for the same response as a password
+// login: a token pair, or an mfa token when the user has two-factor authentication.
+func (app *application) consumeMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	// Guessing links is refused like guessing passwords.
+	retryAfter, err := app.clientLockout(r.Context(), clientIP(r))
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.audit(r, store.AuditLoginBlocked, nil, "")
+		app.rateLimitExceededResponse(w, r, retryAfter.Round(time.Second).String())
+		return
+	}
+
+	userID, err := app.store.Users.ConsumeMagicLink(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.audit(r, store.AuditLoginFailed, nil, "")
+			app.clientFailed(r)
+			app.unauthorizedErrorResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	user, err := app.store.Users.GetForLogin(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.unauthorizedErrorResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// The account may have been locked out since the link was sent.
+	retryAfter, err = app.loginLockout(r.Context(), user.Email, clientIP(r))
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.audit(r, store.AuditLoginBlocked, &user.ID, user.Email)
+		app.rateLimitExceededResponse(w, r, retryAfter.Round(time.Second).String())
+		return
+	}
+	// Like after a password, the failures of users with two-factor
+	// authentication are only cleared once their code checks out.
+	if !user.TwoFactorEnabled {
+		app.loginSucceeded(r, user)
+	}
+
+	app.completeLogin(w, r, user)
+}
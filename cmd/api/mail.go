@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// emailLimitConfig limits the account emails, like activation links and
+// password resets, that anyone can have sent without being logged in.
+type emailLimitConfig struct {
+	perEmail int // emails to one address per window
+	perIP    int // emails asked for by one client per window
+	window   time.Duration
+}
+
+// allowAccountEmail reports whether an account email can be sent to the email
+// for this client, and answers the request itself when it can't. The limit
+// applies to the email as typed, whether or not such an account exists, so it
+// doesn't tell anything about registered emails.
+func (app *application) allowAccountEmail(w http.ResponseWriter, r *http.Request, email string) bool {
+	if allow, retryAfter := app.emailIPLimiter.Allow(clientIP(r)); !allow {
+		app.rateLimitExceededResponse(w, r, retryAfter.String())
+		return false
+	}
+	if allow, retryAfter := app.emailLimiter.Allow(strings.ToLower(email)); !allow {
+		app.rateLimitExceededResponse(w, r, retryAfter.String())
+		return false
+	}
+	return true
+}
+
+// sendEmail delivers a templated email in the background so the request doesn't
+// wait on the SMTP server. Failures are logged, never returned to the client.
+func (app *application) sendEmail(recipient, templateFile string, data any) {
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				app.logger.Errorw("recovered in send email goroutine", "panic", r)
+			}
+		}()
+		if err := app.mailer.Send(recipient, templateFile, data); err != nil {
+			app.logger.Errorw("failed to send email", "template", templateFile, "error", err)
+		}
+	}()
+}
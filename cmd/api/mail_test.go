@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Har2yQn78/social_back.git/internal/ratelimiter"
+	"github.com/Har2yQn78/social_back.git/internal/store"
+)
+
+// pendingUsers knows no account waiting for activation.
+type pendingUsers struct {
+	*store.UsersStore
+}
+
+func (pendingUsers) Reinvite(context.Context, string, string, time.Duration) (*store.User, error) {
+	return nil, store.ErrNotFound
+}
+
+func TestResendActivationRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	app.store.Users = pendingUsers{}
+	app.emailLimiter = ratelimiter.NewFixedWindowLimiter(2, time.Minute)
+	app.emailIPLimiter = ratelimiter.NewFixedWindowLimiter(2, time.Minute)
+
+	tests := []struct {
+		email      string
+		ip         string
+		wantStatus int
+	}{
+		{"a@example.com", "10.0.0.1:1234", http.StatusAccepted},
+		{"A@example.com", "10.0.0.2:1234", http.StatusAccepted},
+		{"a@example.com", "10.0.0.3:1234", http.StatusTooManyRequests}, // third email to the address
+		{"b@example.com", "10.0.0.1:1234", http.StatusAccepted},
+		{"c@example.com", "10.0.0.1:1234", http.StatusTooManyRequests}, // third request from the client
+		{"d@example.com", "10.0.0.4:1234", http.StatusAccepted},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/v1/users/activation/resend", strings.NewReader(`{"email":"`+tt.email+`"}`))
+		req.RemoteAddr = tt.ip
+
+		if rr := executeRequest(req, http.HandlerFunc(app.resendActivationHandler)); rr.Code != tt.wantStatus {
+			t.Fatalf("%s from %s: got status %d, want %d", tt.email, tt.ip, rr.Code, tt.wantStatus)
+		}
+	}
+}
+
+// emailChangeUsers has one user, with the password "password", and takes
+// taken@example.com for the email of another account.
+type emailChangeUsers struct {
+	*store.UsersStore
+	user *store.User
+}
+
+func (s emailChangeUsers) GetByID(context.Context, int64) (*store.User, error) { return s.user, nil }
+
+func (emailChangeUsers) CreateEmailChange(_ context.Context, _ int64, newEmail, _ string, _ time.Duration) error {
+	if newEmail == "taken@example.com" {
+		return store.ErrDuplicateEmail
+	}
+	return nil
+}
+
+func TestChangeEmailDoesNotRevealTakenEmails(t *testing.T) {
+	user := newTestUser(1, "user")
+	user.Email = "me@example.com"
+	if err := user.Password.Set("password"); err != nil {
+		t.Fatal(err)
+	}
+
+	app := newTestApplication(t)
+	app.store.Users = emailChangeUsers{user: user}
+
+	change := func(email string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/v1/users/me/email", strings.NewReader(`{"email":"`+email+`","password":"password"}`))
+		return executeRequest(withUser(req, user), http.HandlerFunc(app.changeEmailHandler))
+	}
+
+	free, taken := change("free@example.com"), change("taken@example.com")
+	if free.Code != http.StatusAccepted {
+		t.Fatalf("free email: got status %d, want %d", free.Code, http.StatusAccepted)
+	}
+	if taken.Code != free.Code || taken.Body.String() != free.Body.String() {
+		t.Fatalf("taken email answered %d %q, free email %d %q", taken.Code, taken.Body, free.Code, free.Body)
+	}
+}
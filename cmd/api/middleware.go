@@ -10,13 +10,17 @@ import (
 
 	"github.com/Har2yQn78/social_back.git/internal/store"
 	"github.com/go-chi/chi/v5"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/Har2yQn78/social_back.git/internal/auth"
 )
 
 type userKey string
 const userCtxKey userKey = "user"
 type postKey string
 const postCtxKey postKey = "post"
+type claimsKey string
+const claimsCtxKey claimsKey = "claims"
+type commentKey string
+const commentCtxKey commentKey = "comment"
 
 func (app *application) getUser(ctx context.Context, userID int64) (*store.User, error) {
 	// If caching is disabled, just go straight to the database.
@@ -54,6 +58,40 @@ func (app *application) getUser(ctx context.Context, userID int64) (*store.User,
 }
 
 
+// authenticatePersonalToken is the AuthTokenMiddleware path for personal access
+// tokens: the request gets the token's owner and is limited to its scopes.
+func (app *application) authenticatePersonalToken(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	pat, err := app.store.PersonalTokens.Authenticate(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.unauthorizedErrorResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	user, err := app.getUser(r.Context(), pat.UserID)
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), userCtxKey, user)
+	ctx = context.WithValue(ctx, scopesCtxKey, pat.Scopes)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// invalidateUser drops the cached copy of a user after their data changed.
+func (app *application) invalidateUser(ctx context.Context, userID int64) {
+	if app.config.redisCfg.enabled == false {
+		return
+	}
+	if err := app.cacheStorage.Delete(ctx, userID); err != nil {
+		app.logger.Errorw("failed to invalidate cached user", "error", err)
+	}
+}
+
 func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// 1. Get the Authorization header.
@@ -71,29 +109,42 @@ func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 		}
 
 		token := parts[1]
-		// 3. Validate the token using our authenticator.
-		jwtToken, err := app.authenticator.ValidateToken(token)
+		// Personal access tokens are opaque strings, recognized by their prefix.
+		if strings.HasPrefix(token, personalTokenPrefix) {
+			app.authenticatePersonalToken(w, r, next, token)
+			return
+		}
+
+		// 3. Validate the token and its claims using our authenticator.
+		claims, err := app.authenticator.ValidateToken(token)
 		if err != nil {
 			app.unauthorizedErrorResponse(w, r, err)
 			return
 		}
-
-		// 4. Extract the claims from the validated token.
-		claims, ok := jwtToken.Claims.(jwt.MapClaims)
-		if !ok {
-			app.unauthorizedErrorResponse(w, r, fmt.Errorf("invalid token claims"))
+		if claims.MFAPending {
+			// Tokens from the first step of a two-factor login don't grant access.
+			app.unauthorizedErrorResponse(w, r, fmt.Errorf("two-factor authentication is required"))
 			return
 		}
 
-
-		// 5. Get the user ID (the 'sub' claim) from the claims.
-		userIDStr := fmt.Sprintf("%.f", claims["sub"]) 
-		userID, err := strconv.ParseInt(userIDStr, 10, 64)
+		// 4. Get the user ID (the 'sub' claim) from the claims.
+		userID, err := claims.UserID()
 		if err != nil {
 			app.unauthorizedErrorResponse(w, r, err)
 			return
 		}
 
+		// 5. Reject tokens that were revoked on logout, with their session or by a password reset.
+		revoked, err := app.isTokenRevoked(r.Context(), claims, userID)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		if revoked {
+			app.unauthorizedErrorResponse(w, r, fmt.Errorf("token has been revoked"))
+			return
+		}
+
 		// 6. Fetch the user from the database USING OUR NEW HELPER.
         user, err := app.getUser(r.Context(), userID) // <-- THIS IS THE CHANGE
         if err != nil {
@@ -101,14 +152,63 @@ func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
             return
         }
 
-		// 7. Add the user to the request context.
+		// 7. Add the user and the token claims to the request context.
 		ctx := context.WithValue(r.Context(), userCtxKey, user)
+		ctx = context.WithValue(ctx, claimsCtxKey, claims)
+
+		// Tokens issued to third-party apps are limited to what the user still grants them.
+		if claims.ClientID != "" {
+			scopes, err := app.oauthScopes(r.Context(), claims, userID)
+			if err != nil {
+				if errors.Is(err, errGrantRevoked) {
+					app.unauthorizedErrorResponse(w, r, err)
+					return
+				}
+				app.internalServerError(w, r, err)
+				return
+			}
+			ctx = context.WithValue(ctx, scopesCtxKey, scopes)
+		}
 
 		// 8. Call the next handler in the chain, passing the modified context.
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// isTokenRevoked checks the denylist for the token itself, its session and for
+// a revocation of every token of its user.
+func (app *application) isTokenRevoked(ctx context.Context, claims *auth.Claims, userID int64) (bool, error) {
+	if claims.ID != "" {
+		revoked, err := app.denylist.IsRevoked(ctx, claims.ID)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+
+	if claims.SessionID != "" {
+		revoked, err := app.denylist.IsSessionRevoked(ctx, claims.SessionID)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+
+	if claims.IssuedAt == nil {
+		// Without an issue date we can't tell whether the token predates a revocation.
+		return true, nil
+	}
+
+	return app.denylist.IsUserRevoked(ctx, userID, claims.IssuedAt.Time)
+}
+
+// getClaimsFromContext returns the claims of the access token that authenticated the request.
+func getClaimsFromContext(r *http.Request) *auth.Claims {
+	claims, ok := r.Context().Value(claimsCtxKey).(*auth.Claims)
+	if !ok {
+		panic("token claims not found in context")
+	}
+	return claims
+}
+
 func (app *application) postsContextMiddleware(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
         idParam := chi.URLParam(r, "postID")
@@ -128,12 +228,53 @@ func (app *application) postsContextMiddleware(next http.Handler) http.Handler {
             return
         }
 
+        // The posts of a private account don't exist for those who don't follow it,
+        // nor do the posts of users who blocked each other.
+        visible, err := app.canSeePosts(r.Context(), getUserFromContext(r), post.UserID)
+        if err != nil {
+            app.internalServerError(w, r, err)
+            return
+        }
+        if !visible {
+            app.notFoundResponse(w, r, store.ErrNotFound)
+            return
+        }
+
         // Put the post in the context.
         ctx := context.WithValue(r.Context(), postCtxKey, post)
         next.ServeHTTP(w, r.WithContext(ctx))
     })
 }
 
+// commentsContextMiddleware loads the {commentID} comment of the post already in the context.
+func (app *application) commentsContextMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        id, err := strconv.ParseInt(chi.URLParam(r, "commentID"), 10, 64)
+        if err != nil {
+            app.badRequestResponse(w, r, errors.New("invalid comment ID"))
+            return
+        }
+
+        comment, err := app.store.Comments.GetByID(r.Context(), id)
+        if err != nil {
+            if errors.Is(err, store.ErrNotFound) {
+                app.notFoundResponse(w, r, err)
+                return
+            }
+            app.internalServerError(w, r, err)
+            return
+        }
+
+        // A comment is only reachable through the post it belongs to.
+        if comment.PostID != getPostFromCtx(r).ID {
+            app.notFoundResponse(w, r, store.ErrNotFound)
+            return
+        }
+
+        ctx := context.WithValue(r.Context(), commentCtxKey, comment)
+        next.ServeHTTP(w, r.WithContext(ctx))
+    })
+}
 
 func (app *application) RateLimiterMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
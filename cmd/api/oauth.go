@@ -0,0 +1,517 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Har2yQn78/social_back.git/internal/auth"
+	"github.com/Har2yQn78/social_back.git/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type oauthConfig struct {
+	codeExp  time.Duration // lifetime of authorization codes
+	tokenExp time.Duration // lifetime of the access tokens issued to apps
+}
+
+var errGrantRevoked = errors.New("access has been revoked by the user")
+
+// oauthScopes returns the scopes a third-party app's token may use: the ones it
+// was issued with that the user still grants. It fails with errGrantRevoked
+// when the user disconnected the app after the token was issued.
+func (app *application) oauthScopes(ctx context.Context, claims *auth.Claims, userID int64) ([]string, error) {
+	grant, err := app.store.OAuth.GetGrant(ctx, userID, claims.ClientID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, errGrantRevoked
+		}
+		return nil, err
+	}
+	// A token issued before the grant comes from an earlier, revoked, grant. The
+	// second of leeway is for timestamps being stored rounded to the second.
+	if claims.IssuedAt.Time.Before(grant.CreatedAt.Add(-time.Second)) {
+		return nil, errGrantRevoked
+	}
+
+	// Never nil, so requireSession keeps apps away from account management.
+	scopes := []string{}
+	for _, scope := range strings.Fields(claims.Scope) {
+		if slices.Contains(grant.Scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// parseScope splits a space separated scope parameter, rejecting unknown scopes.
+func parseScope(scope string) ([]string, error) {
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		return nil, errors.New("scope is required")
+	}
+	for _, s := range scopes {
+		if !slices.Contains(allScopes, s) {
+			return nil, fmt.Errorf("unknown scope %q", s)
+		}
+	}
+	slices.Sort(scopes)
+	return slices.Compact(scopes), nil
+}
+
+func generateOAuthSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// oauthErrorResponse answers the token, introspection and revocation endpoints
+// with an error in the format of RFC 6749, section 5.2.
+func (app *application) oauthErrorResponse(w http.ResponseWriter, r *http.Request, status int, code, description string) {
+	app.logger.Warnw("oauth error", "method", r.Method, "path", r.URL.Path, "error", code, "description", description)
+
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+// authenticateOAuthClient checks the app's credentials, sent with HTTP Basic
+// authentication or in the form body.
+func (app *application) authenticateOAuthClient(r *http.Request) (*store.OAuthClient, error) {
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if clientID == "" || secret == "" {
+		return nil, store.ErrNotFound
+	}
+
+	return app.store.OAuth.AuthenticateClient(r.Context(), clientID, secret)
+}
+
+type CreateOAuthClientPayload struct {
+	Name         string   `json:"name" validate:"required,max=100"`
+	RedirectURIs []string `json:"redirect_uris" validate:"required,min=1,max=10,dive,url,max=2048"`
+}
+
+// OAuthClientResponse includes the client secret, which is only ever shown once.
+type OAuthClientResponse struct {
+	ClientSecret string `json:"client_secret"`
+	*store.OAuthClient
+}
+
+// createOAuthClientHandler registers a third-party app owned by the user.
+func (app *application) createOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateOAuthClientPayload
+	if err := readJSON(w, r, &payload); err != nil { app.badRequestResponse(w, r, err); return }
+	if err := Validate.Struct(payload); err != nil { app.badRequestResponse(w, r, err); return }
+
+	for _, uri := range payload.RedirectURIs {
+		if u, err := url.Parse(uri); err != nil || u.Fragment != "" || u.Host == "" {
+			app.badRequestResponse(w, r, fmt.Errorf("invalid redirect uri %q", uri))
+			return
+		}
+	}
+
+	clientID, err := generateOAuthSecret()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	secret, err := generateOAuthSecret()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	client := &store.OAuthClient{
+		ClientID:     clientID[:32],
+		Name:         payload.Name,
+		RedirectURIs: payload.RedirectURIs,
+		OwnerID:      getUserFromContext(r).ID,
+	}
+	if err := app.store.OAuth.CreateClient(r.Context(), client, secret); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, OAuthClientResponse{ClientSecret: secret, OAuthClient: client}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) getOAuthClientsHandler(w http.ResponseWriter, r *http.Request) {
+	clients, err := app.store.OAuth.GetClientsByOwner(r.Context(), getUserFromContext(r).ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, clients); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) deleteOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.store.OAuth.DeleteClient(r.Context(), chi.URLParam(r, "clientID"), getUserFromContext(r).ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AuthorizationRequest holds the parameters of an authorization request, made
+// with the authorization code grant and PKCE (RFC 6749 and RFC 7636).
+type AuthorizationRequest struct {
+	ResponseType        string `json:"response_type" validate:"required,eq=code"`
+	ClientID            string `json:"client_id" validate:"required,max=64"`
+	RedirectURI         string `json:"redirect_uri" validate:"required,max=2048"`
+	Scope               string `json:"scope" validate:"required,max=255"`
+	State               string `json:"state" validate:"max=255"`
+	CodeChallenge       string `json:"code_challenge" validate:"required,len=43"`
+	CodeChallengeMethod string `json:"code_challenge_method" validate:"required,eq=S256"`
+}
+
+// ConsentResponse is what the consent screen shows the user.
+type ConsentResponse struct {
+	ClientID   string   `json:"client_id"`
+	ClientName string   `json:"client_name"`
+	Scopes     []string `json:"scopes"`
+	// Granted is true when the user already allowed all these scopes.
+	Granted bool `json:"granted"`
+}
+
+type ConsentPayload struct {
+	AuthorizationRequest
+	Approve bool `json:"approve"`
+}
+
+// checkAuthorizationRequest validates an authorization request and returns the
+// app it is for and the requested scopes. Errors must not be redirected to the
+// app, its redirect uri isn't trusted until it matched a registered one.
+func (app *application) checkAuthorizationRequest(ctx context.Context, req AuthorizationRequest) (*store.OAuthClient, []string, error) {
+	if err := Validate.Struct(req); err != nil {
+		return nil, nil, err
+	}
+
+	client, err := app.store.OAuth.GetClient(ctx, req.ClientID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, nil, errors.New("unknown client")
+		}
+		return nil, nil, err
+	}
+	if !slices.Contains(client.RedirectURIs, req.RedirectURI) {
+		return nil, nil, errors.New("redirect uri is not registered for this client")
+	}
+
+	scopes, err := parseScope(req.Scope)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return client, scopes, nil
+}
+
+// getConsentHandler describes an authorization request, so the frontend can ask
+// the user whether they allow the app to act on their behalf.
+func (app *application) getConsentHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	req := AuthorizationRequest{
+		ResponseType:        q.Get("response_type"),
+		ClientID:            q.Get("client_id"),
+		RedirectURI:         q.Get("redirect_uri"),
+		Scope:               q.Get("scope"),
+		State:               q.Get("state"),
+		CodeChallenge:       q.Get("code_challenge"),
+		CodeChallengeMethod: q.Get("code_challenge_method"),
+	}
+
+	client, scopes, err := app.checkAuthorizationRequest(r.Context(), req)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	consent := ConsentResponse{ClientID: client.ClientID, ClientName: client.Name, Scopes: scopes}
+	grant, err := app.store.OAuth.GetGrant(r.Context(), getUserFromContext(r).ID, client.ClientID)
+	switch {
+	case err == nil:
+		consent.Granted = !slices.ContainsFunc(scopes, func(s string) bool { return !slices.Contains(grant.Scopes, s) })
+	case !errors.Is(err, store.ErrNotFound):
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, consent); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// consentHandler records the user's answer to an authorization request and
+// returns the URL to send them back to the app with, carrying either an
+// authorization code or an access_denied error.
+func (app *application) consentHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ConsentPayload
+	if err := readJSON(w, r, &payload); err != nil { app.badRequestResponse(w, r, err); return }
+
+	client, scopes, err := app.checkAuthorizationRequest(r.Context(), payload.AuthorizationRequest)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	redirectURI, err := url.Parse(payload.RedirectURI)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	params := redirectURI.Query()
+	if payload.State != "" {
+		params.Set("state", payload.State)
+	}
+
+	if payload.Approve {
+		plainCode, err := generateOAuthSecret()
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		code := &store.OAuthCode{
+			ClientID:      client.ClientID,
+			UserID:        getUserFromContext(r).ID,
+			RedirectURI:   payload.RedirectURI,
+			Scopes:        scopes,
+			CodeChallenge: payload.CodeChallenge,
+		}
+		if err := app.store.OAuth.Authorize(r.Context(), code, plainCode, app.config.oauth.codeExp); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		params.Set("code", plainCode)
+	} else {
+		params.Set("error", "access_denied")
+	}
+	redirectURI.RawQuery = params.Encode()
+
+	app.jsonResponse(w, http.StatusOK, map[string]string{"redirect_url": redirectURI.String()})
+}
+
+// OAuthTokenResponse is the access token response of RFC 6749, section 5.1.
+type OAuthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+// oauthTokenHandler lets an app exchange an authorization code for an access
+// token. Like every OAuth endpoint used by apps, it takes form encoded bodies.
+func (app *application) oauthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	client, err := app.authenticateOAuthClient(r)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.oauthErrorResponse(w, r, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if grantType := r.PostFormValue("grant_type"); grantType != "authorization_code" {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "unsupported_grant_type", "only the authorization_code grant is supported")
+		return
+	}
+
+	code, err := app.store.OAuth.ConsumeCode(r.Context(), r.PostFormValue("code"))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "invalid or expired code")
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+	switch {
+	case code.ClientID != client.ClientID:
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "code was issued to another client")
+		return
+	case code.RedirectURI != r.PostFormValue("redirect_uri"):
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "redirect uri mismatch")
+		return
+	case auth.PKCEChallenge(r.PostFormValue("code_verifier")) != code.CodeChallenge:
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "code verifier mismatch")
+		return
+	}
+
+	scope := strings.Join(code.Scopes, " ")
+	claims := app.authenticator.NewClaims(code.UserID, app.config.oauth.tokenExp)
+	claims.ClientID = client.ClientID
+	claims.Scope = scope
+
+	token, err := app.authenticator.GenerateToken(claims)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, OAuthTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(app.config.oauth.tokenExp.Seconds()),
+		Scope:       scope,
+	})
+}
+
+// clientTokenClaims returns the claims of a token issued to the client, nil
+// when the token is invalid, revoked or was issued to someone else.
+func (app *application) clientTokenClaims(ctx context.Context, client *store.OAuthClient, token string) (*auth.Claims, error) {
+	claims, err := app.authenticator.ValidateToken(token)
+	if err != nil || claims.ClientID != client.ClientID {
+		return nil, nil
+	}
+	userID, err := claims.UserID()
+	if err != nil {
+		return nil, nil
+	}
+
+	revoked, err := app.isTokenRevoked(ctx, claims, userID)
+	if err != nil || revoked {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// IntrospectionResponse is the answer of RFC 7662. Only Active is set for
+// tokens that are not active.
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+// oauthIntrospectHandler tells an app whether one of its tokens is still active.
+func (app *application) oauthIntrospectHandler(w http.ResponseWriter, r *http.Request) {
+	client, err := app.authenticateOAuthClient(r)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.oauthErrorResponse(w, r, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	claims, err := app.clientTokenClaims(r.Context(), client, r.PostFormValue("token"))
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if claims == nil {
+		writeJSON(w, http.StatusOK, IntrospectionResponse{Active: false})
+		return
+	}
+
+	userID, _ := claims.UserID()
+	scopes, err := app.oauthScopes(r.Context(), claims, userID)
+	if err != nil {
+		if errors.Is(err, errGrantRevoked) {
+			writeJSON(w, http.StatusOK, IntrospectionResponse{Active: false})
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, IntrospectionResponse{
+		Active:    true,
+		Scope:     strings.Join(scopes, " "),
+		ClientID:  claims.ClientID,
+		Subject:   claims.Subject,
+		TokenType: "Bearer",
+		ExpiresAt: claims.ExpiresAt.Unix(),
+		IssuedAt:  claims.IssuedAt.Unix(),
+	})
+}
+
+// oauthRevokeHandler lets an app revoke one of its tokens (RFC 7009). Invalid
+// tokens are not an error, the answer is the same either way.
+func (app *application) oauthRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	client, err := app.authenticateOAuthClient(r)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.oauthErrorResponse(w, r, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	claims, err := app.clientTokenClaims(r.Context(), client, r.PostFormValue("token"))
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if claims != nil {
+		if err := app.denylist.Revoke(r.Context(), claims.ID, claims.ExpiresAt.Time); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// getConnectedAppsHandler lists the apps the user allowed to access their account.
+func (app *application) getConnectedAppsHandler(w http.ResponseWriter, r *http.Request) {
+	grants, err := app.store.OAuth.GetGrantsByUser(r.Context(), getUserFromContext(r).ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, grants); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// revokeConnectedAppHandler disconnects an app: the tokens it holds stop working right away.
+func (app *application) revokeConnectedAppHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.store.OAuth.DeleteGrant(r.Context(), getUserFromContext(r).ID, chi.URLParam(r, "clientID"))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
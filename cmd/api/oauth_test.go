@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Har2yQn78/social_back.git/internal/auth"
+	"github.com/Har2yQn78/social_back.git/internal/store"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeOAuth keeps the apps, codes and grants in memory. Like the store, it
+// burns a code when it's presented and reports expired codes as unknown.
+type fakeOAuth struct {
+	*store.OAuthStore
+	clients map[string]*store.OAuthClient
+	secrets map[string]string
+	codes   map[string]fakeOAuthCode
+	grants  map[string]*store.OAuthGrant // by user ID and client ID
+}
+
+type fakeOAuthCode struct {
+	code   store.OAuthCode
+	expiry time.Time
+}
+
+func newFakeOAuth() *fakeOAuth {
+	return &fakeOAuth{
+		clients: make(map[string]*store.OAuthClient),
+		secrets: make(map[string]string),
+		codes:   make(map[string]fakeOAuthCode),
+		grants:  make(map[string]*store.OAuthGrant),
+	}
+}
+
+func grantKey(userID int64, clientID string) string {
+	return fmt.Sprintf("%d/%s", userID, clientID)
+}
+
+func (s *fakeOAuth) addClient(clientID, secret string, redirectURIs ...string) {
+	s.clients[clientID] = &store.OAuthClient{ClientID: clientID, Name: clientID, RedirectURIs: redirectURIs}
+	s.secrets[clientID] = secret
+}
+
+func (s *fakeOAuth) GetClient(_ context.Context, clientID string) (*store.OAuthClient, error) {
+	client, ok := s.clients[clientID]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return client, nil
+}
+
+func (s *fakeOAuth) AuthenticateClient(ctx context.Context, clientID, secret string) (*store.OAuthClient, error) {
+	client, err := s.GetClient(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if s.secrets[clientID] != secret {
+		return nil, store.ErrNotFound
+	}
+	return client, nil
+}
+
+func (s *fakeOAuth) Authorize(_ context.Context, code *store.OAuthCode, plainCode string, exp time.Duration) error {
+	key := grantKey(code.UserID, code.ClientID)
+	if grant, ok := s.grants[key]; ok {
+		grant.Scopes = code.Scopes
+	} else {
+		s.grants[key] = &store.OAuthGrant{ClientID: code.ClientID, Scopes: code.Scopes, CreatedAt: time.Now()}
+	}
+	s.codes[plainCode] = fakeOAuthCode{code: *code, expiry: time.Now().Add(exp)}
+	return nil
+}
+
+func (s *fakeOAuth) ConsumeCode(_ context.Context, plainCode string) (*store.OAuthCode, error) {
+	c, ok := s.codes[plainCode]
+	delete(s.codes, plainCode)
+	if !ok || time.Now().After(c.expiry) {
+		return nil, store.ErrNotFound
+	}
+	return &c.code, nil
+}
+
+func (s *fakeOAuth) GetGrant(_ context.Context, userID int64, clientID string) (*store.OAuthGrant, error) {
+	grant, ok := s.grants[grantKey(userID, clientID)]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return grant, nil
+}
+
+func (s *fakeOAuth) DeleteGrant(_ context.Context, userID int64, clientID string) error {
+	key := grantKey(userID, clientID)
+	if _, ok := s.grants[key]; !ok {
+		return store.ErrNotFound
+	}
+	delete(s.grants, key)
+	return nil
+}
+
+const (
+	testRedirectURI  = "https://app.example.com/callback"
+	otherRedirectURI = "https://other.example.com/callback"
+)
+
+// newOAuthTestApplication returns an application with the apps "app" and
+// "other", whose secrets are their names.
+func newOAuthTestApplication(t *testing.T) (*application, *fakeOAuth) {
+	t.Helper()
+
+	oauth := newFakeOAuth()
+	oauth.addClient("app", "app", testRedirectURI)
+	oauth.addClient("other", "other", otherRedirectURI)
+
+	app := newTestApplication(t)
+	app.authenticator = auth.NewJWTAuthenticator("test", "test", "test", 0)
+	app.denylist = auth.NewInMemoryDenylist()
+	app.config.oauth.codeExp = time.Minute
+	app.config.oauth.tokenExp = time.Hour
+	app.store.OAuth = oauth
+
+	return app, oauth
+}
+
+// authorizationRequest is a valid request of "app" for posts:read.
+func authorizationRequest(challenge string) AuthorizationRequest {
+	return AuthorizationRequest{
+		ResponseType:        "code",
+		ClientID:            "app",
+		RedirectURI:         testRedirectURI,
+		Scope:               scopePostsRead,
+		State:               "state",
+		CodeChallenge:       challenge,
+		CodeChallengeMethod: "S256",
+	}
+}
+
+// consent answers an authorization request as user and returns the response.
+func consent(t *testing.T, app *application, user *store.User, req AuthorizationRequest) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body, err := json.Marshal(ConsentPayload{AuthorizationRequest: req, Approve: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := withUser(httptest.NewRequest(http.MethodPost, "/v1/oauth/authorize", bytes.NewReader(body)), user)
+	return executeRequest(r, http.HandlerFunc(app.consentHandler))
+}
+
+// authorize has user allow "app" and returns the authorization code and the
+// code verifier that goes with it.
+func authorize(t *testing.T, app *application, user *store.User) (code, verifier string) {
+	t.Helper()
+
+	verifier, challenge, err := auth.GeneratePKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := consent(t, app, user, authorizationRequest(challenge))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("consent: got status %d: %s", rr.Code, rr.Body)
+	}
+
+	var res struct {
+		Data struct {
+			RedirectURL string `json:"redirect_url"`
+		}
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(res.Data.RedirectURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query().Get("code"), verifier
+}
+
+// oauthPost posts a form to an app endpoint, authenticated as the client
+// whose secret is its name.
+func oauthPost(h http.HandlerFunc, clientID string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/v1/oauth/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth(clientID, clientID)
+	return executeRequest(r, h)
+}
+
+func exchangeCode(app *application, clientID, code, verifier string) *httptest.ResponseRecorder {
+	return oauthPost(app.oauthTokenHandler, clientID, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {verifier},
+	})
+}
+
+// issueOAuthToken goes through the whole flow and returns the access token of "app".
+func issueOAuthToken(t *testing.T, app *application, user *store.User) string {
+	t.Helper()
+
+	code, verifier := authorize(t, app, user)
+	rr := exchangeCode(app, "app", code, verifier)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("token exchange: got status %d: %s", rr.Code, rr.Body)
+	}
+	var res OAuthTokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	return res.AccessToken
+}
+
+func TestOAuthAuthorizeRejectsUnregisteredRedirect(t *testing.T) {
+	app, oauth := newOAuthTestApplication(t)
+	user := newTestUser(1, "user")
+
+	_, challenge, err := auth.GeneratePKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, redirectURI := range []string{"https://evil.example.com/callback", testRedirectURI + "/more", otherRedirectURI} {
+		req := authorizationRequest(challenge)
+		req.RedirectURI = redirectURI
+
+		rr := consent(t, app, user, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s: got status %d, want %d", redirectURI, rr.Code, http.StatusBadRequest)
+		}
+		if strings.Contains(rr.Body.String(), "redirect_url") {
+			t.Fatalf("%s: the error was redirected: %s", redirectURI, rr.Body)
+		}
+	}
+	if len(oauth.codes) != 0 {
+		t.Fatal("a code was issued for an unregistered redirect uri")
+	}
+}
+
+func TestOAuthTokenExchange(t *testing.T) {
+	user := newTestUser(1, "user")
+
+	tests := []struct {
+		name string
+		// exchange presents the code it's given and returns the answer.
+		exchange func(app *application, oauth *fakeOAuth, code, verifier string) *httptest.ResponseRecorder
+	}{
+		{
+			name: "code verifier mismatch",
+			exchange: func(app *application, _ *fakeOAuth, code, _ string) *httptest.ResponseRecorder {
+				otherVerifier, _, _ := auth.GeneratePKCE()
+				return exchangeCode(app, "app", code, otherVerifier)
+			},
+		},
+		{
+			name: "no code verifier",
+			exchange: func(app *application, _ *fakeOAuth, code, _ string) *httptest.ResponseRecorder {
+				return exchangeCode(app, "app", code, "")
+			},
+		},
+		{
+			name: "code replayed",
+			exchange: func(app *application, _ *fakeOAuth, code, verifier string) *httptest.ResponseRecorder {
+				if rr := exchangeCode(app, "app", code, verifier); rr.Code != http.StatusOK {
+					return rr
+				}
+				return exchangeCode(app, "app", code, verifier)
+			},
+		},
+		{
+			name: "code presented by another client",
+			exchange: func(app *application, _ *fakeOAuth, code, verifier string) *httptest.ResponseRecorder {
+				return exchangeCode(app, "other", code, verifier)
+			},
+		},
+		{
+			name: "code expired",
+			exchange: func(app *application, oauth *fakeOAuth, code, verifier string) *httptest.ResponseRecorder {
+				c := oauth.codes[code]
+				c.expiry = time.Now().Add(-time.Second)
+				oauth.codes[code] = c
+				return exchangeCode(app, "app", code, verifier)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, oauth := newOAuthTestApplication(t)
+			code, verifier := authorize(t, app, user)
+
+			rr := tt.exchange(app, oauth, code, verifier)
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, http.StatusBadRequest, rr.Body)
+			}
+			if !strings.Contains(rr.Body.String(), `"invalid_grant"`) {
+				t.Fatalf("got %s, want an invalid_grant error", rr.Body)
+			}
+
+			// The code is burnt, even by a failed exchange.
+			if rr := exchangeCode(app, "app", code, verifier); rr.Code != http.StatusBadRequest {
+				t.Fatalf("exchange after the failed one: got status %d, want %d", rr.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestOAuthScopes(t *testing.T) {
+	app, oauth := newOAuthTestApplication(t)
+	ctx := context.Background()
+	user := newTestUser(1, "user")
+
+	issuedAt := time.Now().Truncate(time.Second)
+	claims := &auth.Claims{ClientID: "app", Scope: scopePostsRead + " " + scopeUsersRead}
+	claims.IssuedAt = jwt.NewNumericDate(issuedAt)
+
+	if _, err := app.oauthScopes(ctx, claims, user.ID); err != errGrantRevoked {
+		t.Fatalf("without a grant: got %v, want errGrantRevoked", err)
+	}
+
+	key := grantKey(user.ID, "app")
+	oauth.grants[key] = &store.OAuthGrant{ClientID: "app", Scopes: []string{scopePostsRead}, CreatedAt: issuedAt.Add(-time.Hour)}
+	scopes, err := app.oauthScopes(ctx, claims, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only what the user still grants, even if the token was issued with more.
+	if len(scopes) != 1 || scopes[0] != scopePostsRead {
+		t.Fatalf("got scopes %v, want [%s]", scopes, scopePostsRead)
+	}
+
+	// The user revoked the grant, then allowed the app again: the tokens of
+	// the first grant stay revoked.
+	oauth.grants[key] = &store.OAuthGrant{ClientID: "app", Scopes: []string{scopePostsRead}, CreatedAt: issuedAt.Add(time.Minute)}
+	if _, err := app.oauthScopes(ctx, claims, user.ID); err != errGrantRevoked {
+		t.Fatalf("token of a revoked grant: got %v, want errGrantRevoked", err)
+	}
+
+	// The second of leeway for the timestamps stored rounded.
+	oauth.grants[key].CreatedAt = issuedAt.Add(500 * time.Millisecond)
+	if _, err := app.oauthScopes(ctx, claims, user.ID); err != nil {
+		t.Fatalf("token issued with the grant: %v", err)
+	}
+
+	// A token without any scope the user still grants can't be used for
+	// account management either.
+	claims.Scope = scopeUsersRead
+	scopes, err = app.oauthScopes(ctx, claims, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scopes == nil || len(scopes) != 0 {
+		t.Fatalf("got scopes %#v, want an empty slice", scopes)
+	}
+}
+
+func TestOAuthIntrospectAndRevoke(t *testing.T) {
+	app, oauth := newOAuthTestApplication(t)
+	user := newTestUser(1, "user")
+	token := issueOAuthToken(t, app, user)
+
+	introspect := func(clientID string) IntrospectionResponse {
+		t.Helper()
+		rr := oauthPost(app.oauthIntrospectHandler, clientID, url.Values{"token": {token}})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("introspection: got status %d: %s", rr.Code, rr.Body)
+		}
+		var res IntrospectionResponse
+		if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	if res := introspect("app"); !res.Active || res.ClientID != "app" || res.Scope != scopePostsRead {
+		t.Fatalf("introspection by the app: got %+v", res)
+	}
+	if res := introspect("other"); res.Active || res.ClientID != "" {
+		t.Fatalf("introspection by another client: got %+v, want an inactive token", res)
+	}
+
+	// Another client's revocation is answered the same but does nothing.
+	if rr := oauthPost(app.oauthRevokeHandler, "other", url.Values{"token": {token}}); rr.Code != http.StatusOK {
+		t.Fatalf("revocation by another client: got status %d, want %d", rr.Code, http.StatusOK)
+	}
+	if res := introspect("app"); !res.Active {
+		t.Fatal("another client revoked the token")
+	}
+
+	if rr := oauthPost(app.oauthRevokeHandler, "app", url.Values{"token": {token}}); rr.Code != http.StatusOK {
+		t.Fatalf("revocation: got status %d, want %d", rr.Code, http.StatusOK)
+	}
+	if res := introspect("app"); res.Active {
+		t.Fatal("the revoked token is still active")
+	}
+
+	// Disconnecting the app deactivates the tokens it holds.
+	token = issueOAuthToken(t, app, user)
+	if err := oauth.DeleteGrant(context.Background(), user.ID, "app"); err != nil {
+		t.Fatal(err)
+	}
+	if res := introspect("app"); res.Active {
+		t.Fatal("the token of a disconnected app is still active")
+	}
+
+	// Wrong client credentials are refused outright.
+	r := httptest.NewRequest(http.MethodPost, "/v1/oauth/introspect", strings.NewReader(url.Values{"token": {token}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth("app", "wrong")
+	if rr := executeRequest(r, http.HandlerFunc(app.oauthIntrospectHandler)); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong client secret: got status %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Har2yQn78/social_back.git/internal/auth"
+	"github.com/Har2yQn78/social_back.git/internal/env"
+	"github.com/Har2yQn78/social_back.git/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type oidcConfig struct {
+	providers []auth.OIDCConfig
+	stateExp  time.Duration // how long users have to log in at the provider
+}
+
+// oidcProvidersFromEnv reads the providers listed in OIDC_PROVIDERS, e.g.
+// "google,gitlab", each configured with OIDC_<NAME>_ISSUER, _CLIENT_ID and _CLIENT_SECRET.
+func oidcProvidersFromEnv(frontendURL string) []auth.OIDCConfig {
+	var providers []auth.OIDCConfig
+	for _, name := range strings.Split(env.GetString("OIDC_PROVIDERS", ""), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providers = append(providers, auth.OIDCConfig{
+			Name:         name,
+			Issuer:       env.GetString(prefix+"ISSUER", ""),
+			ClientID:     env.GetString(prefix+"CLIENT_ID", ""),
+			ClientSecret: env.GetString(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  oidcRedirectURL(frontendURL, name),
+		})
+	}
+	return providers
+}
+
+// Providers redirect to the frontend, which posts the code and state to oidcCallbackHandler.
+func oidcRedirectURL(frontendURL, provider string) string {
+	return frontendURL + "/oidc/" + provider + "/callback"
+}
+
+type OIDCLoginResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+type OIDCCallbackPayload struct {
+	Code  string `json:"code" validate:"required,max=2048"`
+	State string `json:"state" validate:"required,max=255"`
+}
+
+func (app *application) getProvider(w http.ResponseWriter, r *http.Request) (auth.Provider, bool) {
+	provider, ok := app.providers[chi.URLParam(r, "provider")]
+	if !ok {
+		app.notFoundResponse(w, r, errors.New("unknown identity provider"))
+	}
+	return provider, ok
+}
+
+// startOIDCLoginHandler returns the URL of the provider's login page. The
+// state and PKCE verifier bind the provider's answer to this very login.
+func (app *application) startOIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.getProvider(w, r)
+	if !ok {
+		return
+	}
+
+	state, err := auth.GenerateState()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	verifier, challenge, err := auth.GeneratePKCE()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Identities.CreateState(r.Context(), provider.Name(), state, verifier, app.config.oidc.stateExp); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	res := OIDCLoginResponse{AuthorizationURL: provider.AuthCodeURL(state, challenge)}
+	if err := app.jsonResponse(w, http.StatusOK, res); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// oidcCallbackHandler finishes a login at a provider. The identity is matched
+// with the account it's linked to, else with the account of its verified email,
+// else a new account is created. It answers like a password login.
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.getProvider(w, r)
+	if !ok {
+		return
+	}
+
+	var payload OIDCCallbackPayload
+	if err := readJSON(w, r, &payload); err != nil { app.badRequestResponse(w, r, err); return }
+	if err := Validate.Struct(payload); err != nil { app.badRequestResponse(w, r, err); return }
+
+	verifier, err := app.store.Identities.ConsumeState(r.Context(), provider.Name(), payload.State)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.unauthorizedErrorResponse(w, r, errors.New("invalid or expired state"))
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	accessToken, err := provider.Exchange(r.Context(), payload.Code, verifier)
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+	identity, err := provider.UserInfo(r.Context(), accessToken)
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+
+	userID, err := app.identityUserID(r.Context(), provider.Name(), identity)
+	if err != nil {
+		switch {
+		case errors.Is(err, errEmailNotVerified):
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.store.Users.GetForLogin(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.unauthorizedErrorResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+	// Like after a password, the failures of users with two-factor
+	// authentication are only cleared once their code checks out.
+	if !user.TwoFactorEnabled {
+		app.loginSucceeded(r, user)
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+var errEmailNotVerified = errors.New("the identity provider has not verified this email")
+
+// identityUserID returns the user the identity logs in, linking or creating it when needed.
+func (app *application) identityUserID(ctx context.Context, provider string, identity *auth.Identity) (int64, error) {
+	userID, err := app.store.Identities.GetUserID(ctx, provider, identity.Subject)
+	if !errors.Is(err, store.ErrNotFound) {
+		return userID, err
+	}
+
+	// Only a verified email proves the identity belongs to the owner of the account.
+	if identity.Email == "" || !identity.EmailVerified {
+		return 0, errEmailNotVerified
+	}
+
+	userID, err = app.store.Identities.Link(ctx, provider, identity.Subject, identity.Email)
+	switch {
+	case errors.Is(err, store.ErrConflict):
+		// Another login with the same identity linked it first.
+		return app.store.Identities.GetUserID(ctx, provider, identity.Subject)
+	case !errors.Is(err, store.ErrNotFound):
+		return userID, err
+	}
+
+	user, err := app.createIdentityUser(ctx, provider, identity)
+	if err != nil {
+		return 0, err
+	}
+	return user.ID, nil
+}
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+// createIdentityUser creates the account of someone who signed up through a
+// provider. The username is derived from the identity and suffixed when taken.
+// The password is random: it can be set later with a password reset.
+func (app *application) createIdentityUser(ctx context.Context, provider string, identity *auth.Identity) (*store.User, error) {
+	base := identity.Username
+	if base == "" {
+		base, _, _ = strings.Cut(identity.Email, "@")
+	}
+	base = usernameInvalidChars.ReplaceAllString(strings.ToLower(base), "")
+	if len(base) > 90 {
+		base = base[:90]
+	}
+	if base == "" {
+		base = provider
+	}
+
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return nil, err
+	}
+	user := &store.User{Email: identity.Email}
+	if err := user.Password.Set(hex.EncodeToString(password)); err != nil {
+		return nil, err
+	}
+
+	const attempts = 3
+	for i := range attempts {
+		user.Username = base
+		if i > 0 {
+			suffix := make([]byte, 3)
+			if _, err := rand.Read(suffix); err != nil {
+				return nil, err
+			}
+			user.Username = base + "-" + hex.EncodeToString(suffix)
+		}
+
+		err := app.store.Identities.CreateUser(ctx, user, provider, identity.Subject)
+		if !errors.Is(err, store.ErrDuplicateUsername) {
+			return user, err
+		}
+	}
+
+	return nil, store.ErrDuplicateUsername
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Har2yQn78/social_back.git/internal/auth"
+	"github.com/google/uuid"
+)
+
+const (
+	fakeOIDCClientID     = "oidctest-client"
+	fakeOIDCClientSecret = "oidctest-secret"
+)
+
+// fakeOIDCServer is a local OpenID Connect provider that logs in, without
+// asking anything, whatever identity was last set with SetIdentity, so the
+// social login flow can be exercised without a network or a real account.
+type fakeOIDCServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	identity auth.Identity
+	codes    map[string]grant         // authorization codes not exchanged yet
+	tokens   map[string]auth.Identity // access tokens issued
+}
+
+type grant struct {
+	identity      auth.Identity
+	redirectURI   string
+	codeChallenge string
+}
+
+// newFakeOIDCServer starts a fake provider, closed when the test ends.
+func newFakeOIDCServer(t *testing.T, identity auth.Identity) *fakeOIDCServer {
+	t.Helper()
+
+	s := &fakeOIDCServer{
+		identity: identity,
+		codes:    make(map[string]grant),
+		tokens:   make(map[string]auth.Identity),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("GET /authorize", s.authorize)
+	mux.HandleFunc("POST /token", s.token)
+	mux.HandleFunc("GET /userinfo", s.userInfo)
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// Issuer is the URL to configure the provider with.
+func (s *fakeOIDCServer) Issuer() string { return s.URL }
+
+// SetIdentity changes the identity the next logins are made with.
+func (s *fakeOIDCServer) SetIdentity(identity auth.Identity) {
+	s.mu.Lock()
+	s.identity = identity
+	s.mu.Unlock()
+}
+
+// Authorize plays the user's browser: it follows the authorization URL and
+// returns the code and state the provider redirected back with.
+func (s *fakeOIDCServer) Authorize(authURL string) (code, state string, err error) {
+	client := *s.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	res, err := client.Get(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		return "", "", fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		return "", "", err
+	}
+	return location.Query().Get("code"), location.Query().Get("state"), nil
+}
+
+func (s *fakeOIDCServer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeProviderJSON(w, http.StatusOK, map[string]any{
+		"issuer":                           s.URL,
+		"authorization_endpoint":           s.URL + "/authorize",
+		"token_endpoint":                   s.URL + "/token",
+		"userinfo_endpoint":                s.URL + "/userinfo",
+		"response_types_supported":         []string{"code"},
+		"code_challenge_methods_supported": []string{"S256"},
+	})
+}
+
+func (s *fakeOIDCServer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != fakeOIDCClientID || q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirectURI.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := uuid.New().String()
+	s.mu.Lock()
+	s.codes[code] = grant{identity: s.identity, redirectURI: q.Get("redirect_uri"), codeChallenge: q.Get("code_challenge")}
+	s.mu.Unlock()
+
+	v := redirectURI.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	redirectURI.RawQuery = v.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (s *fakeOIDCServer) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	clientID, _ = url.QueryUnescape(clientID)
+	clientSecret, _ = url.QueryUnescape(clientSecret)
+	if clientID != fakeOIDCClientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(fakeOIDCClientSecret)) != 1 {
+		writeProviderJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	// Codes are single-use, even when the exchange fails.
+	code := r.PostFormValue("code")
+	s.mu.Lock()
+	g, ok := s.codes[code]
+	delete(s.codes, code)
+	s.mu.Unlock()
+
+	if err := checkGrant(g, ok, r); err != nil {
+		writeProviderJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": err.Error()})
+		return
+	}
+
+	accessToken := uuid.New().String()
+	s.mu.Lock()
+	s.tokens[accessToken] = g.identity
+	s.mu.Unlock()
+
+	writeProviderJSON(w, http.StatusOK, map[string]any{"access_token": accessToken, "token_type": "Bearer", "expires_in": 3600})
+}
+
+func checkGrant(g grant, ok bool, r *http.Request) error {
+	switch {
+	case r.PostFormValue("grant_type") != "authorization_code":
+		return errors.New("unsupported grant type")
+	case !ok:
+		return errors.New("unknown code")
+	case r.PostFormValue("redirect_uri") != g.redirectURI:
+		return errors.New("redirect_uri mismatch")
+	case auth.PKCEChallenge(r.PostFormValue("code_verifier")) != g.codeChallenge:
+		return errors.New("code verifier mismatch")
+	}
+	return nil
+}
+
+func (s *fakeOIDCServer) userInfo(w http.ResponseWriter, r *http.Request) {
+	accessToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	s.mu.Lock()
+	identity, found := s.tokens[accessToken]
+	s.mu.Unlock()
+
+	if !ok || !found {
+		writeProviderJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		return
+	}
+
+	writeProviderJSON(w, http.StatusOK, identity)
+}
+
+func writeProviderJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
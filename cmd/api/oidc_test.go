@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Har2yQn78/social_back.git/internal/auth"
+	"github.com/Har2yQn78/social_back.git/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// fakeIdentities keeps the identities and the login states in memory, and
+// links and creates users in the users of a fakeUsers.
+type fakeIdentities struct {
+	users  map[int64]*store.User
+	states map[string][2]string // provider and code verifier by state
+	links  map[string]int64     // user IDs by provider and subject
+	nextID int64
+}
+
+func newFakeIdentities(users map[int64]*store.User) *fakeIdentities {
+	return &fakeIdentities{users: users, states: make(map[string][2]string), links: make(map[string]int64), nextID: 100}
+}
+
+func (s *fakeIdentities) CreateState(_ context.Context, provider, state, codeVerifier string, _ time.Duration) error {
+	s.states[state] = [2]string{provider, codeVerifier}
+	return nil
+}
+
+func (s *fakeIdentities) ConsumeState(_ context.Context, provider, state string) (string, error) {
+	st, ok := s.states[state]
+	delete(s.states, state)
+	if !ok || st[0] != provider {
+		return "", store.ErrNotFound
+	}
+	return st[1], nil
+}
+
+func (s *fakeIdentities) GetUserID(_ context.Context, provider, subject string) (int64, error) {
+	userID, ok := s.links[provider+"/"+subject]
+	if !ok || !s.users[userID].IsActive {
+		return 0, store.ErrNotFound
+	}
+	return userID, nil
+}
+
+// Link behaves like the store's: accounts never activated are dropped, only an
+// active account is linked.
+func (s *fakeIdentities) Link(_ context.Context, provider, subject, email string) (int64, error) {
+	for id, user := range s.users {
+		if strings.EqualFold(user.Email, email) && !user.IsActive {
+			delete(s.users, id)
+		}
+	}
+	for id, user := range s.users {
+		if strings.EqualFold(user.Email, email) {
+			s.links[provider+"/"+subject] = id
+			return id, nil
+		}
+	}
+	return 0, store.ErrNotFound
+}
+
+func (s *fakeIdentities) CreateUser(_ context.Context, user *store.User, provider, subject string) error {
+	s.nextID++
+	user.ID = s.nextID
+	user.IsActive = true
+	s.users[user.ID] = user
+	s.links[provider+"/"+subject] = user.ID
+	return nil
+}
+
+func (s *fakeIdentities) DeleteExpiredStates(context.Context) (int64, error) { return 0, nil }
+
+func TestOIDCLogin(t *testing.T) {
+	const linkedID, activeID, pendingID = 1, 2, 3
+
+	tests := []struct {
+		name       string
+		identity   auth.Identity
+		wantStatus int
+		wantUserID int64 // 0 for a new user
+	}{
+		{
+			name:       "new user",
+			identity:   auth.Identity{Subject: "new", Email: "new@example.com", EmailVerified: true, Username: "New.User"},
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "linked identity",
+			identity:   auth.Identity{Subject: "linked", Email: "changed@example.com", EmailVerified: true},
+			wantStatus: http.StatusCreated,
+			wantUserID: linkedID,
+		},
+		{
+			name:       "email of an active account",
+			identity:   auth.Identity{Subject: "active", Email: "active@example.com", EmailVerified: true},
+			wantStatus: http.StatusCreated,
+			wantUserID: activeID,
+		},
+		{
+			name:       "email of an account never activated",
+			identity:   auth.Identity{Subject: "pending", Email: "pending@example.com", EmailVerified: true},
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "unverified email",
+			identity:   auth.Identity{Subject: "unverified", Email: "active@example.com"},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := map[int64]*store.User{
+				linkedID:  {ID: linkedID, Username: "linked", Email: "linked@example.com", IsActive: true},
+				activeID:  {ID: activeID, Username: "active", Email: "active@example.com", IsActive: true},
+				pendingID: {ID: pendingID, Username: "squatter", Email: "pending@example.com"},
+			}
+			identities := newFakeIdentities(users)
+			identities.links["fake/linked"] = linkedID
+
+			app, mux, provider := newOIDCTestApplication(t, tt.identity)
+			app.store.Users = fakeUsers{users: users}
+			app.store.Identities = identities
+
+			rr := oidcLogin(t, mux, provider, "")
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			if rr.Code != http.StatusCreated {
+				return
+			}
+
+			var res struct{ Data TokenResponse }
+			if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+			claims, err := app.authenticator.ValidateToken(res.Data.Token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			userID, err := claims.UserID()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			switch {
+			case tt.wantUserID != 0 && userID != tt.wantUserID:
+				t.Fatalf("logged in as user %d, want %d", userID, tt.wantUserID)
+			case tt.wantUserID == 0 && userID <= pendingID:
+				t.Fatalf("logged in as the existing user %d, want a new user", userID)
+			}
+			if _, ok := users[pendingID]; tt.identity.Subject == "pending" && ok {
+				t.Fatal("the account never activated was kept")
+			}
+		})
+	}
+}
+
+func TestOIDCLoginRejectsAnotherState(t *testing.T) {
+	app, mux, provider := newOIDCTestApplication(t, auth.Identity{Subject: "new", Email: "new@example.com", EmailVerified: true})
+	users := map[int64]*store.User{}
+	app.store.Users = fakeUsers{users: users}
+	app.store.Identities = newFakeIdentities(users)
+
+	if rr := oidcLogin(t, mux, provider, "forged-state"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("got status %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+	if len(users) != 0 {
+		t.Fatal("a user was created")
+	}
+}
+
+// newOIDCTestApplication returns an application with a fake provider named
+// "fake" logging in with identity, and a router with the OIDC login routes.
+func newOIDCTestApplication(t *testing.T, identity auth.Identity) (*application, http.Handler, *fakeOIDCServer) {
+	t.Helper()
+
+	server := newFakeOIDCServer(t, identity)
+	provider, err := auth.NewOIDCProvider(context.Background(), auth.OIDCConfig{
+		Name:         "fake",
+		Issuer:       server.Issuer(),
+		ClientID:     fakeOIDCClientID,
+		ClientSecret: fakeOIDCClientSecret,
+		RedirectURL:  oidcRedirectURL("http://frontend.test", "fake"),
+	}, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := newTestApplication(t)
+	app.authenticator = auth.NewJWTAuthenticator("test", "test", "test", 0)
+	app.providers = map[string]auth.Provider{provider.Name(): provider}
+	app.config.oidc.stateExp = time.Minute
+	app.config.auth.token.exp = time.Minute
+	app.store.Sessions = fakeSessions{}
+	app.store.RefreshTokens = fakeRefreshTokens{}
+
+	mux := chi.NewRouter()
+	mux.Get("/v1/authentication/oidc/{provider}", app.startOIDCLoginHandler)
+	mux.Post("/v1/authentication/oidc/{provider}/callback", app.oidcCallbackHandler)
+
+	return app, mux, server
+}
+
+// oidcLogin goes through a login at the fake provider, like the frontend
+// would, and returns the answer of the callback. A non-empty state replaces
+// the one the provider redirected back with.
+func oidcLogin(t *testing.T, mux http.Handler, provider *fakeOIDCServer, state string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rr := executeRequest(httptest.NewRequest(http.MethodGet, "/v1/authentication/oidc/fake", nil), mux)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("starting the login: got status %d: %s", rr.Code, rr.Body)
+	}
+	var start struct{ Data OIDCLoginResponse }
+	if err := json.NewDecoder(rr.Body).Decode(&start); err != nil {
+		t.Fatal(err)
+	}
+
+	code, providerState, err := provider.Authorize(start.Data.AuthorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state == "" {
+		state = providerState
+	}
+
+	body, err := json.Marshal(OIDCCallbackPayload{Code: code, State: state})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/v1/authentication/oidc/fake/callback", strings.NewReader(string(body)))
+	return executeRequest(req, mux)
+}
+
+// failureCounter counts the failures of each key, without ever locking one out.
+type failureCounter map[string]int
+
+func (c failureCounter) Locked(context.Context, string) (time.Duration, error) { return 0, nil }
+
+func (c failureCounter) Fail(_ context.Context, key string) (int, time.Duration, error) {
+	c[key]++
+	return c[key], 0, nil
+}
+
+func (c failureCounter) Reset(_ context.Context, key string) error {
+	delete(c, key)
+	return nil
+}
+
+func TestOIDCLoginKeepsFailuresUntilSecondFactor(t *testing.T) {
+	for _, twoFactor := range []bool{false, true} {
+		user := &store.User{ID: 1, Username: "alice", Email: "alice@example.com", IsActive: true, TwoFactorEnabled: twoFactor}
+		users := map[int64]*store.User{user.ID: user}
+		identities := newFakeIdentities(users)
+		identities.links["fake/alice"] = user.ID
+
+		app, mux, provider := newOIDCTestApplication(t, auth.Identity{Subject: "alice", Email: user.Email, EmailVerified: true})
+		app.store.Users = fakeUsers{users: users}
+		app.store.Identities = identities
+		failures := failureCounter{accountLockoutKey(user.Email): 3}
+		app.config.loginLockout.enabled = true
+		app.accountLockout = failures
+
+		if rr := oidcLogin(t, mux, provider, ""); rr.Code >= http.StatusBadRequest {
+			t.Fatalf("two-factor %v: got status %d: %s", twoFactor, rr.Code, rr.Body)
+		}
+		if got := failures[accountLockoutKey(user.Email)]; twoFactor && got != 3 {
+			t.Fatalf("failures were cleared before the second factor: got %d, want 3", got)
+		} else if !twoFactor && got != 0 {
+			t.Fatalf("failures weren't cleared by the login: got %d, want 0", got)
+		}
+	}
+}
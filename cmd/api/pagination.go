@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Har2yQn78/social_back.git/internal/store"
+)
+
+// readCursor decodes the cursor query parameter, nil when there is none. A list
+// is read either with cursors or with offsets, not both. Cursors are only
+// valid for the list they were issued for, see cursorScope.
+func (app *application) readCursor(r *http.Request) (*store.Cursor, error) {
+	qs := r.URL.Query()
+
+	if !qs.Has("cursor") {
+		return nil, nil
+	}
+	if qs.Get("offset") != "" {
+		return nil, errors.New("cursor and offset can't be used together")
+	}
+	token := qs.Get("cursor")
+	if token == "" {
+		return nil, nil
+	}
+
+	return app.cursors.Decode(cursorScope(r), token)
+}
+
+// cursorScope is what a cursor is bound to: the path and the filters of the
+// list, so that it can't be replayed on another list, or with another sort or
+// search where it would skip or repeat rows. Only the size of the pages may
+// change from one page to the next.
+func cursorScope(r *http.Request) string {
+	qs := r.URL.Query()
+	for key, values := range qs {
+		if key == "cursor" || key == "offset" || key == "limit" || strings.Join(values, "") == "" {
+			qs.Del(key)
+		}
+	}
+	return r.URL.Path + "?" + qs.Encode()
+}
+
+// paginatedResponse sends a page of a list that can be read with offsets or
+// cursors. Lists read with offsets are sent as a plain array, like they always
+// were. Passing the cursor parameter, empty for the first page, asks for
+// cursors instead, see cursorResponse.
+func (app *application) paginatedResponse(w http.ResponseWriter, r *http.Request, status int, data any, page store.Page) error {
+	if !r.URL.Query().Has("cursor") {
+		return writeJSON(w, status, data)
+	}
+	return app.cursorResponse(w, r, status, data, page)
+}
+
+// cursorResponse sends a page of a list with the cursors of the pages around
+// it, {"data": ..., "next_cursor": ..., "prev_cursor": ...}, and links to these
+// pages in the Link header.
+func (app *application) cursorResponse(w http.ResponseWriter, r *http.Request, status int, data any, page store.Page) error {
+	type envelope struct {
+		Data       any    `json:"data"`
+		NextCursor string `json:"next_cursor,omitempty"`
+		PrevCursor string `json:"prev_cursor,omitempty"`
+	}
+	env := envelope{Data: data}
+
+	var links []string
+	if page.Next != nil {
+		env.NextCursor = app.cursors.Encode(cursorScope(r), *page.Next)
+		links = append(links, `<`+app.pageURL(r, env.NextCursor)+`>; rel="next"`)
+	}
+	if page.Prev != nil {
+		env.PrevCursor = app.cursors.Encode(cursorScope(r), *page.Prev)
+		links = append(links, `<`+app.pageURL(r, env.PrevCursor)+`>; rel="prev"`)
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	return writeJSON(w, status, &env)
+}
+
+// pageURL is the URL of the request, reading the page at cursor.
+func (app *application) pageURL(r *http.Request, cursor string) string {
+	qs := r.URL.Query()
+	qs.Del("offset")
+	qs.Set("cursor", cursor)
+
+	return app.config.apiURL + r.URL.Path + "?" + qs.Encode()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Har2yQn78/social_back.git/internal/store"
+)
+
+func TestPaginatedResponse(t *testing.T) {
+	app := newTestApplication(t)
+	page := store.Page{Next: &store.Cursor{CreatedAt: "2024-05-01T10:00:00Z", ID: 42}}
+	send := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		if err := app.paginatedResponse(rr, httptest.NewRequest(http.MethodGet, target, nil), http.StatusOK, []int{1, 2}, page); err != nil {
+			t.Fatal(err)
+		}
+		return rr
+	}
+
+	t.Run("offsets", func(t *testing.T) {
+		rr := send("/v1/users/1/followers?offset=20")
+
+		var data []int
+		if err := json.Unmarshal(rr.Body.Bytes(), &data); err != nil {
+			t.Fatalf("the page is not a plain array: %s", rr.Body)
+		}
+		if rr.Header().Get("Link") != "" {
+			t.Fatal("a Link header was sent for a list read with offsets")
+		}
+	})
+
+	t.Run("cursors", func(t *testing.T) {
+		rr := send("/v1/users/1/followers?cursor=")
+
+		var env struct {
+			Data       []int  `json:"data"`
+			NextCursor string `json:"next_cursor"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &env); err != nil {
+			t.Fatal(err)
+		}
+		if len(env.Data) != 2 || env.NextCursor == "" {
+			t.Fatalf("unexpected page %s", rr.Body)
+		}
+		if rr.Header().Get("Link") == "" {
+			t.Fatal("no Link header was sent")
+		}
+
+		cur, err := app.readCursor(httptest.NewRequest(http.MethodGet, "/v1/users/1/followers?cursor="+env.NextCursor, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *cur != *page.Next {
+			t.Fatalf("readCursor() = %+v, want %+v", *cur, *page.Next)
+		}
+		if _, err := app.readCursor(httptest.NewRequest(http.MethodGet, "/v1/users/2/followers?cursor="+env.NextCursor, nil)); err == nil {
+			t.Fatal("a cursor was accepted on another list")
+		}
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		rr := send("/v1/users/feed?cursor=&sort=asc&tags=go&limit=10")
+
+		var env struct {
+			NextCursor string `json:"next_cursor"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &env); err != nil {
+			t.Fatal(err)
+		}
+
+		// The order of the parameters and the size of the pages don't matter.
+		for _, query := range []string{"sort=asc&tags=go", "tags=go&limit=20&sort=asc", "sort=asc&tags=go&search="} {
+			if _, err := app.readCursor(httptest.NewRequest(http.MethodGet, "/v1/users/feed?"+query+"&cursor="+env.NextCursor, nil)); err != nil {
+				t.Fatalf("%s: %v", query, err)
+			}
+		}
+		for _, query := range []string{"sort=desc&tags=go", "sort=asc&tags=rust", "sort=asc&tags=go&search=gopher", "sort=asc"} {
+			if _, err := app.readCursor(httptest.NewRequest(http.MethodGet, "/v1/users/feed?"+query+"&cursor="+env.NextCursor, nil)); err == nil {
+				t.Fatalf("%s: a cursor was accepted with other filters", query)
+			}
+		}
+	})
+
+	t.Run("cursor and offset", func(t *testing.T) {
+		if _, err := app.readCursor(httptest.NewRequest(http.MethodGet, "/v1/users/1/followers?cursor=&offset=20", nil)); err == nil {
+			t.Fatal("a cursor and an offset were accepted together")
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/Har2yQn78/social_back.git/internal/store"
+)
+
+// checkPermission lets the request through when the user owns the resource, or
+// when their role is at least as high as requiredRole. owner returns the ID of
+// the resource's owner, typically from an object put in the context by a middleware.
+func (app *application) checkPermission(requiredRole string, owner func(*http.Request) int64, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := getUserFromContext(r)
+
+		// Owners always keep their rights on what they created.
+		if owner(r) == user.ID {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, err := app.checkRolePrecedence(r.Context(), user, requiredRole)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		if !allowed {
+			app.forbiddenResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// RequireRole only lets users whose role is at least roleName through.
+func (app *application) RequireRole(roleName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed, err := app.checkRolePrecedence(r.Context(), getUserFromContext(r), roleName)
+			if err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+			if !allowed {
+				app.forbiddenResponse(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// checkRolePrecedence reports whether the user's role level reaches the one of roleName.
+func (app *application) checkRolePrecedence(ctx context.Context, user *store.User, roleName string) (bool, error) {
+	role, err := app.store.Roles.GetByName(ctx, roleName)
+	if err != nil {
+		return false, err
+	}
+
+	return user.Role.Level >= role.Level, nil
+}
+
+func postOwner(r *http.Request) int64 { return getPostFromCtx(r).UserID }
+
+func commentOwner(r *http.Request) int64 { return getCommentFromCtx(r).UserID }
+
+type UpdateUserRolePayload struct {
+	Role string `json:"role" validate:"required,max=255"`
+}
+
+// updateUserRoleHandler lets admins promote or demote other users.
+func (app *application) updateUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := parseUserIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if userID == getUserFromContext(r).ID {
+		app.badRequestResponse(w, r, errors.New("you cannot change your own role"))
+		return
+	}
+
+	var payload UpdateUserRolePayload
+	if err := readJSON(w, r, &payload); err != nil { app.badRequestResponse(w, r, err); return }
+	if err := Validate.Struct(payload); err != nil { app.badRequestResponse(w, r, err); return }
+
+	role, err := app.store.Roles.GetByName(r.Context(), payload.Role)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.badRequestResponse(w, r, errors.New("unknown role"))
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Users.SetRole(r.Context(), userID, role.ID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+	app.invalidateUser(r.Context(), userID)
+
+	app.jsonResponse(w, http.StatusOK, role)
+}
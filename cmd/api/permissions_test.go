@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/Har2yQn78/social_back.git/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestCheckPermission(t *testing.T) {
+	app := newTestApplication(t)
+	const ownerID = 1
+
+	tests := []struct {
+		name         string
+		user         *store.User
+		requiredRole string
+		wantStatus   int
+	}{
+		{"owner", newTestUser(ownerID, "user"), "moderator", http.StatusOK},
+		{"another user", newTestUser(2, "user"), "moderator", http.StatusForbidden},
+		{"moderator", newTestUser(2, "moderator"), "moderator", http.StatusOK},
+		{"admin", newTestUser(2, "admin"), "moderator", http.StatusOK},
+		{"moderator on an admin resource", newTestUser(2, "moderator"), "admin", http.StatusForbidden},
+		{"owner on an admin resource", newTestUser(ownerID, "user"), "admin", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owner := func(*http.Request) int64 { return ownerID }
+			handler := app.checkPermission(tt.requiredRole, owner, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := withUser(httptest.NewRequest(http.MethodPatch, "/v1/posts/1", nil), tt.user)
+			if rr := executeRequest(req, handler); rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rr.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		role       string
+		wantStatus int
+	}{
+		{"user", http.StatusForbidden},
+		{"moderator", http.StatusForbidden},
+		{"admin", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			handler := app.RequireRole("admin")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := withUser(httptest.NewRequest(http.MethodPut, "/v1/users/2/role", nil), newTestUser(1, tt.role))
+			if rr := executeRequest(req, handler); rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rr.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestGetUserPostsVisibility checks who sees the posts of a profile, and who
+// it follows or is followed by: blocks hide them either way and win over
+// follows, private accounts only show them to their followers, mutes only
+// filter feeds, and moderators see everything.
+func TestGetUserPostsVisibility(t *testing.T) {
+	const viewerID, authorID = 1, 2
+
+	tests := []struct {
+		name       string
+		viewerRole string
+		private    bool
+		following  bool
+		blocks     [][2]int64
+		mutes      [][2]int64
+		wantStatus int
+	}{
+		{name: "public", viewerRole: "user", wantStatus: http.StatusOK},
+		{name: "public, muted", viewerRole: "user", mutes: [][2]int64{{viewerID, authorID}}, wantStatus: http.StatusOK},
+		{name: "public, blocked by the author", viewerRole: "user", blocks: [][2]int64{{authorID, viewerID}}, wantStatus: http.StatusNotFound},
+		{name: "public, author blocked", viewerRole: "user", blocks: [][2]int64{{viewerID, authorID}}, wantStatus: http.StatusNotFound},
+		{name: "private", viewerRole: "user", private: true, wantStatus: http.StatusNotFound},
+		{name: "private, following", viewerRole: "user", private: true, following: true, wantStatus: http.StatusOK},
+		{name: "private, following and muted", viewerRole: "user", private: true, following: true, mutes: [][2]int64{{viewerID, authorID}}, wantStatus: http.StatusOK},
+		{name: "private, following but blocked", viewerRole: "user", private: true, following: true, blocks: [][2]int64{{authorID, viewerID}}, wantStatus: http.StatusNotFound},
+		{name: "private, moderator", viewerRole: "moderator", private: true, wantStatus: http.StatusOK},
+		{name: "blocked, moderator", viewerRole: "moderator", blocks: [][2]int64{{authorID, viewerID}}, wantStatus: http.StatusOK},
+		{name: "private and blocked, admin", viewerRole: "admin", private: true, blocks: [][2]int64{{authorID, viewerID}}, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			author := newTestUser(authorID, "user")
+			author.IsPrivate = tt.private
+
+			followers := fakeFollowers{}
+			if tt.following {
+				followers.follows = [][2]int64{{authorID, viewerID}}
+			}
+
+			app := newTestApplication(t)
+			app.store.Users = fakeUsers{users: map[int64]*store.User{authorID: author}}
+			app.store.Followers = followers
+			app.store.Blocks = &fakeBlocks{blocks: tt.blocks, mutes: tt.mutes}
+			app.store.Posts = fakePosts{}
+
+			mux := chi.NewRouter()
+			mux.Get("/v1/users/{userID}/posts", app.getUserPostsHandler)
+			mux.Get("/v1/users/{userID}/followers", app.getFollowersHandler)
+			mux.Get("/v1/users/{userID}/following", app.getFollowingHandler)
+
+			for _, list := range []string{"posts", "followers", "following"} {
+				req := httptest.NewRequest(http.MethodGet, "/v1/users/"+strconv.Itoa(authorID)+"/"+list, nil)
+				rr := executeRequest(withUser(req, newTestUser(viewerID, tt.viewerRole)), mux)
+				if rr.Code != tt.wantStatus {
+					t.Fatalf("%s: got status %d, want %d: %s", list, rr.Code, tt.wantStatus, rr.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestBlockAndMuteHandlers(t *testing.T) {
+	const userID = 1
+
+	tests := []struct {
+		name       string
+		path       string
+		handler    func(*application) http.HandlerFunc
+		targetID   string
+		wantStatus int
+		wantBlocks int
+		wantMutes  int
+	}{
+		{"block", "/block", func(app *application) http.HandlerFunc { return app.blockUserHandler }, "2", http.StatusOK, 1, 0},
+		{"block yourself", "/block", func(app *application) http.HandlerFunc { return app.blockUserHandler }, "1", http.StatusBadRequest, 0, 0},
+		{"block an invalid ID", "/block", func(app *application) http.HandlerFunc { return app.blockUserHandler }, "me", http.StatusBadRequest, 0, 0},
+		{"mute", "/mute", func(app *application) http.HandlerFunc { return app.muteUserHandler }, "2", http.StatusOK, 0, 1},
+		{"mute yourself", "/mute", func(app *application) http.HandlerFunc { return app.muteUserHandler }, "1", http.StatusBadRequest, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blocks := &fakeBlocks{}
+			app := newTestApplication(t)
+			app.store.Blocks = blocks
+
+			mux := chi.NewRouter()
+			mux.Put("/v1/users/{userID}"+tt.path, tt.handler(app))
+
+			req := httptest.NewRequest(http.MethodPut, "/v1/users/"+tt.targetID+tt.path, nil)
+			rr := executeRequest(withUser(req, newTestUser(userID, "user")), mux)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			if len(blocks.blocks) != tt.wantBlocks || len(blocks.mutes) != tt.wantMutes {
+				t.Fatalf("got %d blocks and %d mutes, want %d and %d", len(blocks.blocks), len(blocks.mutes), tt.wantBlocks, tt.wantMutes)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 	
 	"github.com/Har2yQn78/social_back.git/internal/store"
@@ -10,10 +11,16 @@ type CreatePostPayload struct {
 	Title   string   `json:"title" validate:"required,max=100"`
 	Content string   `json:"content" validate:"required,max=1000"`
 	Tags    []string `json:"tags"`
+	// The language the post is indexed in for the search, the default one if empty.
+	Language string `json:"language" validate:"omitempty,oneof=simple arabic danish dutch english finnish french german hungarian indonesian irish italian lithuanian nepali norwegian portuguese romanian russian spanish swedish tamil turkish"`
 }
 
 type CreateCommentPayload struct {
-    Content string `json:"content" validate:"required,max:1000"`
+    Content string `json:"content" validate:"required,max=1000"`
+}
+
+type UpdateCommentPayload struct {
+    Content string `json:"content" validate:"required,max=1000"`
 }
 
 func getPostFromCtx(r *http.Request) *store.Post {
@@ -24,6 +31,14 @@ func getPostFromCtx(r *http.Request) *store.Post {
     return post
 }
 
+func getCommentFromCtx(r *http.Request) *store.Comment {
+    comment, ok := r.Context().Value(commentCtxKey).(*store.Comment)
+    if !ok {
+        panic("comment not found in context")
+    }
+    return comment
+}
+
 
 func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request) {
 	var payload CreatePostPayload
@@ -40,16 +55,23 @@ func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request
 	user := getUserFromContext(r)
 
 	post := &store.Post{
-		Title:   payload.Title,
-		Content: payload.Content,
-		Tags:    payload.Tags,
-		UserID:  user.ID, 
+		Title:    payload.Title,
+		Content:  payload.Content,
+		Tags:     payload.Tags,
+		UserID:   user.ID,
+		Language: payload.Language,
+		Pushed:   app.pushesToFollowers(user),
+	}
+	if post.Language == "" {
+		post.Language = app.config.search.language
 	}
 
 	if err := app.store.Posts.Create(r.Context(), post); err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
+	app.invalidateUser(r.Context(), user.ID) // posts count
+	app.pushToTimelines(user, post)
 
 	if err := app.jsonResponse(w, http.StatusCreated, post); err != nil {
 		app.internalServerError(w, r, err)
@@ -65,6 +87,11 @@ func (app *application) createCommentHandler(w http.ResponseWriter, r *http.Requ
     if err := readJSON(w, r, &payload); err != nil { app.badRequestResponse(w, r, err); return }
     if err := Validate.Struct(payload); err != nil { app.badRequestResponse(w, r, err); return }
 
+    // Moderators get past a block in postsContextMiddleware, but they don't get to comment.
+    blocked, err := app.store.Blocks.IsBlocked(r.Context(), user.ID, post.UserID)
+    if err != nil { app.internalServerError(w, r, err); return }
+    if blocked { app.forbiddenResponse(w, r); return }
+
     comment := &store.Comment{
         PostID:  post.ID,
         UserID:  user.ID,
@@ -72,6 +99,8 @@ func (app *application) createCommentHandler(w http.ResponseWriter, r *http.Requ
     }
 
     if err := app.store.Comments.Create(r.Context(), comment); err != nil {
+        // The post was deleted since postsContextMiddleware loaded it.
+        if errors.Is(err, store.ErrNotFound) { app.notFoundResponse(w, r, err); return }
         app.internalServerError(w, r, err); return
     }
     if err := app.jsonResponse(w, http.StatusCreated, comment); err != nil {
@@ -82,7 +111,7 @@ func (app *application) createCommentHandler(w http.ResponseWriter, r *http.Requ
 
 func (app *application) getPostHandler(w http.ResponseWriter, r *http.Request) {
     post := getPostFromCtx(r)
-    comments, err := app.store.Comments.GetByPostID(r.Context(), post.ID)
+    comments, err := app.store.Comments.GetByPostID(r.Context(), post.ID, getUserFromContext(r).ID)
     if err != nil {
         app.internalServerError(w, r, err)
         return
@@ -101,15 +130,9 @@ type UpdatePostPayload struct {
     Tags    []string `json:"tags"`
 }
 
+// updatePostHandler is wrapped in checkPermission, so only the author or a moderator gets here.
 func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request) {
     post := getPostFromCtx(r)
-    user := getUserFromContext(r)
-
-    // *** AUTHORIZATION CHECK ***
-    if post.UserID != user.ID {
-        app.forbiddenResponse(w, r)
-        return
-    }
 
     var payload UpdatePostPayload
     if err := readJSON(w, r, &payload); err != nil { app.badRequestResponse(w, r, err); return }
@@ -128,19 +151,44 @@ func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request
     }
 }
 
+// deletePostHandler is wrapped in checkPermission, so only the author or a moderator gets here.
 func (app *application) deletePostHandler(w http.ResponseWriter, r *http.Request) {
     post := getPostFromCtx(r)
-    user := getUserFromContext(r)
 
-    // *** AUTHORIZATION CHECK ***
-    if post.UserID != user.ID {
-        app.forbiddenResponse(w, r)
+    if err := app.store.Posts.Delete(r.Context(), post.ID); err != nil {
+        app.internalServerError(w, r, err)
         return
     }
+    app.invalidateUser(r.Context(), post.UserID) // posts count
+    app.removeFromTimelines(post)
+    w.WriteHeader(http.StatusNoContent)
+}
 
-    if err := app.store.Posts.Delete(r.Context(), post.ID); err != nil {
+// updateCommentHandler is wrapped in checkPermission, so only the author or a moderator gets here.
+func (app *application) updateCommentHandler(w http.ResponseWriter, r *http.Request) {
+    comment := getCommentFromCtx(r)
+
+    var payload UpdateCommentPayload
+    if err := readJSON(w, r, &payload); err != nil { app.badRequestResponse(w, r, err); return }
+    if err := Validate.Struct(payload); err != nil { app.badRequestResponse(w, r, err); return }
+
+    comment.Content = payload.Content
+    if err := app.store.Comments.Update(r.Context(), comment); err != nil {
+        app.internalServerError(w, r, err)
+        return
+    }
+    if err := app.jsonResponse(w, http.StatusOK, comment); err != nil {
+        app.internalServerError(w, r, err)
+    }
+}
+
+// deleteCommentHandler is wrapped in checkPermission, so only the author or a moderator gets here.
+func (app *application) deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+    comment := getCommentFromCtx(r)
+
+    if err := app.store.Comments.Delete(r.Context(), comment.ID); err != nil {
         app.internalServerError(w, r, err)
         return
     }
     w.WriteHeader(http.StatusNoContent)
-}
\ No newline at end of file
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Har2yQn78/social_back.git/internal/store"
+)
+
+// fakeComments stores comments on the posts it holds.
+type fakeComments struct {
+	*store.CommentStore
+	posts map[int64]bool
+}
+
+func (s fakeComments) Create(_ context.Context, comment *store.Comment) error {
+	if !s.posts[comment.PostID] {
+		return store.ErrNotFound
+	}
+	comment.ID = 1
+	return nil
+}
+
+func TestCreateComment(t *testing.T) {
+	app := newTestApplication(t)
+	app.store.Blocks = &fakeBlocks{}
+	app.store.Comments = fakeComments{posts: map[int64]bool{1: true}}
+
+	tests := []struct {
+		name       string
+		postID     int64
+		wantStatus int
+	}{
+		{"on a post", 1, http.StatusCreated},
+		{"on a post deleted meanwhile", 2, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/posts/1/comments", strings.NewReader(`{"content":"nice"}`))
+			req = withUser(req, newTestUser(1, "user"))
+			post := &store.Post{ID: tt.postID, UserID: 2}
+			req = req.WithContext(context.WithValue(req.Context(), postCtxKey, post))
+
+			rr := executeRequest(req, http.HandlerFunc(app.createCommentHandler))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+		})
+	}
+}
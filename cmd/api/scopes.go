@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+)
+
+// Scopes limit what a personal access token can do. Logged-in sessions are not
+// limited and can reach every endpoint.
+const (
+	scopePostsRead     = "posts:read"
+	scopePostsWrite    = "posts:write"
+	scopeCommentsWrite = "comments:write"
+	scopeFeedRead      = "feed:read"
+	scopeUsersRead     = "users:read"
+	scopeUsersWrite    = "users:write"
+)
+
+// allScopes lists every scope a token can be granted.
+var allScopes = []string{scopePostsRead, scopePostsWrite, scopeCommentsWrite, scopeFeedRead, scopeUsersRead, scopeUsersWrite}
+
+type scopesKey string
+const scopesCtxKey scopesKey = "scopes"
+
+// getScopesFromContext returns the scopes the request was granted. limited is
+// false when it was authenticated with a session and may do anything the user
+// can do; a token granted no scope is limited and may do nothing.
+func getScopesFromContext(r *http.Request) (scopes []string, limited bool) {
+	scopes, limited = r.Context().Value(scopesCtxKey).([]string)
+	return scopes, limited
+}
+
+// requireScope is how a route declares the scope it needs. Requests made with a
+// scoped token that wasn't granted it are rejected.
+func (app *application) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scopes, limited := getScopesFromContext(r)
+			if limited && !slices.Contains(scopes, scope) {
+				app.logger.Warnw("missing scope", "scope", scope, "path", r.URL.Path)
+				app.forbiddenResponse(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireSession protects account management endpoints: they can only be used
+// from a real login, never with a personal access token or by a third-party app.
+func (app *application) requireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, limited := getScopesFromContext(r); limited {
+			app.logger.Warnw("session required", "path", r.URL.Path)
+			app.forbiddenResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
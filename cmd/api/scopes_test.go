@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireScope(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name       string
+		scopes     []string // set in the context when limited
+		limited    bool
+		wantStatus int
+	}{
+		{name: "session", wantStatus: http.StatusOK},
+		{name: "granted", scopes: []string{scopePostsRead, scopeUsersRead}, limited: true, wantStatus: http.StatusOK},
+		{name: "not granted", scopes: []string{scopePostsRead}, limited: true, wantStatus: http.StatusForbidden},
+		{name: "no scope", scopes: []string{}, limited: true, wantStatus: http.StatusForbidden},
+		{name: "no scope scanned as nil", scopes: nil, limited: true, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/users/me", nil)
+			if tt.limited {
+				req = req.WithContext(context.WithValue(req.Context(), scopesCtxKey, tt.scopes))
+			}
+
+			if rr := executeRequest(req, app.requireScope(scopeUsersRead)(ok)); rr.Code != tt.wantStatus {
+				t.Fatalf("requireScope: got status %d, want %d", rr.Code, tt.wantStatus)
+			}
+
+			wantSession := http.StatusOK
+			if tt.limited {
+				wantSession = http.StatusForbidden
+			}
+			if rr := executeRequest(req, app.requireSession(ok)); rr.Code != wantSession {
+				t.Fatalf("requireSession: got status %d, want %d", rr.Code, wantSession)
+			}
+		})
+	}
+}
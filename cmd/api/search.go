@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/Har2yQn78/social_back.git/internal/store"
+)
+
+type searchConfig struct {
+	language string // the text search configuration of the posts that don't set one, and of the searches
+}
+
+// searchPostsHandler runs a full-text search of the posts, best matches first.
+func (app *application) searchPostsHandler(w http.ResponseWriter, r *http.Request) {
+	sq, ok := app.readSearchQuery(w, r)
+	if !ok {
+		return
+	}
+
+	posts, err := app.store.Posts.Search(r.Context(), getUserFromContext(r).ID, sq)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, posts); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// searchCommentsHandler runs a full-text search of the comments, best matches first.
+func (app *application) searchCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	sq, ok := app.readSearchQuery(w, r)
+	if !ok {
+		return
+	}
+
+	comments, err := app.store.Comments.Search(r.Context(), getUserFromContext(r).ID, sq)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, comments); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) readSearchQuery(w http.ResponseWriter, r *http.Request) (store.SearchQuery, bool) {
+	var sq store.SearchQuery
+	sq.Parse(r)
+	if sq.Language == "" {
+		sq.Language = app.config.search.language
+	}
+	if err := Validate.Struct(sq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return sq, false
+	}
+	return sq, true
+}
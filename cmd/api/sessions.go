@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Har2yQn78/social_back.git/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// deviceLabel turns a User-Agent into something a user recognizes in their
+// list of sessions, e.g. "Firefox on Windows".
+func deviceLabel(userAgent string) string {
+	// The order matters: most browsers also claim to be the ones they derive from.
+	browsers := []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	}
+	systems := []struct{ token, name string }{
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+
+	var browser, system string
+	for _, b := range browsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, s := range systems {
+		if strings.Contains(userAgent, s.token) {
+			system = s.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	case userAgent != "":
+		return truncate(userAgent, 100)
+	default:
+		return "Unknown device"
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// revokeSession ends one of the user's sessions, along with the access tokens
+// it issued that haven't expired yet.
+func (app *application) revokeSession(ctx context.Context, sessionID string, userID int64) error {
+	if err := app.store.Sessions.Revoke(ctx, sessionID, userID); err != nil {
+		return err
+	}
+	return app.denylist.RevokeSession(ctx, sessionID, app.config.auth.token.exp)
+}
+
+// getSessionsHandler lists the devices the user is logged in on.
+func (app *application) getSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	sessions, err := app.store.Sessions.GetByUserID(r.Context(), getUserFromContext(r).ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	current := getClaimsFromContext(r).SessionID
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, sessions); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// deleteSessionHandler logs one of the user's devices out.
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := uuid.Parse(chi.URLParam(r, "sessionID"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid session ID"))
+		return
+	}
+
+	if err := app.revokeSession(r.Context(), sessionID.String(), getUserFromContext(r).ID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteAllSessionsHandler logs the user out everywhere, this device included.
+func (app *application) deleteAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	if err := app.store.Sessions.RevokeAll(r.Context(), user.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.denylist.RevokeUser(r.Context(), user.ID, app.config.auth.token.exp); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
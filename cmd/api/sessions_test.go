@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Har2yQn78/social_back.git/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestDeleteSession(t *testing.T) {
+	app, state := newAuthTestApplication(t)
+
+	mux := chi.NewRouter()
+	mux.With(app.AuthTokenMiddleware, app.requireSession).Delete("/v1/users/me/sessions/{sessionID}", app.deleteSessionHandler)
+	deleteSession := func(accessToken, sessionID string) int {
+		r := httptest.NewRequest(http.MethodDelete, "/v1/users/me/sessions/"+sessionID, nil)
+		r.Header.Set("Authorization", "Bearer "+accessToken)
+		return executeRequest(r, mux).Code
+	}
+	sessionOf := func(tokens *TokenResponse) string {
+		claims, err := app.authenticator.ValidateToken(tokens.Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return claims.SessionID
+	}
+
+	laptop, phone := login(t, app), login(t, app)
+
+	if code := deleteSession(laptop.Token, sessionOf(phone)); code != http.StatusNoContent {
+		t.Fatalf("logging the phone out: got status %d, want %d", code, http.StatusNoContent)
+	}
+	if code := authenticated(app, phone.Token, okHandler, ""); code != http.StatusUnauthorized {
+		t.Fatalf("access token of the revoked session: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := refresh(t, app, phone.RefreshToken); code != http.StatusUnauthorized {
+		t.Fatalf("refresh token of the revoked session: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := authenticated(app, laptop.Token, okHandler, ""); code != http.StatusOK {
+		t.Fatalf("access token of the other session: got status %d, want %d", code, http.StatusOK)
+	}
+
+	if code := deleteSession(laptop.Token, sessionOf(phone)); code != http.StatusNotFound {
+		t.Fatalf("session revoked again: got status %d, want %d", code, http.StatusNotFound)
+	}
+	if code := deleteSession(laptop.Token, "not-a-session"); code != http.StatusBadRequest {
+		t.Fatalf("invalid session ID: got status %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// Someone else's session is left alone.
+	bobs := "0b6f3c52-8f3e-4d8b-9a4e-2f1c7d5e6a10"
+	state.sessions[bobs] = &store.Session{ID: bobs, UserID: 2}
+	if code := deleteSession(laptop.Token, bobs); code != http.StatusNotFound {
+		t.Fatalf("another user's session: got status %d, want %d", code, http.StatusNotFound)
+	}
+	if state.revoked[bobs] {
+		t.Fatal("another user's session was revoked")
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Har2yQn78/social_back.git/internal/store"
+)
+
+type suggestionsConfig struct {
+	interval time.Duration // how often the suggestions are recomputed
+	perUser  int           // how many suggestions are kept per user
+	perTag   int           // how many of the authors of a tag are suggested for sharing it
+}
+
+// runSuggestions recomputes who users could follow right away and then
+// periodically, until ctx is done.
+func (app *application) runSuggestions(ctx context.Context) {
+	app.recomputeSuggestions(ctx)
+
+	ticker := time.NewTicker(app.config.suggestions.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.recomputeSuggestions(ctx)
+		}
+	}
+}
+
+func (app *application) recomputeSuggestions(ctx context.Context) {
+	start := time.Now()
+	stored, err := app.store.Suggestions.Recompute(ctx, app.config.suggestions.perUser, app.config.suggestions.perTag)
+	if err != nil {
+		if errors.Is(err, store.ErrRecomputing) {
+			app.logger.Infow("follow suggestions are recomputed by another instance")
+			return
+		}
+		app.logger.Errorw("failed to recompute follow suggestions", "error", err)
+		return
+	}
+	app.logger.Infow("follow suggestions recomputed", "suggestions", stored, "duration", time.Since(start))
+}
+
+// getSuggestionsHandler lists who the authenticated user could follow, best first.
+func (app *application) getSuggestionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	var pq store.PaginatedQuery
+	pq.Parse(r)
+	if err := Validate.Struct(pq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	suggestions, err := app.store.Suggestions.GetForUser(r.Context(), user.ID, pq)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	// Users who registered since the last computation have none yet, their
+	// feed is empty too: show them the popular accounts instead.
+	if len(suggestions) == 0 && pq.Offset == 0 {
+		suggestions, err = app.store.Suggestions.GetPopular(r.Context(), user.ID, pq)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, suggestions); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// dismissSuggestionHandler stops suggesting the user in the URL.
+func (app *application) dismissSuggestionHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	dismissedID, err := parseUserIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if dismissedID == user.ID {
+		app.badRequestResponse(w, r, errors.New("you cannot dismiss yourself"))
+		return
+	}
+
+	if err := app.store.Suggestions.Dismiss(r.Context(), user.ID, dismissedID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Har2yQn78/social_back.git/internal/cursor"
+	"github.com/Har2yQn78/social_back.git/internal/store"
+	"go.uber.org/zap"
+)
+
+// The levels of the roles inserted by migration 000014.
+var testRoles = map[string]store.Role{
+	"user":      {ID: 1, Name: "user", Level: 1},
+	"moderator": {ID: 2, Name: "moderator", Level: 2},
+	"admin":     {ID: 3, Name: "admin", Level: 3},
+}
+
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	return &application{
+		logger:  zap.NewNop().Sugar(),
+		cursors: cursor.NewCodec("test"),
+		store: store.Storage{
+			Roles: fakeRoles{},
+			Audit: fakeAudit{},
+		},
+	}
+}
+
+// newTestUser is an active user with the role roleName.
+func newTestUser(id int64, roleName string) *store.User {
+	role := testRoles[roleName]
+	return &store.User{ID: id, Username: "user", IsActive: true, RoleID: role.ID, Role: role}
+}
+
+// withUser authenticates the request as user, like the auth middleware would.
+func withUser(r *http.Request, user *store.User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userCtxKey, user))
+}
+
+func executeRequest(r *http.Request, h http.Handler) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	return rr
+}
+
+type fakeRoles struct{}
+
+func (fakeRoles) GetByName(_ context.Context, name string) (*store.Role, error) {
+	role, ok := testRoles[name]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return &role, nil
+}
+
+// The fakes below embed the real stores for the methods a test doesn't need,
+// calling one of them panics on the nil database.
+
+type fakeUsers struct {
+	*store.UsersStore
+	users map[int64]*store.User
+}
+
+func (s fakeUsers) GetByID(_ context.Context, id int64) (*store.User, error) {
+	user, ok := s.users[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return user, nil
+}
+
+// fakeBlocks holds the blocks and mutes as [user, blocked or muted] pairs.
+type fakeBlocks struct {
+	*store.BlockStore
+	blocks [][2]int64
+	mutes  [][2]int64
+}
+
+func (s *fakeBlocks) Block(_ context.Context, userID, blockedID int64) error {
+	s.blocks = append(s.blocks, [2]int64{userID, blockedID})
+	return nil
+}
+
+func (s *fakeBlocks) Mute(_ context.Context, userID, mutedID int64) error {
+	s.mutes = append(s.mutes, [2]int64{userID, mutedID})
+	return nil
+}
+
+func (s *fakeBlocks) IsBlocked(_ context.Context, userID, otherID int64) (bool, error) {
+	for _, b := range s.blocks {
+		if (b[0] == userID && b[1] == otherID) || (b[0] == otherID && b[1] == userID) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// fakeFollowers holds the follows as [followed, follower] pairs.
+type fakeFollowers struct {
+	*store.FollowersStore
+	follows [][2]int64
+}
+
+func (s fakeFollowers) IsFollowing(_ context.Context, followedID, followerID int64) (bool, error) {
+	for _, f := range s.follows {
+		if f[0] == followedID && f[1] == followerID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (fakeFollowers) GetFollowers(context.Context, int64, int64, store.PaginatedQuery) ([]store.PublicUser, store.Page, error) {
+	return []store.PublicUser{}, store.Page{}, nil
+}
+
+func (fakeFollowers) GetFollowing(context.Context, int64, int64, store.PaginatedQuery) ([]store.PublicUser, store.Page, error) {
+	return []store.PublicUser{}, store.Page{}, nil
+}
+
+type fakePosts struct {
+	*store.PostsStore
+}
+
+func (fakePosts) GetByUser(context.Context, int64, store.UserPostsQuery) ([]store.Post, store.Page, error) {
+	return []store.Post{}, store.Page{}, nil
+}
+
+func (s fakeUsers) GetIDByUsername(_ context.Context, username string) (int64, error) {
+	for _, user := range s.users {
+		if user.Username == username {
+			return user.ID, nil
+		}
+	}
+	return 0, store.ErrNotFound
+}
+
+func (s fakeUsers) GetForLogin(ctx context.Context, id int64) (*store.User, error) {
+	return s.GetByID(ctx, id)
+}
+
+func (s fakeUsers) CancelDeletion(context.Context, int64) (bool, error) {
+	return false, nil
+}
+
+type fakeSessions struct {
+	*store.SessionStore
+}
+
+func (fakeSessions) Create(context.Context, *store.Session, time.Duration) error { return nil }
+
+type fakeRefreshTokens struct {
+	*store.RefreshTokenStore
+}
+
+func (fakeRefreshTokens) Create(context.Context, int64, string, string, time.Duration) error {
+	return nil
+}
+
+type fakeAudit struct{}
+
+func (fakeAudit) Log(context.Context, *store.AuditEntry) error { return nil }
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/Har2yQn78/social_back.git/internal/store"
+)
+
+type timelineConfig struct {
+	size               int           // how many posts a cached timeline holds
+	ttl                time.Duration // how long a timeline stays cached once filled
+	celebrityFollowers int           // the posts of authors with this many followers aren't pushed, they are merged in on read
+	fanOutTimeout      time.Duration
+}
+
+// pushesToFollowers tells whether the new posts of the author are pushed to the
+// timelines of their followers, which is too costly for the most followed authors.
+func (app *application) pushesToFollowers(author *store.User) bool {
+	return author.Followers < int64(app.config.timeline.celebrityFollowers)
+}
+
+// pushToTimelines adds a new post to the cached timelines of its author and,
+// when it's a pushed post, of their followers. It runs in the background.
+func (app *application) pushToTimelines(author *store.User, post *store.Post) {
+	if app.timelines == nil {
+		return
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, post.CreatedAt)
+	if err != nil {
+		app.logger.Errorw("failed to push post to timelines", "post_id", post.ID, "error", err)
+		return
+	}
+	entry := store.TimelineEntry{PostID: post.ID, CreatedAt: createdAt}
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				app.logger.Errorw("recovered in timeline fan-out goroutine", "panic", r)
+			}
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.timeline.fanOutTimeout)
+		defer cancel()
+
+		userIDs := []int64{author.ID}
+		if post.Pushed {
+			followerIDs, err := app.store.Followers.GetFollowerIDs(ctx, author.ID)
+			if err != nil {
+				app.logger.Errorw("failed to push post to timelines", "post_id", post.ID, "error", err)
+				return
+			}
+			userIDs = append(userIDs, followerIDs...)
+		}
+
+		if err := app.timelines.Push(ctx, userIDs, entry); err != nil {
+			app.logger.Errorw("failed to push post to timelines", "post_id", post.ID, "error", err)
+		}
+	}()
+}
+
+// removeFromTimelines takes a deleted post out of the cached timelines. The
+// timelines it's missed in drop it when the page is hydrated anyway.
+func (app *application) removeFromTimelines(post *store.Post) {
+	if app.timelines == nil {
+		return
+	}
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				app.logger.Errorw("recovered in timeline removal goroutine", "panic", r)
+			}
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.timeline.fanOutTimeout)
+		defer cancel()
+
+		followerIDs, err := app.store.Followers.GetFollowerIDs(ctx, post.UserID)
+		if err != nil {
+			app.logger.Errorw("failed to remove post from timelines", "post_id", post.ID, "error", err)
+			return
+		}
+		if err := app.timelines.Remove(ctx, append(followerIDs, post.UserID), post.ID); err != nil {
+			app.logger.Errorw("failed to remove post from timelines", "post_id", post.ID, "error", err)
+		}
+	}()
+}
+
+// invalidateTimeline drops the cached timeline of a user who started or
+// stopped following someone, the next read rebuilds it.
+func (app *application) invalidateTimeline(ctx context.Context, userID int64) {
+	if app.timelines == nil {
+		return
+	}
+	if err := app.timelines.Delete(ctx, userID); err != nil {
+		app.logger.Errorw("failed to invalidate timeline", "user_id", userID, "error", err)
+	}
+}
+
+// readTimeline reads a page of the user's feed from their cached timeline,
+// rebuilding it first when it isn't cached. The posts that weren't pushed, of
+// the authors with too many followers, are read from the database and merged in. ok is
+// false when the page goes past the posts the timeline holds, it has to be
+// read from the database then.
+func (app *application) readTimeline(ctx context.Context, userID int64, fq store.PaginatedFeedQuery) (feed []store.Post, page store.Page, ok bool, err error) {
+	cfg := app.config.timeline
+
+	entries, cached, err := app.timelines.Get(ctx, userID)
+	if err != nil {
+		return nil, store.Page{}, false, err
+	}
+	if !cached {
+		// The version is read first: a post pushed after it aborts the fill,
+		// one pushed before it is already in the database.
+		version, err := app.timelines.Version(ctx, userID)
+		if err != nil {
+			return nil, store.Page{}, false, err
+		}
+		entries, err = app.store.Posts.GetTimelineEntries(ctx, userID, cfg.size)
+		if err != nil {
+			return nil, store.Page{}, false, err
+		}
+		if err := app.timelines.Fill(ctx, userID, version, entries); err != nil {
+			app.logger.Errorw("failed to fill timeline", "user_id", userID, "error", err)
+		}
+	}
+
+	unpushed, err := app.store.Posts.GetUnpushedEntries(ctx, userID, fq.Cursor, fq.Limit+1)
+	if err != nil {
+		return nil, store.Page{}, false, err
+	}
+
+	rows, page, err := store.PaginateTimeline(append(entries, unpushed...), fq.Cursor, fq.Limit)
+	if err != nil {
+		return nil, store.Page{}, false, err
+	}
+
+	// A full timeline was trimmed: there are older posts than its oldest one.
+	if len(entries) >= cfg.size {
+		oldest := entries[0]
+		for _, e := range entries[1:] {
+			if e.Older(oldest) {
+				oldest = e
+			}
+		}
+		if page.Next == nil || (len(rows) > 0 && rows[len(rows)-1].Older(oldest)) {
+			return nil, store.Page{}, false, nil
+		}
+	}
+
+	ids := make([]int64, len(rows))
+	for i, e := range rows {
+		ids[i] = e.PostID
+	}
+	posts, err := app.store.Posts.GetByIDs(ctx, userID, ids)
+	if err != nil {
+		return nil, store.Page{}, false, err
+	}
+	byID := make(map[int64]store.Post, len(posts))
+	for _, p := range posts {
+		byID[p.ID] = p
+	}
+
+	// Deleted posts and the ones of muted or blocked authors are missing, the
+	// page can come out shorter than asked.
+	feed = []store.Post{}
+	for _, e := range rows {
+		if p, ok := byID[e.PostID]; ok {
+			feed = append(feed, p)
+		}
+	}
+	return feed, page, true, nil
+}
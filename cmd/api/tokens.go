@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Har2yQn78/social_back.git/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// personalTokenPrefix tells personal access tokens apart from JWTs in the Authorization header.
+const personalTokenPrefix = "gsp_"
+
+type CreatePersonalTokenPayload struct {
+	Name          string   `json:"name" validate:"required,max=100"`
+	Scopes        []string `json:"scopes" validate:"required,min=1,max=10,dive,oneof=posts:read posts:write comments:write feed:read users:read users:write"`
+	ExpiresInDays int      `json:"expires_in_days" validate:"omitempty,min=1,max=365"`
+}
+
+// PersonalTokenResponse includes the plain token, which is only ever shown once.
+type PersonalTokenResponse struct {
+	Token string `json:"token"`
+	*store.PersonalAccessToken
+}
+
+func generatePersonalToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return personalTokenPrefix + hex.EncodeToString(b), nil
+}
+
+func (app *application) createPersonalTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	var payload CreatePersonalTokenPayload
+	if err := readJSON(w, r, &payload); err != nil { app.badRequestResponse(w, r, err); return }
+	if err := Validate.Struct(payload); err != nil { app.badRequestResponse(w, r, err); return }
+
+	plainToken, err := generatePersonalToken()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	pat := &store.PersonalAccessToken{
+		UserID: user.ID,
+		Name:   payload.Name,
+		Scopes: payload.Scopes,
+	}
+	if payload.ExpiresInDays > 0 {
+		expiry := time.Now().Add(time.Hour * 24 * time.Duration(payload.ExpiresInDays))
+		pat.Expiry = &expiry
+	}
+
+	if err := app.store.PersonalTokens.Create(r.Context(), pat, plainToken); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, PersonalTokenResponse{Token: plainToken, PersonalAccessToken: pat}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) getPersonalTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	tokens, err := app.store.PersonalTokens.GetByUserID(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, tokens); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) deletePersonalTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	tokenID, err := strconv.ParseInt(chi.URLParam(r, "tokenID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid token ID"))
+		return
+	}
+
+	if err := app.store.PersonalTokens.Delete(r.Context(), tokenID, user.ID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
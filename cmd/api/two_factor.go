@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Har2yQn78/social_back.git/internal/auth"
+	"github.com/Har2yQn78/social_back.git/internal/store"
+)
+
+const recoveryCodesCount = 10
+
+// MFAChallengeResponse is returned by a login when a second factor is needed.
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+// TwoFactorEnrollment is shown once, when the user starts enrolling.
+type TwoFactorEnrollment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"otpauth_uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// TwoFactorCodePayload carries either a code from the authenticator app or a recovery code.
+type TwoFactorCodePayload struct {
+	Code         string `json:"code" validate:"required_without=RecoveryCode,omitempty,len=6,numeric"`
+	RecoveryCode string `json:"recovery_code" validate:"required_without=Code,omitempty,max=20"`
+}
+
+type TwoFactorLoginPayload struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	TwoFactorCodePayload
+}
+
+type DisableTwoFactorPayload struct {
+	Password string `json:"password" validate:"required,min=3,max=72"`
+	TwoFactorCodePayload
+}
+
+var errInvalidCode = errors.New("invalid two-factor code")
+
+// mfaTokenLockoutKey counts the wrong codes tried with an mfa token, identified by its jti.
+func mfaTokenLockoutKey(jti string) string { return "mfa:" + jti }
+
+// checkSecondFactor verifies a TOTP code, or a recovery code when no TOTP code is given.
+// Both are single-use: a code that already logged the user in is refused.
+func (app *application) checkSecondFactor(ctx context.Context, userID int64, code, recoveryCode string) error {
+	if code == "" {
+		err := app.store.TwoFactor.UseRecoveryCode(ctx, userID, strings.ToLower(strings.TrimSpace(recoveryCode)))
+		if errors.Is(err, store.ErrNotFound) {
+			return errInvalidCode
+		}
+		return err
+	}
+
+	tf, err := app.store.TwoFactor.Get(ctx, userID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return errInvalidCode
+		}
+		return err
+	}
+
+	secret, err := app.secretBox.Open(tf.Secret)
+	if err != nil {
+		return err
+	}
+
+	step, ok := app.totp.Validate(string(secret), code)
+	if !ok {
+		return errInvalidCode
+	}
+
+	err = app.store.TwoFactor.UseStep(ctx, userID, step)
+	if errors.Is(err, store.ErrCodeUsed) {
+		return errInvalidCode
+	}
+	return err
+}
+
+// secondFactorFailed counts a wrong code against the account, the client and the
+// mfa token. After too many wrong codes the mfa token is revoked: the next
+// attempt has to start over with the password.
+func (app *application) secondFactorFailed(r *http.Request, claims *auth.Claims, user *store.User) {
+	app.loginFailed(r, user.Email, user)
+
+	failures, _, err := app.accountLockout.Fail(r.Context(), mfaTokenLockoutKey(claims.ID))
+	if err != nil {
+		app.logger.Errorw("failed to record failed code", "error", err)
+		return
+	}
+	if failures < app.config.auth.totp.maxAttempts {
+		return
+	}
+	if err := app.denylist.Revoke(r.Context(), claims.ID, claims.ExpiresAt.Time); err != nil {
+		app.logger.Errorw("failed to revoke mfa token", "error", err)
+	}
+}
+
+// createTokenTwoFactorHandler is the second step of a login with two-factor
+// authentication: it trades the mfa token and a valid code for a token pair.
+func (app *application) createTokenTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var payload TwoFactorLoginPayload
+	if err := readJSON(w, r, &payload); err != nil { app.badRequestResponse(w, r, err); return }
+	if err := Validate.Struct(payload); err != nil { app.badRequestResponse(w, r, err); return }
+
+	claims, err := app.authenticator.ValidateToken(payload.MFAToken)
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+	if !claims.MFAPending {
+		app.unauthorizedErrorResponse(w, r, errors.New("not an mfa token"))
+		return
+	}
+
+	userID, err := claims.UserID()
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+
+	revoked, err := app.isTokenRevoked(r.Context(), claims, userID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if revoked {
+		app.unauthorizedErrorResponse(w, r, errors.New("mfa token has already been used"))
+		return
+	}
+
+	user, err := app.store.Users.GetForLogin(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.unauthorizedErrorResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// Codes are guessed like passwords: the same lockout applies.
+	retryAfter, err := app.loginLockout(r.Context(), user.Email, clientIP(r))
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.audit(r, store.AuditLoginBlocked, &user.ID, user.Email)
+		app.rateLimitExceededResponse(w, r, retryAfter.Round(time.Second).String())
+		return
+	}
+
+	if err := app.checkSecondFactor(r.Context(), userID, payload.Code, payload.RecoveryCode); err != nil {
+		if errors.Is(err, errInvalidCode) {
+			app.secondFactorFailed(r, claims, user)
+			app.unauthorizedErrorResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+	app.loginSucceeded(r, user)
+	if err := app.accountLockout.Reset(r.Context(), mfaTokenLockoutKey(claims.ID)); err != nil {
+		app.logger.Errorw("failed to reset failed codes", "error", err)
+	}
+
+	// The mfa token can only be exchanged once.
+	if err := app.denylist.Revoke(r.Context(), claims.ID, claims.ExpiresAt.Time); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	tokens, err := app.issueTokens(r, userID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, tokens); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// enrollTwoFactorHandler generates a TOTP secret and recovery codes for the user.
+// Two-factor authentication is only turned on once a first code is confirmed.
+func (app *application) enrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	if user.TwoFactorEnabled {
+		app.badRequestResponse(w, r, errors.New("two-factor authentication is already enabled"))
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	sealed, err := app.secretBox.Seal([]byte(secret))
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.TwoFactor.Enroll(r.Context(), user.ID, sealed, codes); err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			app.badRequestResponse(w, r, errors.New("two-factor authentication is already enabled"))
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	enrollment := TwoFactorEnrollment{
+		Secret:        secret,
+		URI:           app.totp.URI(user.Email, secret),
+		RecoveryCodes: codes,
+	}
+	if err := app.jsonResponse(w, http.StatusCreated, enrollment); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// confirmTwoFactorHandler enables two-factor authentication with a first valid code.
+func (app *application) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	var payload struct {
+		Code string `json:"code" validate:"required,len=6,numeric"`
+	}
+	if err := readJSON(w, r, &payload); err != nil { app.badRequestResponse(w, r, err); return }
+	if err := Validate.Struct(payload); err != nil { app.badRequestResponse(w, r, err); return }
+
+	tf, err := app.store.TwoFactor.Get(r.Context(), user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.badRequestResponse(w, r, errors.New("two-factor authentication enrollment has not been started"))
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+	if tf.Enabled {
+		app.badRequestResponse(w, r, errors.New("two-factor authentication is already enabled"))
+		return
+	}
+
+	secret, err := app.secretBox.Open(tf.Secret)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	step, ok := app.totp.Validate(string(secret), payload.Code)
+	if !ok {
+		app.badRequestResponse(w, r, errInvalidCode)
+		return
+	}
+
+	if err := app.store.TwoFactor.Enable(r.Context(), user.ID, step); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	app.invalidateUser(r.Context(), user.ID)
+
+	app.jsonResponse(w, http.StatusOK, map[string]string{"message": "two-factor authentication enabled"})
+}
+
+// disableTwoFactorHandler turns two-factor authentication off. It asks for the
+// password and a code, so a stolen access token alone isn't enough.
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var payload DisableTwoFactorPayload
+	if err := readJSON(w, r, &payload); err != nil { app.badRequestResponse(w, r, err); return }
+	if err := Validate.Struct(payload); err != nil { app.badRequestResponse(w, r, err); return }
+
+	// The cached user doesn't carry the password hash, read it from the database.
+	user, err := app.store.Users.GetByID(r.Context(), getUserFromContext(r).ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if !user.TwoFactorEnabled {
+		app.badRequestResponse(w, r, errors.New("two-factor authentication is not enabled"))
+		return
+	}
+	if err := user.Password.Compare(payload.Password); err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.checkSecondFactor(r.Context(), user.ID, payload.Code, payload.RecoveryCode); err != nil {
+		if errors.Is(err, errInvalidCode) {
+			app.unauthorizedErrorResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.TwoFactor.Disable(r.Context(), user.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	app.invalidateUser(r.Context(), user.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
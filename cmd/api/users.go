@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Har2yQn78/social_back.git/internal/notifier"
 	"github.com/Har2yQn78/social_back.git/internal/store"
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 )
 
 
@@ -21,33 +26,90 @@ func getUserFromContext(r *http.Request) *store.User {
 	return user
 }
 
+// parseUserIDParam reads the {userID} URL parameter.
+func parseUserIDParam(r *http.Request) (int64, error) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid user ID")
+	}
+	return userID, nil
+}
+
 func (app *application) followUserHandler(w http.ResponseWriter, r *http.Request) {
 	followerUser := getUserFromContext(r)
-	followedID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	followedID, err := parseUserIDParam(r)
 	if err != nil {
-		app.badRequestResponse(w, r, errors.New("invalid user ID"))
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	err = app.store.Followers.Follow(r.Context(), followedID, followerUser.ID)
+	if followedID == followerUser.ID {
+		app.badRequestResponse(w, r, errors.New("you cannot follow yourself"))
+		return
+	}
+
+	followed, err := app.getUser(r.Context(), followedID)
 	if err != nil {
-		if errors.Is(err, store.ErrConflict) {
-			app.conflictResponse(w, r, err)
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
 			return
 		}
 		app.internalServerError(w, r, err)
 		return
 	}
 
+	blocked, err := app.store.Blocks.IsBlocked(r.Context(), followerUser.ID, followedID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if blocked {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	// Private accounts decide who follows them.
+	if followed.IsPrivate {
+		if err := app.store.Followers.Request(r.Context(), followedID, followerUser.ID); err != nil {
+			if errors.Is(err, store.ErrConflict) {
+				app.conflictResponse(w, r, err)
+				return
+			}
+			app.internalServerError(w, r, err)
+			return
+		}
+		app.notify(r.Context(), notifier.FollowRequested, followedID, followerUser.ID)
+
+		app.jsonResponse(w, http.StatusAccepted, map[string]string{"message": "follow request sent"})
+		return
+	}
+
+	err = app.store.Followers.Follow(r.Context(), followedID, followerUser.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, err)
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	// Both users' counts changed.
+	app.invalidateUser(r.Context(), followedID)
+	app.invalidateUser(r.Context(), followerUser.ID)
+	app.invalidateTimeline(r.Context(), followerUser.ID)
+
 	app.jsonResponse(w, http.StatusOK, map[string]string{"message": "user followed"})
 }
 
 func (app *application) unfollowUserHandler(w http.ResponseWriter, r *http.Request) {
 	followerUser := getUserFromContext(r)
 
-	followedID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	followedID, err := parseUserIDParam(r)
 	if err != nil {
-		app.badRequestResponse(w, r, errors.New("invalid user ID"))
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
@@ -56,6 +118,14 @@ func (app *application) unfollowUserHandler(w http.ResponseWriter, r *http.Reque
 		app.internalServerError(w, r, err)
 		return
 	}
+	// Unfollowing a private account also withdraws a pending request.
+	if err := app.store.Followers.CancelRequest(r.Context(), followedID, followerUser.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	app.invalidateUser(r.Context(), followedID)
+	app.invalidateUser(r.Context(), followerUser.ID)
+	app.invalidateTimeline(r.Context(), followerUser.ID)
 
 	app.jsonResponse(w, http.StatusOK, map[string]string{"message": "user unfollowed"})
 }
@@ -64,16 +134,38 @@ func (app *application) getUserFeedHandler(w http.ResponseWriter, r *http.Reques
     user := getUserFromContext(r)
     var fq store.PaginatedFeedQuery
     fq.Parse(r)
+    if fq.Language == "" {
+        fq.Language = app.config.search.language
+    }
     if err := Validate.Struct(fq); err != nil {
         app.badRequestResponse(w, r, err)
         return
     }
-    feed, err := app.store.Posts.GetUserFeed(r.Context(), user.ID, fq)
+    cursor, err := app.readCursor(r)
     if err != nil {
-        app.internalServerError(w, r, err)
+        app.badRequestResponse(w, r, err)
         return
     }
-    if err := app.jsonResponse(w, http.StatusOK, feed); err != nil {
+    fq.Cursor = cursor
+
+    var feed []store.Post
+    var page store.Page
+    var fromTimeline bool
+    // The cached timeline only holds the plain feed, newest first.
+    if app.timelines != nil && fq.Search == "" && len(fq.Tags) == 0 && fq.Sort == "desc" && fq.Offset == 0 {
+        feed, page, fromTimeline, err = app.readTimeline(r.Context(), user.ID, fq)
+        if err != nil {
+            app.logger.Errorw("failed to read timeline", "user_id", user.ID, "error", err)
+        }
+    }
+    if !fromTimeline {
+        feed, page, err = app.store.Posts.GetUserFeed(r.Context(), user.ID, fq)
+        if err != nil {
+            app.internalServerError(w, r, err)
+            return
+        }
+    }
+    if err := app.paginatedResponse(w, r, http.StatusOK, feed, page); err != nil {
         app.internalServerError(w, r, err)
     }
 }
@@ -90,4 +182,358 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
         return
     }
     app.jsonResponse(w, http.StatusOK, map[string]string{"message": "user activated successfully"})
-}
\ No newline at end of file
+}
+
+type ResendActivationPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// resendActivationHandler issues a fresh invitation for an account that was never
+// activated, e.g. because the welcome email got lost or the link expired.
+func (app *application) resendActivationHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ResendActivationPayload
+	if err := readJSON(w, r, &payload); err != nil { app.badRequestResponse(w, r, err); return }
+	if err := Validate.Struct(payload); err != nil { app.badRequestResponse(w, r, err); return }
+
+	if !app.allowAccountEmail(w, r, payload.Email) {
+		return
+	}
+
+	plainToken := uuid.New().String()
+	user, err := app.store.Users.Reinvite(r.Context(), payload.Email, plainToken, invitationExp)
+	switch {
+	case err == nil:
+		app.sendActivationEmail(user, plainToken)
+	case errors.Is(err, store.ErrNotFound):
+		// Unknown and already active emails get the same answer, to avoid
+		// revealing which emails are registered in our system.
+	default:
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.jsonResponse(w, http.StatusAccepted, map[string]string{"message": "if this email is waiting for activation, a new link has been sent"})
+}
+
+type ChangeEmailPayload struct {
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Password string `json:"password" validate:"required,min=3,max=72"`
+}
+
+// changeEmailHandler starts an email change. The new address only replaces the
+// current one once confirmed with the link sent to it; the current address is
+// told about the change, in case the account was taken over.
+func (app *application) changeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ChangeEmailPayload
+	if err := readJSON(w, r, &payload); err != nil { app.badRequestResponse(w, r, err); return }
+	if err := Validate.Struct(payload); err != nil { app.badRequestResponse(w, r, err); return }
+
+	// The cached user doesn't carry the password hash, read it from the database.
+	user, err := app.store.Users.GetByID(r.Context(), getUserFromContext(r).ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := user.Password.Compare(payload.Password); err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+	if strings.EqualFold(payload.Email, user.Email) {
+		app.badRequestResponse(w, r, errors.New("this is already your email"))
+		return
+	}
+
+	plainToken := uuid.New().String()
+	err = app.store.Users.CreateEmailChange(r.Context(), user.ID, payload.Email, plainToken, time.Hour*24)
+	switch {
+	case err == nil:
+		app.sendEmail(payload.Email, "email_change_confirm.tmpl", map[string]any{
+			"confirmURL": app.config.frontendURL + "/confirm-email/" + plainToken,
+			"username":   user.Username,
+		})
+	case errors.Is(err, store.ErrDuplicateEmail):
+		// We answer exactly as if the email was free, to avoid revealing which
+		// emails are registered in our system. Its owner is told instead.
+		app.sendEmail(payload.Email, "email_change_taken.tmpl", nil)
+	default:
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.sendEmail(user.Email, "email_change_notice.tmpl", map[string]any{
+		"newEmail": payload.Email,
+		"username": user.Username,
+	})
+
+	app.jsonResponse(w, http.StatusAccepted, map[string]string{"message": "please check your new email to confirm the change"})
+}
+
+// confirmEmailHandler swaps the user's email for the new one the token was sent to.
+func (app *application) confirmEmailHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.store.Users.ConfirmEmailChange(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		case errors.Is(err, store.ErrDuplicateEmail):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	app.invalidateUser(r.Context(), userID)
+
+	app.jsonResponse(w, http.StatusOK, map[string]string{"message": "email changed successfully"})
+}
+
+// getUserHandler returns the public profile of a user.
+func (app *application) getUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := parseUserIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	app.writeProfile(w, r, userID)
+}
+
+// getUserByUsernameHandler returns the public profile of the user with the username in the URL.
+func (app *application) getUserByUsernameHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.store.Users.GetIDByUsername(r.Context(), chi.URLParam(r, "username"))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.writeProfile(w, r, userID)
+}
+
+func (app *application) writeProfile(w http.ResponseWriter, r *http.Request, userID int64) {
+	user, err := app.getUser(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	profile := user.Public()
+	profile.FollowedByMe, err = app.store.Followers.IsFollowing(r.Context(), user.ID, getUserFromContext(r).ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, profile); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// searchUsersHandler finds users by username or display name.
+func (app *application) searchUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var sq store.UserSearchQuery
+	sq.Parse(r)
+	if err := Validate.Struct(sq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	users, err := app.store.Users.Search(r.Context(), getUserFromContext(r).ID, sq)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, users); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// getUserPostsHandler lists the posts of the user in the URL, newest first. The
+// posts of private accounts are only listed to their followers.
+func (app *application) getUserPostsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := parseUserIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var q store.UserPostsQuery
+	q.Parse(r)
+	if err := Validate.Struct(q); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if q.Cursor, err = app.readCursor(r); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if _, err := app.getUser(r.Context(), userID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	visible, err := app.canSeePosts(r.Context(), getUserFromContext(r), userID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	// Like a single post, the posts of an account the user can't see don't
+	// exist for them, which doesn't tell whether it's private or blocked them.
+	if !visible {
+		app.notFoundResponse(w, r, store.ErrNotFound)
+		return
+	}
+
+	posts, page, err := app.store.Posts.GetByUser(r.Context(), userID, q)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.cursorResponse(w, r, http.StatusOK, posts, page); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// getFollowersHandler lists who follows the user in the URL. Like their posts,
+// the lists of private accounts are only shown to their followers.
+func (app *application) getFollowersHandler(w http.ResponseWriter, r *http.Request) {
+	app.listFollows(w, r, app.store.Followers.GetFollowers)
+}
+
+// getFollowingHandler lists who the user in the URL follows.
+func (app *application) getFollowingHandler(w http.ResponseWriter, r *http.Request) {
+	app.listFollows(w, r, app.store.Followers.GetFollowing)
+}
+
+func (app *application) listFollows(w http.ResponseWriter, r *http.Request, list func(ctx context.Context, userID, viewerID int64, pq store.PaginatedQuery) ([]store.PublicUser, store.Page, error)) {
+	userID, err := parseUserIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var pq store.PaginatedQuery
+	pq.Parse(r)
+	if err := Validate.Struct(pq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if pq.Cursor, err = app.readCursor(r); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if _, err := app.getUser(r.Context(), userID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// Who a private account follows, or is followed by, is as private as its
+	// posts.
+	visible, err := app.canSeePosts(r.Context(), getUserFromContext(r), userID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if !visible {
+		app.notFoundResponse(w, r, store.ErrNotFound)
+		return
+	}
+
+	users, page, err := list(r.Context(), userID, getUserFromContext(r).ID, pq)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.paginatedResponse(w, r, http.StatusOK, users, page); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// getMeHandler returns the full account of the authenticated user, email included.
+func (app *application) getMeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := app.jsonResponse(w, http.StatusOK, getUserFromContext(r)); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// UpdateProfilePayload only changes the fields that are given. An empty string
+// clears a field.
+type UpdateProfilePayload struct {
+	DisplayName *string `json:"display_name" validate:"omitnil,max=100"`
+	Bio         *string `json:"bio" validate:"omitnil,max=500"`
+	Location    *string `json:"location" validate:"omitnil,max=100"`
+	Website     *string `json:"website" validate:"omitnil,max=255,len=0|http_url"`
+	AvatarURL   *string `json:"avatar_url" validate:"omitnil,max=2048,len=0|http_url"`
+	IsPrivate   *bool   `json:"is_private"`
+}
+
+// trimSpace trims the string s points to, if any.
+func trimSpace(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	trimmed := strings.TrimSpace(*s)
+	return &trimmed
+}
+
+func (app *application) updateMeHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	var payload UpdateProfilePayload
+	if err := readJSON(w, r, &payload); err != nil { app.badRequestResponse(w, r, err); return }
+	if err := Validate.Struct(payload); err != nil { app.badRequestResponse(w, r, err); return }
+
+	update := &store.ProfileUpdate{
+		DisplayName: trimSpace(payload.DisplayName),
+		Bio:         trimSpace(payload.Bio),
+		Location:    trimSpace(payload.Location),
+		Website:     payload.Website,
+		AvatarURL:   payload.AvatarURL,
+		IsPrivate:   payload.IsPrivate,
+	}
+	profile, err := app.store.Users.UpdateProfile(r.Context(), user.ID, update)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+	// The next request reloads the user, so every instance sees the new profile.
+	app.invalidateUser(r.Context(), user.ID)
+
+	// Nobody has to wait for an approval on a public account. The cached user
+	// may be stale, so this doesn't depend on whether it was private before.
+	if payload.IsPrivate != nil && !profile.IsPrivate {
+		if err := app.approveAllFollowRequests(r.Context(), user.ID); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
+	user.Profile = *profile
+	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Har2yQn78/social_back.git/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// profileUsers keeps the last profile update it was given.
+type profileUsers struct {
+	fakeUsers
+	update *store.ProfileUpdate
+}
+
+func (s *profileUsers) UpdateProfile(_ context.Context, _ int64, update *store.ProfileUpdate) (*store.Profile, error) {
+	s.update = update
+	return &store.Profile{}, nil
+}
+
+func TestUpdateMe(t *testing.T) {
+	bio, empty := "bio", ""
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		want       store.ProfileUpdate
+	}{
+		{name: "one field", body: `{"bio":"  bio  "}`, wantStatus: http.StatusOK, want: store.ProfileUpdate{Bio: &bio}},
+		{name: "clear a field", body: `{"website":""}`, wantStatus: http.StatusOK, want: store.ProfileUpdate{Website: &empty}},
+		{name: "nothing", body: `{}`, wantStatus: http.StatusOK},
+		{name: "invalid website", body: `{"website":"example.com"}`, wantStatus: http.StatusBadRequest},
+		{name: "bio too long", body: `{"bio":"` + strings.Repeat("a", 501) + `"}`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &profileUsers{}
+			app := newTestApplication(t)
+			app.store.Users = users
+
+			req := httptest.NewRequest(http.MethodPatch, "/v1/users/me", strings.NewReader(tt.body))
+			rr := executeRequest(withUser(req, newTestUser(1, "user")), http.HandlerFunc(app.updateMeHandler))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if users.update != nil {
+					t.Fatal("an invalid update was saved")
+				}
+				return
+			}
+
+			// Only the fields given are passed on, the others stay nil.
+			got := users.update
+			if !sameString(got.DisplayName, tt.want.DisplayName) || !sameString(got.Bio, tt.want.Bio) ||
+				!sameString(got.Location, tt.want.Location) || !sameString(got.Website, tt.want.Website) ||
+				!sameString(got.AvatarURL, tt.want.AvatarURL) || got.IsPrivate != nil {
+				t.Fatalf("got update %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetUserByUsername(t *testing.T) {
+	alice := newTestUser(2, "user")
+	alice.Username = "alice"
+
+	app := newTestApplication(t)
+	app.store.Users = fakeUsers{users: map[int64]*store.User{alice.ID: alice}}
+	app.store.Followers = fakeFollowers{}
+
+	mux := chi.NewRouter()
+	mux.Get("/v1/users/by-username/{username}", app.getUserByUsernameHandler)
+	mux.Get("/v1/users/search", app.searchUsersHandler)
+
+	rr := executeRequest(withUser(httptest.NewRequest(http.MethodGet, "/v1/users/by-username/alice", nil), newTestUser(1, "user")), mux)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	var res struct{ Data store.PublicUser }
+	if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Data.ID != alice.ID {
+		t.Fatalf("got user %d, want %d", res.Data.ID, alice.ID)
+	}
+
+	rr = executeRequest(withUser(httptest.NewRequest(http.MethodGet, "/v1/users/by-username/bob", nil), newTestUser(1, "user")), mux)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("unknown username: got status %d, want %d", rr.Code, http.StatusNotFound)
+	}
+
+	// A search needs a query, "@" alone is none.
+	for _, path := range []string{"/v1/users/search", "/v1/users/search?q=@"} {
+		rr := executeRequest(withUser(httptest.NewRequest(http.MethodGet, path, nil), newTestUser(1, "user")), mux)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s: got status %d, want %d", path, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func sameString(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+  id bigserial PRIMARY KEY,
+  token_hash bytea UNIQUE NOT NULL,
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  family_id uuid NOT NULL,
+  expiry timestamp(0) with time zone NOT NULL,
+  revoked_at timestamp(0) with time zone,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id);
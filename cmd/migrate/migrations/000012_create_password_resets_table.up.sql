@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS password_resets(
+  token_hash bytea PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  expiry timestamp(0) with time zone NOT NULL
+);
@@ -0,0 +1,12 @@
+ALTER TABLE users ADD COLUMN totp_secret bytea;
+ALTER TABLE users ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN totp_last_step bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes(
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  code_hash bytea NOT NULL,
+  used_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_recovery_codes_user_id ON user_recovery_codes (user_id);
@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN role_id;
+
+DROP TABLE IF EXISTS roles;
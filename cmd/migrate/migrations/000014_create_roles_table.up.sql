@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS roles (
+  id bigserial PRIMARY KEY,
+  name varchar(255) NOT NULL UNIQUE,
+  level int NOT NULL DEFAULT 0,
+  description text NOT NULL DEFAULT ''
+);
+
+INSERT INTO roles (name, level, description)
+VALUES
+  ('user', 1, 'A user can create posts and comments'),
+  ('moderator', 2, 'A moderator can update or delete the posts and comments of other users'),
+  ('admin', 3, 'An admin can do everything, including changing the role of users');
+
+-- New users get the 'user' role, which is the first one inserted above.
+ALTER TABLE users ADD COLUMN role_id bigint NOT NULL REFERENCES roles(id) DEFAULT 1;
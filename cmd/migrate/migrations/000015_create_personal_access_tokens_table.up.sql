@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS personal_access_tokens (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name varchar(100) NOT NULL,
+  token_hash bytea UNIQUE NOT NULL,
+  scopes varchar(50)[] NOT NULL,
+  last_used_at timestamp(0) with time zone,
+  expiry timestamp(0) with time zone,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_personal_access_tokens_user_id ON personal_access_tokens (user_id);
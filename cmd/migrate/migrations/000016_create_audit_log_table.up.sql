@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+  id bigserial PRIMARY KEY,
+  user_id bigint REFERENCES users(id) ON DELETE SET NULL,
+  event varchar(50) NOT NULL,
+  email citext,
+  ip varchar(64) NOT NULL,
+  user_agent text NOT NULL DEFAULT '',
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_user_id ON audit_log (user_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_email ON audit_log (email);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS account_unlocks;
\ No newline at end of file
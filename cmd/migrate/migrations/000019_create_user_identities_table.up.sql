@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS user_identities (
+  provider varchar(50) NOT NULL,
+  subject varchar(255) NOT NULL,
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  email citext NOT NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities (user_id);
+
+CREATE TABLE IF NOT EXISTS oidc_states (
+  state_hash bytea PRIMARY KEY,
+  provider varchar(50) NOT NULL,
+  code_verifier varchar(128) NOT NULL,
+  expiry timestamp(0) with time zone NOT NULL
+);
@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS oauth_clients (
+  id bigserial PRIMARY KEY,
+  client_id varchar(64) UNIQUE NOT NULL,
+  secret_hash bytea NOT NULL,
+  name varchar(100) NOT NULL,
+  redirect_uris text[] NOT NULL,
+  owner_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_oauth_clients_owner_id ON oauth_clients (owner_id);
+
+-- What a user allowed an app to do. Deleting the grant disconnects the app.
+CREATE TABLE IF NOT EXISTS oauth_grants (
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  client_id bigint NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+  scopes varchar(50)[] NOT NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (user_id, client_id)
+);
+
+CREATE TABLE IF NOT EXISTS oauth_codes (
+  code_hash bytea PRIMARY KEY,
+  client_id bigint NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  redirect_uri text NOT NULL,
+  scopes varchar(50)[] NOT NULL,
+  code_challenge varchar(128) NOT NULL,
+  expiry timestamp(0) with time zone NOT NULL
+);
@@ -0,0 +1,23 @@
+-- A session is a login on a device. Its ID is the family of the refresh tokens
+-- rotated by that device and the "sid" claim of the access tokens issued to it.
+CREATE TABLE IF NOT EXISTS sessions (
+  id uuid PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  device varchar(100) NOT NULL,
+  user_agent varchar(512) NOT NULL,
+  ip varchar(45) NOT NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  last_seen_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  expiry timestamp(0) with time zone NOT NULL,
+  revoked_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+
+-- Logins made before sessions were tracked.
+INSERT INTO sessions (id, user_id, device, user_agent, ip, created_at, last_seen_at, expiry)
+SELECT family_id, user_id, 'Unknown device', '', '', MIN(created_at), MAX(created_at), MAX(expiry)
+FROM refresh_tokens
+WHERE revoked_at IS NULL AND expiry > NOW()
+GROUP BY family_id, user_id
+ON CONFLICT (id) DO NOTHING;
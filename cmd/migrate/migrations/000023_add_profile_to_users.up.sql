@@ -0,0 +1,6 @@
+ALTER TABLE users
+  ADD COLUMN display_name varchar(100) NOT NULL DEFAULT '',
+  ADD COLUMN bio varchar(500) NOT NULL DEFAULT '',
+  ADD COLUMN location varchar(100) NOT NULL DEFAULT '',
+  ADD COLUMN website varchar(255) NOT NULL DEFAULT '',
+  ADD COLUMN avatar_url varchar(2048) NOT NULL DEFAULT '';
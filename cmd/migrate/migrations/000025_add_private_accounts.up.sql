@@ -0,0 +1,9 @@
+ALTER TABLE users ADD COLUMN is_private BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Follows of private accounts wait here until the owner approves them.
+CREATE TABLE IF NOT EXISTS follow_requests (
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  requester_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (user_id, requester_id)
+);
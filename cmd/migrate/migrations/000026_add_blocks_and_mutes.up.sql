@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS blocks (
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  blocked_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (user_id, blocked_id),
+  CHECK (user_id <> blocked_id)
+);
+
+-- Blocks are checked both ways.
+CREATE INDEX IF NOT EXISTS idx_blocks_blocked_id ON blocks (blocked_id);
+
+CREATE TABLE IF NOT EXISTS mutes (
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  muted_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (user_id, muted_id),
+  CHECK (user_id <> muted_id)
+);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS data_exports;
+DROP INDEX IF EXISTS idx_users_deletion_scheduled_at;
+ALTER TABLE users DROP COLUMN IF EXISTS deletion_scheduled_at;
+ALTER TABLE posts DROP CONSTRAINT IF EXISTS fk_user;
+ALTER TABLE posts ADD CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users (id);
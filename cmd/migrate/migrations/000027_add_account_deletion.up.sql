@@ -0,0 +1,14 @@
+-- Deleting a user deletes their posts, comments on them included.
+ALTER TABLE posts DROP CONSTRAINT IF EXISTS fk_user;
+ALTER TABLE posts ADD CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+
+-- Set while an account waits for its deletion, which logging in again cancels.
+ALTER TABLE users ADD COLUMN deletion_scheduled_at timestamp(0) with time zone;
+CREATE INDEX IF NOT EXISTS idx_users_deletion_scheduled_at ON users (deletion_scheduled_at) WHERE deletion_scheduled_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS data_exports (
+  token_hash bytea PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  file_name text NOT NULL,
+  expiry timestamp(0) with time zone NOT NULL
+);
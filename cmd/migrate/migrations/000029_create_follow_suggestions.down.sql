@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS dismissed_suggestions;
+DROP TABLE IF EXISTS follow_suggestions;
@@ -0,0 +1,17 @@
+-- Recomputed periodically, see SuggestionStore.Recompute.
+CREATE TABLE IF NOT EXISTS follow_suggestions (
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  suggested_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  score double precision NOT NULL,
+  mutual_followers int NOT NULL DEFAULT 0,
+  PRIMARY KEY (user_id, suggested_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_follow_suggestions_score ON follow_suggestions (user_id, score DESC);
+
+CREATE TABLE IF NOT EXISTS dismissed_suggestions (
+  user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  dismissed_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (user_id, dismissed_id)
+);
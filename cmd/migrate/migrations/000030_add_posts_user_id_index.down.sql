@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_comments_post_id;
+DROP INDEX IF EXISTS idx_posts_user_id_created_at;
@@ -0,0 +1,3 @@
+-- Lists the posts of a user newest first, see PostsStore.GetByUser.
+CREATE INDEX IF NOT EXISTS idx_posts_user_id_created_at ON posts (user_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_comments_post_id ON comments (post_id);
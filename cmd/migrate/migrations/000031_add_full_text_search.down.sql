@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_comments_search_vector;
+DROP INDEX IF EXISTS idx_posts_search_vector;
+ALTER TABLE comments DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE posts DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE comments DROP COLUMN IF EXISTS language;
+ALTER TABLE posts DROP COLUMN IF EXISTS language;
@@ -0,0 +1,16 @@
+-- The text search configuration, hence the language, each post is indexed in.
+-- Comments are indexed in the language of their post.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS language regconfig NOT NULL DEFAULT 'english';
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS language regconfig NOT NULL DEFAULT 'english';
+
+-- Matches in titles rank above matches in contents.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector(language, coalesce(title, '')), 'A') ||
+	setweight(to_tsvector(language, coalesce(content, '')), 'B')
+) STORED;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+	to_tsvector(language, coalesce(content, ''))
+) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_posts_search_vector ON posts USING gin (search_vector);
+CREATE INDEX IF NOT EXISTS idx_comments_search_vector ON comments USING gin (search_vector);
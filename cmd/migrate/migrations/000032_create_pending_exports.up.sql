@@ -0,0 +1,5 @@
+-- An export being prepared, a user only gets one at a time.
+CREATE TABLE IF NOT EXISTS pending_exports (
+  user_id bigint PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  started_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
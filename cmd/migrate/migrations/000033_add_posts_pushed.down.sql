@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_posts_not_pushed;
+ALTER TABLE posts DROP COLUMN IF EXISTS pushed;
@@ -0,0 +1,5 @@
+-- Whether a post was pushed to the cached timelines of its author's followers.
+-- The ones that weren't, from authors with too many followers, are merged in
+-- when a timeline is read, whatever the follower count of the author since.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS pushed boolean NOT NULL DEFAULT TRUE;
+CREATE INDEX IF NOT EXISTS idx_posts_not_pushed ON posts (user_id, created_at DESC, id DESC) WHERE pushed = FALSE;
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Denylist keeps track of revoked access tokens, identified by their "jti"
+// claim, until they would have expired anyway. RevokeUser invalidates every
+// token of a user issued before the call, and RevokeSession every token of a
+// session ("sid" claim), for the lifetime ttl of a token.
+type Denylist interface {
+	Revoke(ctx context.Context, jti string, exp time.Time) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+	RevokeUser(ctx context.Context, userID int64, ttl time.Duration) error
+	IsUserRevoked(ctx context.Context, userID int64, issuedAt time.Time) (bool, error)
+	RevokeSession(ctx context.Context, sessionID string, ttl time.Duration) error
+	IsSessionRevoked(ctx context.Context, sessionID string) (bool, error)
+}
+
+// InMemoryDenylist is used when Redis is disabled. Entries only live as long as
+// the process, which is fine for a single instance in development.
+type InMemoryDenylist struct {
+	sync.RWMutex
+	tokens   map[string]time.Time
+	users    map[int64]userRevocation
+	sessions map[string]time.Time
+}
+
+type userRevocation struct {
+	at  time.Time // tokens issued before this instant are revoked
+	exp time.Time
+}
+
+func NewInMemoryDenylist() *InMemoryDenylist {
+	return &InMemoryDenylist{
+		tokens:   make(map[string]time.Time),
+		users:    make(map[int64]userRevocation),
+		sessions: make(map[string]time.Time),
+	}
+}
+
+func (d *InMemoryDenylist) Revoke(ctx context.Context, jti string, exp time.Time) error {
+	d.Lock()
+	defer d.Unlock()
+
+	// Drop the entries that expired on their own while we hold the lock.
+	now := time.Now()
+	for id, e := range d.tokens {
+		if now.After(e) {
+			delete(d.tokens, id)
+		}
+	}
+	d.tokens[jti] = exp
+
+	return nil
+}
+
+func (d *InMemoryDenylist) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	d.RLock()
+	exp, ok := d.tokens[jti]
+	d.RUnlock()
+
+	return ok && time.Now().Before(exp), nil
+}
+
+func (d *InMemoryDenylist) RevokeUser(ctx context.Context, userID int64, ttl time.Duration) error {
+	d.Lock()
+	defer d.Unlock()
+
+	now := time.Now()
+	for id, rev := range d.users {
+		if now.After(rev.exp) {
+			delete(d.users, id)
+		}
+	}
+	d.users[userID] = userRevocation{at: now.Truncate(time.Second), exp: now.Add(ttl)}
+
+	return nil
+}
+
+func (d *InMemoryDenylist) IsUserRevoked(ctx context.Context, userID int64, issuedAt time.Time) (bool, error) {
+	d.RLock()
+	rev, ok := d.users[userID]
+	d.RUnlock()
+
+	if !ok || time.Now().After(rev.exp) {
+		return false, nil
+	}
+
+	return issuedAt.Before(rev.at), nil
+}
+
+func (d *InMemoryDenylist) RevokeSession(ctx context.Context, sessionID string, ttl time.Duration) error {
+	d.Lock()
+	defer d.Unlock()
+
+	now := time.Now()
+	for id, exp := range d.sessions {
+		if now.After(exp) {
+			delete(d.sessions, id)
+		}
+	}
+	d.sessions[sessionID] = now.Add(ttl)
+
+	return nil
+}
+
+func (d *InMemoryDenylist) IsSessionRevoked(ctx context.Context, sessionID string) (bool, error) {
+	d.RLock()
+	exp, ok := d.sessions[sessionID]
+	d.RUnlock()
+
+	return ok && time.Now().Before(exp), nil
+}
@@ -1,7 +1,7 @@
 package auth
 
 import (
-	"fmt"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -9,16 +9,20 @@ import (
 // JWTAuthenticator holds the configuration for our JWT implementation.
 type JWTAuthenticator struct {
 	secret string
-	aud    string // "Audience" claim
-	iss    string // "Issuer" claim
+	tokenPolicy
 }
 
-func NewJWTAuthenticator(secret, aud, iss string) *JWTAuthenticator {
-	return &JWTAuthenticator{secret, iss, aud}
+func NewJWTAuthenticator(secret, aud, iss string, leeway time.Duration) *JWTAuthenticator {
+	return &JWTAuthenticator{secret: secret, tokenPolicy: tokenPolicy{aud: aud, iss: iss, leeway: leeway}}
+}
+
+// NewClaims returns the claims of a token for the given user, valid for ttl.
+func (a *JWTAuthenticator) NewClaims(userID int64, ttl time.Duration) *Claims {
+	return a.newClaims(userID, ttl)
 }
 
 // GenerateToken creates and signs a new JWT with the given claims.
-func (a *JWTAuthenticator) GenerateToken(claims jwt.Claims) (string, error) {
+func (a *JWTAuthenticator) GenerateToken(claims *Claims) (string, error) {
 	// We'll use the HS256 signing algorithm.
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
@@ -32,15 +36,16 @@ func (a *JWTAuthenticator) GenerateToken(claims jwt.Claims) (string, error) {
 }
 
 // ValidateToken parses and validates a token string.
-func (a *JWTAuthenticator) ValidateToken(token string) (*jwt.Token, error) {
-	// The library handles all the heavy lifting: checking the signature,
-	// the expiration time, and other claims.
-	return jwt.Parse(token, func(t *jwt.Token) (any, error) {
-		// This callback function ensures the token's signing algorithm is what we expect.
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
-		}
-
+func (a *JWTAuthenticator) ValidateToken(token string) (*Claims, error) {
+	// The library handles all the heavy lifting: checking the signature, the
+	// algorithm, and the expiration, issuer and audience claims.
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
 		return []byte(a.secret), nil
-	})
-}
\ No newline at end of file
+	}, a.parserOptions(jwt.SigningMethodHS256.Alg())...)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testSecret = "test-secret"
+	testAud    = "test-aud"
+	testIss    = "test-iss"
+)
+
+func TestJWTAuthenticatorValidateToken(t *testing.T) {
+	a := NewJWTAuthenticator(testSecret, testAud, testIss, 30*time.Second)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(method jwt.SigningMethod, key any, claims *Claims) string {
+		t.Helper()
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	claims := func(edit func(*Claims)) *Claims {
+		c := a.NewClaims(42, time.Minute)
+		if edit != nil {
+			edit(c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{
+			name:  "valid",
+			token: sign(jwt.SigningMethodHS256, []byte(testSecret), claims(nil)),
+			valid: true,
+		},
+		{
+			name:  "forged with another secret",
+			token: sign(jwt.SigningMethodHS256, []byte("not-the-secret"), claims(nil)),
+		},
+		{
+			name: "tampered payload",
+			token: func() string {
+				valid := sign(jwt.SigningMethodHS256, []byte(testSecret), claims(nil))
+				other := sign(jwt.SigningMethodHS256, []byte(testSecret), claims(func(c *Claims) { c.Subject = "1" }))
+				// The header and payload of one token with the signature of another.
+				return other[:strings.LastIndex(other, ".")] + valid[strings.LastIndex(valid, "."):]
+			}(),
+		},
+		{
+			name: "expired",
+			token: sign(jwt.SigningMethodHS256, []byte(testSecret), claims(func(c *Claims) {
+				c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+			})),
+		},
+		{
+			name: "expired within the leeway",
+			token: sign(jwt.SigningMethodHS256, []byte(testSecret), claims(func(c *Claims) {
+				c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-10 * time.Second))
+			})),
+			valid: true,
+		},
+		{
+			name: "without expiry",
+			token: sign(jwt.SigningMethodHS256, []byte(testSecret), claims(func(c *Claims) {
+				c.ExpiresAt = nil
+			})),
+		},
+		{
+			name: "not valid yet",
+			token: sign(jwt.SigningMethodHS256, []byte(testSecret), claims(func(c *Claims) {
+				c.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Hour))
+			})),
+		},
+		{
+			name: "wrong audience",
+			token: sign(jwt.SigningMethodHS256, []byte(testSecret), claims(func(c *Claims) {
+				c.Audience = jwt.ClaimStrings{"another-aud"}
+			})),
+		},
+		{
+			name: "wrong issuer",
+			token: sign(jwt.SigningMethodHS256, []byte(testSecret), claims(func(c *Claims) {
+				c.Issuer = "another-iss"
+			})),
+		},
+		{
+			name:  "wrong algorithm HS512",
+			token: sign(jwt.SigningMethodHS512, []byte(testSecret), claims(nil)),
+		},
+		{
+			name:  "wrong algorithm EdDSA",
+			token: sign(jwt.SigningMethodEdDSA, edKey, claims(nil)),
+		},
+		{
+			name:  "unsigned",
+			token: sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, claims(nil)),
+		},
+		{
+			name:  "garbage",
+			token: "not.a.token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := a.ValidateToken(tt.token)
+			if !tt.valid {
+				if err == nil {
+					t.Fatal("expected the token to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected a valid token, got %v", err)
+			}
+			if userID, err := got.UserID(); err != nil || userID != 42 {
+				t.Fatalf("UserID() = %d, %v; want 42", userID, err)
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticatorRoundTrip(t *testing.T) {
+	a := NewJWTAuthenticator(testSecret, testAud, testIss, 0)
+
+	claims := a.NewClaims(7, time.Minute)
+	claims.SessionID = "session"
+	token, err := a.GenerateToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := a.ValidateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SessionID != "session" || got.ID != claims.ID || got.Issuer != testIss {
+		t.Fatalf("claims did not survive the round trip: %+v", got)
+	}
+}
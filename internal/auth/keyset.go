@@ -0,0 +1,270 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// KeySetAuthenticator signs tokens with asymmetric keys (RS256 or EdDSA) and
+// verifies them with whichever key of the set the "kid" header points to, so
+// other services only need the public keys published as a JWKS.
+//
+// Keys are PEM files in a directory, the file name (without .pem) being the kid.
+// The private key with the greatest kid signs new tokens: naming keys after the
+// date they were created (20261001.pem) makes a new key take over while the
+// older ones keep verifying the tokens they signed. Files that only hold a
+// PUBLIC KEY are used for verification only.
+//
+// A new key is published in the JWKS right away but only signs once its file is
+// older than publishDelay, so other services have fetched it by then. Until
+// then the previous key keeps signing, unless there is none.
+type KeySetAuthenticator struct {
+	sync.RWMutex
+	tokenPolicy
+	dir          string
+	publishDelay time.Duration
+	keys         map[string]*keyPair
+	signers      []*keyPair // sorted by kid
+}
+
+type keyPair struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer // nil for verification-only keys
+	public  crypto.PublicKey
+	signsAt time.Time // when the key may start signing
+}
+
+// JWK is the public part of a key, as published in a JSON Web Key Set (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewKeySetAuthenticator loads the keys of dir. publishDelay must cover the time
+// it takes for a new key to reach the services verifying our tokens: how long
+// the JWKS is cached plus how often the key set is reloaded.
+func NewKeySetAuthenticator(dir, aud, iss string, leeway, publishDelay time.Duration) (*KeySetAuthenticator, error) {
+	a := &KeySetAuthenticator{dir: dir, publishDelay: publishDelay, tokenPolicy: tokenPolicy{aud: aud, iss: iss, leeway: leeway}}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Reload reads the key directory again and swaps the key set in one go.
+// On error the current keys are kept.
+func (a *KeySetAuthenticator) Reload() error {
+	files, err := filepath.Glob(filepath.Join(a.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*keyPair, len(files))
+	var signers []*keyPair
+
+	sort.Strings(files)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		key, err := parseKeyPair(kid, data)
+		if err != nil {
+			return fmt.Errorf("loading key %s: %w", file, err)
+		}
+		key.signsAt = info.ModTime().Add(a.publishDelay)
+
+		keys[kid] = key
+		if key.private != nil {
+			signers = append(signers, key) // files are sorted, so are the signers
+		}
+	}
+
+	if len(signers) == 0 {
+		return fmt.Errorf("no private key found in %s", a.dir)
+	}
+
+	a.Lock()
+	a.keys = keys
+	a.signers = signers
+	a.Unlock()
+
+	return nil
+}
+
+// ReloadEvery reloads the key set on every tick until ctx is done, which is how
+// newly provisioned keys get published without a restart.
+func (a *KeySetAuthenticator) ReloadEvery(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.Reload(); err != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// NewClaims returns the claims of a token for the given user, valid for ttl.
+func (a *KeySetAuthenticator) NewClaims(userID int64, ttl time.Duration) *Claims {
+	return a.newClaims(userID, ttl)
+}
+
+// signingKey returns the private key with the greatest kid that was published
+// long enough ago, or the one with the greatest kid when none was.
+func (a *KeySetAuthenticator) signingKey(now time.Time) *keyPair {
+	a.RLock()
+	defer a.RUnlock()
+
+	for i := len(a.signers) - 1; i >= 0; i-- {
+		if !now.Before(a.signers[i].signsAt) {
+			return a.signers[i]
+		}
+	}
+	return a.signers[len(a.signers)-1]
+}
+
+// GenerateToken signs the claims with the current signing key and sets its kid.
+func (a *KeySetAuthenticator) GenerateToken(claims *Claims) (string, error) {
+	key := a.signingKey(time.Now())
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+
+	return token.SignedString(key.private)
+}
+
+// ValidateToken parses a token and checks its signature with the key named in its header.
+func (a *KeySetAuthenticator) ValidateToken(token string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, ok := t.Header["kid"].(string)
+		if !ok {
+			return nil, errors.New("token has no kid header")
+		}
+
+		a.RLock()
+		key, ok := a.keys[kid]
+		a.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+
+		// The algorithm must be the one of the key, not whatever the token claims.
+		if t.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+
+		return key.public, nil
+	}, a.parserOptions(jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg())...)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// JWKS returns the public keys of the set, signing key included.
+func (a *KeySetAuthenticator) JWKS() JWKS {
+	a.RLock()
+	defer a.RUnlock()
+
+	set := JWKS{Keys: make([]JWK, 0, len(a.keys))}
+	for _, key := range a.keys {
+		jwk := JWK{Kid: key.kid, Use: "sig", Alg: key.method.Alg()}
+
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+
+	return set
+}
+
+// parseKeyPair accepts RSA (PKCS#1 or PKCS#8) and Ed25519 (PKCS#8) private keys,
+// or a PKIX public key for verification-only entries.
+func parseKeyPair(kid string, data []byte) (*keyPair, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &keyPair{kid: kid, method: jwt.SigningMethodRS256, private: k, public: &k.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &keyPair{kid: kid, method: jwt.SigningMethodEdDSA, private: k, public: k.Public()}, nil
+	case *rsa.PublicKey:
+		return &keyPair{kid: kid, method: jwt.SigningMethodRS256, public: k}, nil
+	case ed25519.PublicKey:
+		return &keyPair{kid: kid, method: jwt.SigningMethodEdDSA, public: k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+}
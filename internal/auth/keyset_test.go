@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// writeKey writes key in dir as kid.pem, dated age ago. Private keys are
+// written as PKCS#8, public keys as PKIX.
+func writeKey(t *testing.T, dir, kid string, key any, age time.Duration) {
+	t.Helper()
+
+	var block *pem.Block
+	switch key.(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	default:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+
+	file := filepath.Join(dir, kid+".pem")
+	if err := os.WriteFile(file, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	date := time.Now().Add(-age)
+	if err := os.Chtimes(file, date, date); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newEd25519Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// signingKid returns the kid of the key a new token is signed with.
+func signingKid(t *testing.T, a *KeySetAuthenticator) string {
+	t.Helper()
+
+	token, err := a.GenerateToken(a.NewClaims(42, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.ValidateToken(token); err != nil {
+		t.Fatalf("validating a token we signed: %v", err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func TestKeySetSigningKey(t *testing.T) {
+	const publishDelay = time.Hour
+	dir := t.TempDir()
+
+	writeKey(t, dir, "20260101", newEd25519Key(t), 48*time.Hour)
+	writeKey(t, dir, "20260201", newRSAKey(t), 24*time.Hour)
+	// Only the public half of the newest key is here, it must never sign.
+	writeKey(t, dir, "20260301", newEd25519Key(t).Public(), 24*time.Hour)
+
+	a, err := NewKeySetAuthenticator(dir, testAud, testIss, 0, publishDelay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := signingKid(t, a); kid != "20260201" {
+		t.Fatalf("signing with %q, want the greatest private kid 20260201", kid)
+	}
+
+	// A key just provisioned is published but doesn't sign yet.
+	writeKey(t, dir, "20260401", newEd25519Key(t), 0)
+	if err := a.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if kid := signingKid(t, a); kid != "20260201" {
+		t.Fatalf("signing with %q before the new key was published long enough, want 20260201", kid)
+	}
+	if !hasKid(a.JWKS(), "20260401") {
+		t.Fatal("the new key isn't published")
+	}
+
+	// Once the JWKS caches had time to pick it up, it takes over.
+	writeKey(t, dir, "20260401", newEd25519Key(t), publishDelay)
+	if err := a.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if kid := signingKid(t, a); kid != "20260401" {
+		t.Fatalf("signing with %q, want 20260401", kid)
+	}
+}
+
+func TestKeySetFirstKeySignsRightAway(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "20260101", newEd25519Key(t), 0)
+
+	a, err := NewKeySetAuthenticator(dir, testAud, testIss, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := signingKid(t, a); kid != "20260101" {
+		t.Fatalf("signing with %q, want 20260101", kid)
+	}
+}
+
+func TestKeySetWithoutPrivateKey(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "20260101", newEd25519Key(t).Public(), time.Hour)
+
+	if _, err := NewKeySetAuthenticator(dir, testAud, testIss, 0, time.Hour); err == nil {
+		t.Fatal("loaded a key set without any private key")
+	}
+}
+
+func TestKeySetValidateToken(t *testing.T) {
+	dir := t.TempDir()
+	edKey := newEd25519Key(t)
+	rsaKey := newRSAKey(t)
+	writeKey(t, dir, "ed", edKey, time.Hour)
+	writeKey(t, dir, "rsa", rsaKey, 2*time.Hour)
+
+	a, err := NewKeySetAuthenticator(dir, testAud, testIss, 0, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(method jwt.SigningMethod, key any, kid any) string {
+		t.Helper()
+		token := jwt.NewWithClaims(method, a.NewClaims(42, time.Minute))
+		if kid != nil {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{name: "EdDSA", token: sign(jwt.SigningMethodEdDSA, edKey, "ed"), valid: true},
+		{name: "RS256", token: sign(jwt.SigningMethodRS256, rsaKey, "rsa"), valid: true},
+		{name: "unknown kid", token: sign(jwt.SigningMethodEdDSA, edKey, "unknown")},
+		{name: "no kid", token: sign(jwt.SigningMethodEdDSA, edKey, nil)},
+		{name: "kid of another key", token: sign(jwt.SigningMethodEdDSA, edKey, "rsa")},
+		{name: "signed by a key not in the set", token: sign(jwt.SigningMethodEdDSA, newEd25519Key(t), "ed")},
+		{
+			// The RSA public key, known to everyone, used as an HMAC secret.
+			name: "HS256 with an RSA kid",
+			token: func() string {
+				der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return sign(jwt.SigningMethodHS256, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), "rsa")
+			}(),
+		},
+		{name: "RS256 with an EdDSA kid", token: sign(jwt.SigningMethodRS256, rsaKey, "ed")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.ValidateToken(tt.token)
+			if tt.valid && err != nil {
+				t.Fatalf("want valid, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatal("want an error, got a valid token")
+			}
+		})
+	}
+}
+
+func TestKeySetJWKS(t *testing.T) {
+	dir := t.TempDir()
+	edKey := newEd25519Key(t)
+	rsaKey := newRSAKey(t)
+	writeKey(t, dir, "ed", edKey, time.Hour)
+	writeKey(t, dir, "rsa", rsaKey.Public(), time.Hour)
+
+	a, err := NewKeySetAuthenticator(dir, testAud, testIss, 0, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := a.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("got %d keys, want 2", len(set.Keys))
+	}
+
+	edJWK, rsaJWK := set.Keys[0], set.Keys[1]
+	if edJWK.Kid != "ed" || edJWK.Kty != "OKP" || edJWK.Crv != "Ed25519" || edJWK.Alg != "EdDSA" || edJWK.Use != "sig" || edJWK.X == "" || edJWK.N != "" {
+		t.Fatalf("got Ed25519 key %+v", edJWK)
+	}
+	if rsaJWK.Kid != "rsa" || rsaJWK.Kty != "RSA" || rsaJWK.Alg != "RS256" || rsaJWK.Use != "sig" || rsaJWK.N == "" || rsaJWK.E != "AQAB" || rsaJWK.X != "" {
+		t.Fatalf("got RSA key %+v", rsaJWK)
+	}
+}
+
+func hasKid(set JWKS, kid string) bool {
+	for _, key := range set.Keys {
+		if key.Kid == kid {
+			return true
+		}
+	}
+	return false
+}
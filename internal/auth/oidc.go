@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Identity is what a provider tells us about the user who signed in with it.
+type Identity struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Username      string `json:"preferred_username"`
+}
+
+// Provider is an external identity provider users can sign in with, through
+// the authorization code flow with PKCE.
+type Provider interface {
+	Name() string
+	// AuthCodeURL returns the URL the user is sent to, to log in at the provider.
+	AuthCodeURL(state, codeChallenge string) string
+	// Exchange trades the code the provider redirected back with for an access token.
+	Exchange(ctx context.Context, code, codeVerifier string) (string, error)
+	// UserInfo returns the identity the access token was issued for.
+	UserInfo(ctx context.Context, accessToken string) (*Identity, error)
+}
+
+type OIDCConfig struct {
+	Name         string
+	Issuer       string // the provider's endpoints are discovered from the issuer
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string // defaults to openid, email and profile
+}
+
+// OIDCProvider is a Provider for any OpenID Connect compliant identity provider.
+type OIDCProvider struct {
+	cfg         OIDCConfig
+	client      *http.Client
+	authURL     string
+	tokenURL    string
+	userInfoURL string
+}
+
+// NewOIDCProvider reads the provider's endpoints from its discovery document.
+func NewOIDCProvider(ctx context.Context, cfg OIDCConfig, client *http.Client) (*OIDCProvider, error) {
+	if client == nil {
+		client = &http.Client{Timeout: time.Second * 10}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	discoveryURL := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	}
+	if err := doJSON(client, req, &doc); err != nil {
+		return nil, fmt.Errorf("oidc discovery for %s: %w", cfg.Name, err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != strings.TrimSuffix(cfg.Issuer, "/") {
+		return nil, fmt.Errorf("oidc discovery for %s: issuer %q does not match", cfg.Name, doc.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.UserInfoEndpoint == "" {
+		return nil, fmt.Errorf("oidc discovery for %s: missing endpoints", cfg.Name)
+	}
+
+	return &OIDCProvider{
+		cfg:         cfg,
+		client:      client,
+		authURL:     doc.AuthorizationEndpoint,
+		tokenURL:    doc.TokenEndpoint,
+		userInfoURL: doc.UserInfoEndpoint,
+	}, nil
+}
+
+func (p *OIDCProvider) Name() string { return p.cfg.Name }
+
+func (p *OIDCProvider) AuthCodeURL(state, codeChallenge string) string {
+	v := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(p.authURL, "?") {
+		sep = "&"
+	}
+	return p.authURL + sep + v.Encode()
+}
+
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+	}
+	if err := doJSON(p.client, req, &token); err != nil {
+		return "", fmt.Errorf("oidc code exchange with %s: %w", p.cfg.Name, err)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("oidc code exchange with %s: no access token", p.cfg.Name)
+	}
+
+	return token.AccessToken, nil
+}
+
+func (p *OIDCProvider) UserInfo(ctx context.Context, accessToken string) (*Identity, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.userInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	var identity Identity
+	if err := doJSON(p.client, req, &identity); err != nil {
+		return nil, fmt.Errorf("oidc userinfo from %s: %w", p.cfg.Name, err)
+	}
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("oidc userinfo from %s: no subject", p.cfg.Name)
+	}
+
+	return &identity, nil
+}
+
+// doJSON sends the request and decodes the JSON answer into v.
+func doJSON(client *http.Client, req *http.Request, v any) error {
+	req.Header.Set("Accept", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	return json.NewDecoder(http.MaxBytesReader(nil, res.Body, 1<<20)).Decode(v)
+}
+
+// GeneratePKCE returns a random code verifier and its S256 code challenge (RFC 7636).
+func GeneratePKCE() (verifier, challenge string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	verifier = base64.RawURLEncoding.EncodeToString(b)
+	return verifier, PKCEChallenge(verifier), nil
+}
+
+// PKCEChallenge derives the S256 code challenge of a code verifier.
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// GenerateState returns a random value binding a provider's callback to the
+// login it was started for.
+func GenerateState() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
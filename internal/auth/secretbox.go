@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+)
+
+// SecretBox encrypts small secrets, like TOTP seeds, with AES-256-GCM before
+// they are stored. The sealed value is the nonce followed by the ciphertext.
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox derives a 256 bit key from the configured passphrase.
+func NewSecretBox(key string) (*SecretBox, error) {
+	sum := sha256.Sum256([]byte(key))
+
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecretBox{aead: aead}, nil
+}
+
+func (b *SecretBox) Seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return b.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (b *SecretBox) Open(sealed []byte) ([]byte, error) {
+	size := b.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, errors.New("sealed secret is too short")
+	}
+
+	return b.aead.Open(nil, sealed[:size], sealed[size:], nil)
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 secret of the test vectors of RFC 6238, "12345678901234567890".
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// fakeClock is a clock tests move by hand.
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	// The RFC lists 8 digit codes, ours are their last 6 digits.
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		clock := &fakeClock{now: time.Unix(tt.unix, 0)}
+		totp := NewTOTP("test", clock.Now)
+
+		code, err := totp.Code(rfcSecret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("Code() at %d = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestTOTPValidate(t *testing.T) {
+	start := time.Unix(1234567890, 0)
+	clock := &fakeClock{now: start}
+	totp := NewTOTP("test", clock.Now)
+
+	code, err := totp.Code(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStep := start.Unix() / totpPeriod
+
+	tests := []struct {
+		name  string
+		now   time.Time
+		code  string
+		valid bool
+	}{
+		{"current step", start, code, true},
+		{"one step later", start.Add(totpPeriod * time.Second), code, true},
+		{"one step earlier", start.Add(-totpPeriod * time.Second), code, true},
+		{"two steps later", start.Add(2 * totpPeriod * time.Second), code, false},
+		{"two steps earlier", start.Add(-2 * totpPeriod * time.Second), code, false},
+		{"wrong code", start, "000000", false},
+		{"too short", start, code[:5], false},
+		{"too long", start, code + "0", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.now = tt.now
+
+			step, ok := totp.Validate(rfcSecret, tt.code)
+			if ok != tt.valid {
+				t.Fatalf("Validate() = %v, want %v", ok, tt.valid)
+			}
+			if ok && step != wantStep {
+				t.Fatalf("Validate() matched step %d, want %d", step, wantStep)
+			}
+		})
+	}
+}
+
+func TestTOTPValidateRejectsABadSecret(t *testing.T) {
+	totp := NewTOTP("test", (&fakeClock{now: time.Unix(59, 0)}).Now)
+
+	if _, ok := totp.Validate("not base32!", "287082"); ok {
+		t.Fatal("a code was accepted for an invalid secret")
+	}
+}
+
+func TestTOTPGeneratedSecret(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	totp := NewTOTP("test", clock.Now)
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.Code(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := totp.Validate(secret, code); !ok {
+		t.Fatal("the code of a generated secret was refused")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	totp := NewTOTP("GO Social", nil)
+
+	u, err := url.Parse(totp.URI("user@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" {
+		t.Fatalf("unexpected URI %s", u)
+	}
+	if u.Path != "/GO Social:user@example.com" {
+		t.Errorf("label = %q", u.Path)
+	}
+
+	q := u.Query()
+	for key, want := range map[string]string{"secret": rfcSecret, "issuer": "GO Social", "digits": "6", "period": "30", "algorithm": "SHA1"} {
+		if got := q.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("got %d codes, want 10", len(codes))
+	}
+
+	format := regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`)
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("code %q is not formatted as xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q was generated twice", code)
+		}
+		seen[code] = true
+	}
+}
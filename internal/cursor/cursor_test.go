@@ -0,0 +1,42 @@
+package cursor
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Har2yQn78/social_back.git/internal/store"
+)
+
+func TestCodec(t *testing.T) {
+	c := NewCodec("secret")
+	cur := store.Cursor{CreatedAt: "2024-05-01T10:00:00Z", ID: 42, Backward: true}
+	token := c.Encode("/v1/users/1/followers", cur)
+
+	got, err := c.Decode("/v1/users/1/followers", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != cur {
+		t.Fatalf("Decode() = %+v, want %+v", *got, cur)
+	}
+
+	tests := []struct {
+		name  string
+		codec *Codec
+		scope string
+		token string
+	}{
+		{"another list", c, "/v1/users/2/followers", token},
+		{"another key", NewCodec("another secret"), "/v1/users/1/followers", token},
+		{"tampered payload", c, "/v1/users/1/followers", c.Encode("/v1/users/1/followers", store.Cursor{ID: 1})[:10] + token[10:]},
+		{"without signature", c, "/v1/users/1/followers", token[:len(token)-44]},
+		{"garbage", c, "/v1/users/1/followers", "not-a-cursor"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.codec.Decode(tt.scope, tt.token); !errors.Is(err, ErrInvalid) {
+				t.Fatalf("got %v, want ErrInvalid", err)
+			}
+		})
+	}
+}
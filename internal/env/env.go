@@ -3,6 +3,7 @@ package env
 import (
 	"os"
 	"strconv"
+	"time"
 )
 
 func GetString(key, fallback string) string {
@@ -40,4 +41,17 @@ func GetBool(key string, fallback bool) bool {
 	}
 
 	return valAsBool
-}
\ No newline at end of file
+}
+func GetDuration(key string, fallback time.Duration) time.Duration {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	valAsDuration, err := time.ParseDuration(val)
+	if err != nil {
+		return fallback
+	}
+
+	return valAsDuration
+}
@@ -0,0 +1,40 @@
+// Package notifier tells users about what happens around their account.
+package notifier
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+const (
+	FollowRequested = "follow_requested" // someone asked to follow a private account
+	FollowApproved  = "follow_approved"  // the owner of a private account approved a request
+)
+
+// Notification is addressed to UserID, about something ActorID did.
+type Notification struct {
+	Type    string
+	UserID  int64
+	ActorID int64
+}
+
+// Notifier delivers notifications. Delivery must not fail the request that
+// triggered it, so callers only log the errors.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// LogNotifier only logs the notifications, until they are delivered by email or push.
+type LogNotifier struct {
+	logger *zap.SugaredLogger
+}
+
+func NewLogNotifier(logger *zap.SugaredLogger) *LogNotifier {
+	return &LogNotifier{logger: logger}
+}
+
+func (n *LogNotifier) Notify(ctx context.Context, notification Notification) error {
+	n.logger.Infow("notification", "type", notification.Type, "user_id", notification.UserID, "actor_id", notification.ActorID)
+	return nil
+}
@@ -0,0 +1,110 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// FailureTracker counts failed attempts per key (an account, an IP) and locks
+// a key out, with an exponential backoff, once too many attempts failed.
+type FailureTracker interface {
+	// Locked returns how long the key is still locked out for, 0 if it isn't.
+	Locked(ctx context.Context, key string) (time.Duration, error)
+	// Fail records a failed attempt and returns the number of failures in the
+	// current window and the lockout it triggered, 0 if none.
+	Fail(ctx context.Context, key string) (int, time.Duration, error)
+	// Reset forgets the failures of the key and lifts its lockout.
+	Reset(ctx context.Context, key string) error
+}
+
+type LockoutConfig struct {
+	MaxAttempts int           // failures allowed before the first lockout
+	BaseLockout time.Duration // first lockout, doubled on every further failure
+	MaxLockout  time.Duration
+	Window      time.Duration // failures older than this are forgotten
+}
+
+// Backoff returns the lockout for the given number of failures.
+func (c LockoutConfig) Backoff(failures int) time.Duration {
+	if failures < c.MaxAttempts {
+		return 0
+	}
+
+	lockout := c.BaseLockout
+	for i := c.MaxAttempts; i < failures && lockout < c.MaxLockout; i++ {
+		lockout *= 2
+	}
+
+	return min(lockout, c.MaxLockout)
+}
+
+// InMemoryFailureTracker is used when Redis is disabled.
+type InMemoryFailureTracker struct {
+	sync.Mutex
+	cfg     LockoutConfig
+	entries map[string]*failureEntry
+}
+
+type failureEntry struct {
+	failures    int
+	lockedUntil time.Time
+	expires     time.Time // when the entry is forgotten
+}
+
+func NewInMemoryFailureTracker(cfg LockoutConfig) *InMemoryFailureTracker {
+	return &InMemoryFailureTracker{cfg: cfg, entries: make(map[string]*failureEntry)}
+}
+
+func (t *InMemoryFailureTracker) Locked(ctx context.Context, key string) (time.Duration, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	e, ok := t.entries[key]
+	if !ok {
+		return 0, nil
+	}
+
+	return max(time.Until(e.lockedUntil), 0), nil
+}
+
+func (t *InMemoryFailureTracker) Fail(ctx context.Context, key string) (int, time.Duration, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	now := time.Now()
+	t.prune(now)
+
+	e, ok := t.entries[key]
+	if !ok {
+		e = &failureEntry{expires: now.Add(t.cfg.Window)}
+		t.entries[key] = e
+	}
+	e.failures++
+
+	lockout := t.cfg.Backoff(e.failures)
+	if lockout > 0 {
+		e.lockedUntil = now.Add(lockout)
+		// Keep counting past the lockout so the next failure doubles it.
+		e.expires = e.lockedUntil.Add(t.cfg.Window)
+	}
+
+	return e.failures, lockout, nil
+}
+
+func (t *InMemoryFailureTracker) Reset(ctx context.Context, key string) error {
+	t.Lock()
+	delete(t.entries, key)
+	t.Unlock()
+
+	return nil
+}
+
+// prune drops the entries that expired.
+func (t *InMemoryFailureTracker) prune(now time.Time) {
+	for key, e := range t.entries {
+		if now.After(e.expires) {
+			delete(t.entries, key)
+		}
+	}
+}
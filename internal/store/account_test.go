@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestScheduleDeletion(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := NewStorage(db)
+
+	alice := createTestUser(t, db, "alice")
+	developer := createTestUser(t, db, "developer")
+
+	if err := s.PersonalTokens.Create(ctx, &PersonalAccessToken{UserID: alice.ID, Name: "bot", Scopes: []string{"posts:read"}}, "pat"); err != nil {
+		t.Fatal(err)
+	}
+	client := &OAuthClient{ClientID: "app", Name: "App", RedirectURIs: []string{"https://app.example.com"}, OwnerID: developer.ID}
+	if err := s.OAuth.CreateClient(ctx, client, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	code := &OAuthCode{ClientID: "app", UserID: alice.ID, RedirectURI: "https://app.example.com", Scopes: []string{"posts:read"}, CodeChallenge: "challenge"}
+	if err := s.OAuth.Authorize(ctx, code, "code", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	session := &Session{ID: "d5a2c0a4-4a3c-4a8e-9b8e-2f1c1f5d9e01", UserID: alice.ID}
+	if err := s.Sessions.Create(ctx, session, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Users.ScheduleDeletion(ctx, alice.ID, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Users.GetByID(ctx, alice.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetByID: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.Users.GetForLogin(ctx, alice.ID); err != nil {
+		t.Fatalf("GetForLogin: %v", err)
+	}
+	if _, err := s.Users.GetByEmail(ctx, alice.Email); err != nil {
+		t.Fatalf("GetByEmail: %v", err)
+	}
+
+	if _, err := s.PersonalTokens.Authenticate(ctx, "pat"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("personal access token: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.OAuth.GetGrant(ctx, alice.ID, "app"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("grant: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.OAuth.ConsumeCode(ctx, "code"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("authorization code: got %v, want ErrNotFound", err)
+	}
+	sessions, err := s.Sessions.GetByUserID(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 0 {
+		t.Fatalf("got %d sessions, want none", len(sessions))
+	}
+
+	canceled, err := s.Users.CancelDeletion(ctx, alice.ID)
+	if err != nil || !canceled {
+		t.Fatalf("CancelDeletion() = %v, %v; want true", canceled, err)
+	}
+	if _, err := s.Users.GetByID(ctx, alice.ID); err != nil {
+		t.Fatalf("GetByID after canceling: %v", err)
+	}
+}
+
+func TestExportsStart(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := NewStorage(db)
+
+	alice := createTestUser(t, db, "alice")
+
+	if err := s.Exports.Start(ctx, alice.ID, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Exports.Start(ctx, alice.ID, time.Hour); !errors.Is(err, ErrConflict) {
+		t.Fatalf("second export: got %v, want ErrConflict", err)
+	}
+
+	// An export older than staleAfter was abandoned, a new one takes its place.
+	if _, err := db.ExecContext(ctx, `UPDATE pending_exports SET started_at = NOW() - INTERVAL '2 hours'`); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Exports.Start(ctx, alice.ID, time.Hour); err != nil {
+		t.Fatalf("abandoned export: %v", err)
+	}
+
+	if err := s.Exports.Create(ctx, alice.ID, "alice.zip", "token", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Exports.Start(ctx, alice.ID, time.Hour); err != nil {
+		t.Fatalf("export after the last one finished: %v", err)
+	}
+
+	if err := s.Exports.Cancel(ctx, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Exports.Start(ctx, alice.ID, time.Hour); err != nil {
+		t.Fatalf("export after the last one failed: %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Events recorded in the audit log.
+const (
+	AuditLoginSucceeded    = "login_succeeded"
+	AuditLoginFailed       = "login_failed"
+	AuditLoginBlocked      = "login_blocked"
+	AuditAccountLocked     = "account_locked"
+	AuditAccountUnlocked   = "account_unlocked"
+	AuditDeletionScheduled = "deletion_scheduled"
+	AuditDeletionCanceled  = "deletion_canceled"
+)
+
+// AuditEntry records a security relevant event. UserID is nil when the event
+// can't be tied to an account, e.g. a login with an unknown email.
+type AuditEntry struct {
+	ID        int64  `json:"id"`
+	UserID    *int64 `json:"user_id"`
+	Event     string `json:"event"`
+	Email     string `json:"email"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	CreatedAt string `json:"created_at"`
+}
+
+type AuditStore struct {
+	db *sql.DB
+}
+
+func (s *AuditStore) Log(ctx context.Context, entry *AuditEntry) error {
+	query := `
+		INSERT INTO audit_log (user_id, event, email, ip, user_agent)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5) RETURNING id, created_at
+	`
+	return s.db.QueryRowContext(ctx, query, entry.UserID, entry.Event, entry.Email, entry.IP, entry.UserAgent).Scan(&entry.ID, &entry.CreatedAt)
+}
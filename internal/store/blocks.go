@@ -0,0 +1,144 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// BlockStore keeps who blocked and who muted whom. A block cuts every tie
+// between two users, both ways; a mute only hides the muted user from the muter.
+type BlockStore struct {
+	db *sql.DB
+}
+
+// Block blocks blockedID for userID and drops the follows and follow requests
+// between them, both ways. It fails with ErrConflict when the user is already
+// blocked and with ErrNotFound when there is no such user.
+func (s *BlockStore) Block(ctx context.Context, userID, blockedID int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `INSERT INTO blocks (user_id, blocked_id) VALUES ($1, $2)`
+		if _, err := tx.ExecContext(ctx, query, userID, blockedID); err != nil {
+			if pqErr, ok := err.(*pq.Error); ok {
+				switch pqErr.Code {
+				case "23505":
+					return ErrConflict
+				case "23503":
+					return ErrNotFound
+				}
+			}
+			return err
+		}
+
+		query = `
+			DELETE FROM followers
+			WHERE (user_id = $1 AND follower_id = $2) OR (user_id = $2 AND follower_id = $1)
+		`
+		if _, err := tx.ExecContext(ctx, query, userID, blockedID); err != nil { return err }
+
+		query = `
+			DELETE FROM follow_requests
+			WHERE (user_id = $1 AND requester_id = $2) OR (user_id = $2 AND requester_id = $1)
+		`
+		_, err := tx.ExecContext(ctx, query, userID, blockedID)
+		return err
+	})
+}
+
+func (s *BlockStore) Unblock(ctx context.Context, userID, blockedID int64) error {
+	query := `DELETE FROM blocks WHERE user_id = $1 AND blocked_id = $2`
+	return s.deleteOne(ctx, query, userID, blockedID)
+}
+
+// IsBlocked reports whether either user blocked the other.
+func (s *BlockStore) IsBlocked(ctx context.Context, userID, otherID int64) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM blocks
+			WHERE (user_id = $1 AND blocked_id = $2) OR (user_id = $2 AND blocked_id = $1)
+		)
+	`
+
+	var blocked bool
+	err := s.db.QueryRowContext(ctx, query, userID, otherID).Scan(&blocked)
+	return blocked, err
+}
+
+// GetBlocked lists the users blocked by the user, most recent first.
+func (s *BlockStore) GetBlocked(ctx context.Context, userID int64, pq PaginatedQuery) ([]PublicUser, Page, error) {
+	cond, order := keyset(pq.Cursor, "b.created_at", "u.id", true, 5)
+	query := `
+		SELECT ` + publicUserColumns + `, b.created_at
+		FROM blocks b
+		JOIN users u ON u.id = b.blocked_id
+		WHERE b.user_id = $1 AND ` + cond + `
+		ORDER BY ` + order + `
+		LIMIT $3 OFFSET $4
+	`
+	return listPublicUsersPage(ctx, s.db, query, userID, userID, pq)
+}
+
+// Mute hides the posts and comments of mutedID from userID. It fails with
+// ErrConflict when the user is already muted and with ErrNotFound when there
+// is no such user.
+func (s *BlockStore) Mute(ctx context.Context, userID, mutedID int64) error {
+	query := `INSERT INTO mutes (user_id, muted_id) VALUES ($1, $2)`
+
+	_, err := s.db.ExecContext(ctx, query, userID, mutedID)
+	if pqErr, ok := err.(*pq.Error); ok {
+		switch pqErr.Code {
+		case "23505":
+			return ErrConflict
+		case "23503":
+			return ErrNotFound
+		}
+	}
+	return err
+}
+
+func (s *BlockStore) Unmute(ctx context.Context, userID, mutedID int64) error {
+	query := `DELETE FROM mutes WHERE user_id = $1 AND muted_id = $2`
+	return s.deleteOne(ctx, query, userID, mutedID)
+}
+
+// GetMuted lists the users muted by the user, most recent first.
+func (s *BlockStore) GetMuted(ctx context.Context, userID int64, pq PaginatedQuery) ([]PublicUser, Page, error) {
+	cond, order := keyset(pq.Cursor, "m.created_at", "u.id", true, 5)
+	query := `
+		SELECT ` + publicUserColumns + `, m.created_at
+		FROM mutes m
+		JOIN users u ON u.id = m.muted_id
+		WHERE m.user_id = $1 AND ` + cond + `
+		ORDER BY ` + order + `
+		LIMIT $3 OFFSET $4
+	`
+	return listPublicUsersPage(ctx, s.db, query, userID, userID, pq)
+}
+
+func (s *BlockStore) deleteOne(ctx context.Context, query string, args ...any) error {
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// hiddenAuthorCondition filters out the rows whose author, given by column, is
+// muted by the viewer or blocked either way. The viewer's ID must be bound to $1.
+func hiddenAuthorCondition(column string) string {
+	return `
+		NOT EXISTS (SELECT 1 FROM mutes m WHERE m.user_id = $1 AND m.muted_id = ` + column + `) AND
+		NOT EXISTS (
+			SELECT 1 FROM blocks b
+			WHERE (b.user_id = $1 AND b.blocked_id = ` + column + `) OR (b.user_id = ` + column + ` AND b.blocked_id = $1)
+		)
+	`
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestBlock(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := NewStorage(db)
+
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+	carol := createTestUser(t, db, "carol")
+
+	// alice and bob follow each other, carol follows both and bob asked carol.
+	for _, f := range [][2]int64{{alice.ID, bob.ID}, {bob.ID, alice.ID}, {alice.ID, carol.ID}, {bob.ID, carol.ID}} {
+		if err := s.Followers.Follow(ctx, f[0], f[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Followers.Request(ctx, carol.ID, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Blocks.Block(ctx, alice.ID, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Blocks.Block(ctx, alice.ID, bob.ID); !errors.Is(err, ErrConflict) {
+		t.Fatalf("blocking twice: got %v, want ErrConflict", err)
+	}
+	if err := s.Blocks.Block(ctx, alice.ID, -1); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("blocking an unknown user: got %v, want ErrNotFound", err)
+	}
+
+	// The follows go in both directions, the others are kept.
+	for _, f := range [][2]int64{{alice.ID, bob.ID}, {bob.ID, alice.ID}} {
+		if following, err := s.Followers.IsFollowing(ctx, f[0], f[1]); err != nil || following {
+			t.Fatalf("IsFollowing(%d, %d) after a block = %v, %v; want false", f[0], f[1], following, err)
+		}
+	}
+	assertCounts(t, s, alice.ID, Counts{Followers: 1})
+	assertCounts(t, s, bob.ID, Counts{Followers: 1})
+	assertCounts(t, s, carol.ID, Counts{Following: 2})
+
+	for _, pair := range [][2]int64{{alice.ID, bob.ID}, {bob.ID, alice.ID}} {
+		if blocked, err := s.Blocks.IsBlocked(ctx, pair[0], pair[1]); err != nil || !blocked {
+			t.Fatalf("IsBlocked(%d, %d) = %v, %v; want true", pair[0], pair[1], blocked, err)
+		}
+	}
+
+	t.Run("pending follow requests", func(t *testing.T) {
+		if err := s.Blocks.Block(ctx, carol.ID, bob.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Followers.ApproveRequest(ctx, carol.ID, bob.ID); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("approving the request of a blocked user: got %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("unblock", func(t *testing.T) {
+		if err := s.Blocks.Unblock(ctx, alice.ID, bob.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Blocks.Unblock(ctx, alice.ID, bob.ID); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("unblocking twice: got %v, want ErrNotFound", err)
+		}
+		if blocked, err := s.Blocks.IsBlocked(ctx, alice.ID, bob.ID); err != nil || blocked {
+			t.Fatalf("IsBlocked after Unblock = %v, %v; want false", blocked, err)
+		}
+		// Unblocking doesn't bring the follows back.
+		if following, err := s.Followers.IsFollowing(ctx, alice.ID, bob.ID); err != nil || following {
+			t.Fatalf("IsFollowing after Unblock = %v, %v; want false", following, err)
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Har2yQn78/social_back.git/internal/ratelimiter"
+	"github.com/go-redis/redis/v8"
+)
+
+// FailureTracker keeps failed login counters and lockouts in Redis, so they are
+// shared by every API instance.
+type FailureTracker struct {
+	rdb *redis.Client
+	cfg ratelimiter.LockoutConfig
+}
+
+func NewFailureTracker(rdb *redis.Client, cfg ratelimiter.LockoutConfig) *FailureTracker {
+	return &FailureTracker{rdb: rdb, cfg: cfg}
+}
+
+func (t *FailureTracker) Locked(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := t.rdb.PTTL(ctx, fmt.Sprintf("login-lock-%s", key)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	// PTTL returns a negative duration when the key doesn't exist.
+	return max(ttl, 0), nil
+}
+
+// incrScript counts a failure and starts its window with the first one. Doing
+// both in one step means a failure counter never lives on without an expiry.
+var incrScript = redis.NewScript(`
+local failures = redis.call("INCR", KEYS[1])
+if failures == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return failures
+`)
+
+func (t *FailureTracker) Fail(ctx context.Context, key string) (int, time.Duration, error) {
+	failuresKey := fmt.Sprintf("login-failures-%s", key)
+
+	failures, err := incrScript.Run(ctx, t.rdb, []string{failuresKey}, t.cfg.Window.Milliseconds()).Int()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	lockout := t.cfg.Backoff(failures)
+	if lockout > 0 {
+		_, err := t.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SetEX(ctx, fmt.Sprintf("login-lock-%s", key), 1, lockout)
+			// Keep counting past the lockout so the next failure doubles it.
+			pipe.Expire(ctx, failuresKey, t.cfg.Window+lockout)
+			return nil
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return failures, lockout, nil
+}
+
+func (t *FailureTracker) Reset(ctx context.Context, key string) error {
+	return t.rdb.Del(ctx, fmt.Sprintf("login-failures-%s", key), fmt.Sprintf("login-lock-%s", key)).Err()
+}
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Har2yQn78/social_back.git/internal/ratelimiter"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return mr, rdb
+}
+
+func TestFailureTracker(t *testing.T) {
+	ctx := context.Background()
+	mr, rdb := newTestRedis(t)
+	cfg := ratelimiter.LockoutConfig{MaxAttempts: 3, BaseLockout: time.Minute, MaxLockout: time.Hour, Window: 15 * time.Minute}
+	tracker := NewFailureTracker(rdb, cfg)
+
+	tests := []struct {
+		failures    int
+		wantLockout time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+	}
+	for _, tt := range tests {
+		failures, lockout, err := tracker.Fail(ctx, "account:a@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if failures != tt.failures || lockout != tt.wantLockout {
+			t.Fatalf("Fail() = %d, %s; want %d, %s", failures, lockout, tt.failures, tt.wantLockout)
+		}
+	}
+
+	// The counter got its expiry with the first failure, extended by the lockout.
+	if ttl := mr.TTL("login-failures-account:a@example.com"); ttl != cfg.Window+2*time.Minute {
+		t.Fatalf("failure counter TTL = %s, want %s", ttl, cfg.Window+2*time.Minute)
+	}
+	if locked, err := tracker.Locked(ctx, "account:a@example.com"); err != nil || locked != 2*time.Minute {
+		t.Fatalf("Locked() = %s, %v; want %s", locked, err, 2*time.Minute)
+	}
+	if locked, err := tracker.Locked(ctx, "account:b@example.com"); err != nil || locked != 0 {
+		t.Fatalf("Locked() of another key = %s, %v; want 0", locked, err)
+	}
+
+	if err := tracker.Reset(ctx, "account:a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if locked, err := tracker.Locked(ctx, "account:a@example.com"); err != nil || locked != 0 {
+		t.Fatalf("Locked() after Reset = %s, %v; want 0", locked, err)
+	}
+}
+
+func TestFailureTrackerWindow(t *testing.T) {
+	ctx := context.Background()
+	mr, rdb := newTestRedis(t)
+	tracker := NewFailureTracker(rdb, ratelimiter.LockoutConfig{MaxAttempts: 3, BaseLockout: time.Minute, MaxLockout: time.Hour, Window: time.Minute})
+
+	if _, _, err := tracker.Fail(ctx, "ip:1.2.3.4"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("login-failures-ip:1.2.3.4"); ttl != time.Minute {
+		t.Fatalf("failure counter TTL = %s, want %s", ttl, time.Minute)
+	}
+
+	// Failures in the window don't push it back.
+	mr.FastForward(30 * time.Second)
+	if _, _, err := tracker.Fail(ctx, "ip:1.2.3.4"); err != nil {
+		t.Fatal(err)
+	}
+	mr.FastForward(31 * time.Second)
+
+	failures, lockout, err := tracker.Fail(ctx, "ip:1.2.3.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures != 1 || lockout != 0 {
+		t.Fatalf("Fail() after the window = %d, %s; want 1, 0", failures, lockout)
+	}
+}
@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Har2yQn78/social_back.git/internal/store"
+	"github.com/go-redis/redis/v8"
+)
+
+// TimelineStore keeps the home timelines of the users in Redis sorted sets of
+// post IDs scored by their creation date, in microseconds like in the
+// database. New posts are pushed to the timelines of the author's followers
+// (fan-out on write), so reading a timeline doesn't have to look for the
+// posts of everyone the user follows.
+//
+// A timeline only holds its size newest posts and expires ttl after it was
+// filled, the next read rebuilds it from the database. Every timeline holds a
+// sentinel member, so a timeline with no posts isn't mistaken for a missing one.
+//
+// Every push and delete bumps the version of the timeline, a rebuild only
+// fills it when the version didn't change since before it read the database.
+// Otherwise a post pushed, or a follow removed, while the rebuild ran would be
+// overwritten by the stale entries.
+type TimelineStore struct {
+	rdb  *redis.Client
+	size int
+	ttl  time.Duration
+}
+
+const timelineSentinel = "0"
+
+// pushScript bumps the version of a timeline and adds a post to it when it
+// exists, trimming it to its size. The sentinel sorts first, at rank 0,
+// trimming starts after it.
+var pushScript = redis.NewScript(`
+redis.call("INCR", KEYS[2])
+redis.call("PEXPIRE", KEYS[2], ARGV[4])
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[1], ARGV[2])
+redis.call("ZREMRANGEBYRANK", KEYS[1], 1, -(tonumber(ARGV[3]) + 1))
+return 1
+`)
+
+// fillScript replaces a timeline with the given members, as score and member
+// pairs, unless its version changed.
+var fillScript = redis.NewScript(`
+if tonumber(redis.call("GET", KEYS[2]) or "0") ~= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call("DEL", KEYS[1])
+for i = 3, #ARGV, 2 do
+	redis.call("ZADD", KEYS[1], ARGV[i], ARGV[i + 1])
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+return 1
+`)
+
+func NewTimelineStore(rdb *redis.Client, size int, ttl time.Duration) *TimelineStore {
+	return &TimelineStore{rdb: rdb, size: size, ttl: ttl}
+}
+
+func timelineKey(userID int64) string {
+	return fmt.Sprintf("timeline-%d", userID)
+}
+
+func timelineVersionKey(userID int64) string {
+	return fmt.Sprintf("timeline-version-%d", userID)
+}
+
+// Size is how many posts a timeline holds at most.
+func (s *TimelineStore) Size() int {
+	return s.size
+}
+
+// Get returns the entries of the user's timeline, newest first. ok is false when
+// the timeline isn't in Redis and has to be rebuilt.
+func (s *TimelineStore) Get(ctx context.Context, userID int64) ([]store.TimelineEntry, bool, error) {
+	members, err := s.rdb.ZRevRangeWithScores(ctx, timelineKey(userID), 0, -1).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	if len(members) == 0 {
+		return nil, false, nil
+	}
+
+	entries := make([]store.TimelineEntry, 0, len(members))
+	for _, m := range members {
+		member, _ := m.Member.(string)
+		if member == timelineSentinel {
+			continue
+		}
+		postID, err := strconv.ParseInt(member, 10, 64)
+		if err != nil {
+			return nil, false, err
+		}
+		entries = append(entries, store.TimelineEntry{PostID: postID, CreatedAt: time.UnixMicro(int64(m.Score))})
+	}
+	return entries, true, nil
+}
+
+// Version returns the version of the user's timeline, to be read before the
+// entries a rebuild fills it with.
+func (s *TimelineStore) Version(ctx context.Context, userID int64) (int64, error) {
+	version, err := s.rdb.Get(ctx, timelineVersionKey(userID)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return version, err
+}
+
+// Fill replaces the user's timeline with the given entries, unless a post was
+// pushed to it or it was deleted since version was read. The timeline is left
+// for the next read to rebuild then.
+func (s *TimelineStore) Fill(ctx context.Context, userID int64, version int64, entries []store.TimelineEntry) error {
+	args := make([]interface{}, 0, 2*len(entries)+4)
+	args = append(args, version, s.ttl.Milliseconds(), "-inf", timelineSentinel)
+	for _, e := range entries {
+		args = append(args, e.CreatedAt.UnixMicro(), strconv.FormatInt(e.PostID, 10))
+	}
+
+	keys := []string{timelineKey(userID), timelineVersionKey(userID)}
+	return fillScript.Run(ctx, s.rdb, keys, args...).Err()
+}
+
+// Push adds a post to the timelines of the given users. The timelines that
+// aren't in Redis are left alone, they will be rebuilt with the post. It must
+// be called once the post is in the database.
+func (s *TimelineStore) Push(ctx context.Context, userIDs []int64, entry store.TimelineEntry) error {
+	score := float64(entry.CreatedAt.UnixMicro())
+	member := strconv.FormatInt(entry.PostID, 10)
+
+	// A pipeline can't fall back to sending the script when Redis doesn't know
+	// it, it's loaded beforehand.
+	if err := pushScript.Load(ctx, s.rdb).Err(); err != nil {
+		return err
+	}
+	_, err := s.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, userID := range userIDs {
+			keys := []string{timelineKey(userID), timelineVersionKey(userID)}
+			pushScript.EvalSha(ctx, pipe, keys, score, member, s.size, s.ttl.Milliseconds())
+		}
+		return nil
+	})
+	return err
+}
+
+// Remove takes a post out of the timelines of the given users.
+func (s *TimelineStore) Remove(ctx context.Context, userIDs []int64, postID int64) error {
+	member := strconv.FormatInt(postID, 10)
+
+	_, err := s.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, userID := range userIDs {
+			pipe.ZRem(ctx, timelineKey(userID), member)
+		}
+		return nil
+	})
+	return err
+}
+
+// Delete drops the user's timeline, the next read rebuilds it. It bumps its
+// version so that a rebuild already running doesn't fill it.
+func (s *TimelineStore) Delete(ctx context.Context, userID int64) error {
+	versionKey := timelineVersionKey(userID)
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, timelineKey(userID))
+		pipe.Incr(ctx, versionKey)
+		pipe.PExpire(ctx, versionKey, s.ttl)
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Har2yQn78/social_back.git/internal/store"
+)
+
+func timelineEntry(postID int64) store.TimelineEntry {
+	return store.TimelineEntry{PostID: postID, CreatedAt: time.Unix(1700000000+postID, 0)}
+}
+
+// assertTimeline checks the posts of the user's cached timeline, newest first.
+func assertTimeline(t *testing.T, s *TimelineStore, userID int64, want ...int64) {
+	t.Helper()
+
+	entries, ok, err := s.Get(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("the timeline is not cached")
+	}
+	got := make([]int64, len(entries))
+	for i, e := range entries {
+		got[i] = e.PostID
+	}
+	if len(got) != len(want) {
+		t.Fatalf("timeline = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("timeline = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestTimelineStore(t *testing.T) {
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+	s := NewTimelineStore(rdb, 3, time.Hour)
+
+	if _, ok, err := s.Get(ctx, 1); err != nil || ok {
+		t.Fatalf("Get() of a missing timeline = %v, %v; want not cached", ok, err)
+	}
+
+	// An empty timeline is cached too.
+	if err := s.Fill(ctx, 1, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	assertTimeline(t, s, 1)
+
+	if err := s.Fill(ctx, 1, 0, []store.TimelineEntry{timelineEntry(2), timelineEntry(1)}); err != nil {
+		t.Fatal(err)
+	}
+	assertTimeline(t, s, 1, 2, 1)
+
+	// Pushing trims the timeline to its size and keeps the sentinel.
+	for _, postID := range []int64{3, 4, 5} {
+		if err := s.Push(ctx, []int64{1, 2}, timelineEntry(postID)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertTimeline(t, s, 1, 5, 4, 3)
+	if _, err := rdb.ZScore(ctx, timelineKey(1), timelineSentinel).Result(); err != nil {
+		t.Fatalf("the sentinel was trimmed: %v", err)
+	}
+
+	// Timelines that aren't cached are left for the next read to rebuild.
+	if _, ok, err := s.Get(ctx, 2); err != nil || ok {
+		t.Fatalf("Get() of a timeline never filled = %v, %v; want not cached", ok, err)
+	}
+
+	if err := s.Remove(ctx, []int64{1}, 4); err != nil {
+		t.Fatal(err)
+	}
+	assertTimeline(t, s, 1, 5, 3)
+
+	if err := s.Delete(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := s.Get(ctx, 1); err != nil || ok {
+		t.Fatalf("Get() of a deleted timeline = %v, %v; want not cached", ok, err)
+	}
+}
+
+func TestTimelineStoreTTL(t *testing.T) {
+	ctx := context.Background()
+	mr, rdb := newTestRedis(t)
+	s := NewTimelineStore(rdb, 3, time.Hour)
+
+	if err := s.Fill(ctx, 1, 0, []store.TimelineEntry{timelineEntry(1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither reading nor pushing keeps a timeline past the TTL of its fill,
+	// so a timeline that went wrong is rebuilt in the end.
+	mr.FastForward(40 * time.Minute)
+	assertTimeline(t, s, 1, 1)
+	if err := s.Push(ctx, []int64{1}, timelineEntry(2)); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL(timelineKey(1)); ttl != 20*time.Minute {
+		t.Fatalf("TTL after a read and a push = %v, want %v", ttl, 20*time.Minute)
+	}
+
+	mr.FastForward(20 * time.Minute)
+	if _, ok, err := s.Get(ctx, 1); err != nil || ok {
+		t.Fatalf("Get() of an expired timeline = %v, %v; want not cached", ok, err)
+	}
+}
+
+func TestTimelineStoreStaleFill(t *testing.T) {
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+	s := NewTimelineStore(rdb, 3, time.Hour)
+
+	// A post is pushed while the timeline is rebuilt from the database.
+	version, err := s.Version(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Push(ctx, []int64{1}, timelineEntry(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Fill(ctx, 1, version, []store.TimelineEntry{timelineEntry(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := s.Get(ctx, 1); err != nil || ok {
+		t.Fatalf("Get() after a stale fill = %v, %v; want not cached", ok, err)
+	}
+
+	// The timeline is invalidated while it's rebuilt.
+	if version, err = s.Version(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Fill(ctx, 1, version, []store.TimelineEntry{timelineEntry(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := s.Get(ctx, 1); err != nil || ok {
+		t.Fatalf("Get() after a fill overtaken by a delete = %v, %v; want not cached", ok, err)
+	}
+
+	// Nothing happened during this rebuild.
+	if version, err = s.Version(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Fill(ctx, 1, version, []store.TimelineEntry{timelineEntry(2), timelineEntry(1)}); err != nil {
+		t.Fatal(err)
+	}
+	assertTimeline(t, s, 1, 2, 1)
+}
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// TokenDenylist stores the IDs of revoked access tokens in Redis so every API
+// instance rejects them until they expire.
+type TokenDenylist struct {
+	rdb *redis.Client
+}
+
+func NewTokenDenylist(rdb *redis.Client) *TokenDenylist {
+	return &TokenDenylist{rdb: rdb}
+}
+
+func (s *TokenDenylist) Revoke(ctx context.Context, jti string, exp time.Time) error {
+	ttl := time.Until(exp)
+	if ttl <= 0 {
+		return nil // The token is already expired, nothing to deny.
+	}
+
+	cacheKey := fmt.Sprintf("revoked-jti-%s", jti)
+	return s.rdb.SetEX(ctx, cacheKey, 1, ttl).Err()
+}
+
+func (s *TokenDenylist) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	cacheKey := fmt.Sprintf("revoked-jti-%s", jti)
+
+	n, err := s.rdb.Exists(ctx, cacheKey).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func (s *TokenDenylist) RevokeUser(ctx context.Context, userID int64, ttl time.Duration) error {
+	cacheKey := fmt.Sprintf("revoked-user-%d", userID)
+	return s.rdb.SetEX(ctx, cacheKey, time.Now().Unix(), ttl).Err()
+}
+
+func (s *TokenDenylist) IsUserRevoked(ctx context.Context, userID int64, issuedAt time.Time) (bool, error) {
+	cacheKey := fmt.Sprintf("revoked-user-%d", userID)
+
+	data, err := s.rdb.Get(ctx, cacheKey).Result()
+	if err == redis.Nil {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	revokedAt, err := strconv.ParseInt(data, 10, 64)
+	if err != nil {
+		return false, err
+	}
+
+	return issuedAt.Unix() < revokedAt, nil
+}
+
+func (s *TokenDenylist) RevokeSession(ctx context.Context, sessionID string, ttl time.Duration) error {
+	cacheKey := fmt.Sprintf("revoked-session-%s", sessionID)
+	return s.rdb.SetEX(ctx, cacheKey, 1, ttl).Err()
+}
+
+func (s *TokenDenylist) IsSessionRevoked(ctx context.Context, sessionID string) (bool, error) {
+	cacheKey := fmt.Sprintf("revoked-session-%s", sessionID)
+
+	n, err := s.rdb.Exists(ctx, cacheKey).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
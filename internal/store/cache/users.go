@@ -50,4 +50,10 @@ func (s *UserStore) Set(ctx context.Context, user *store.User) error {
 
 	// Set the key in Redis with our specified expiration time.
 	return s.rdb.SetEX(ctx, cacheKey, json, UserExpTime).Err()
-}
\ No newline at end of file
+}
+
+// Delete evicts a user, to be called whenever the user's data changes.
+func (s *UserStore) Delete(ctx context.Context, userID int64) error {
+	cacheKey := fmt.Sprintf("user-%d", userID)
+	return s.rdb.Del(ctx, cacheKey).Err()
+}
@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 )
 
 type Comment struct {
@@ -19,15 +20,17 @@ type CommentStore struct {
 }
 
 
-func (s *CommentStore) GetByPostID(ctx context.Context, postID int64) ([]Comment, error) {
+// GetByPostID returns the comments of the post, without the ones of the users
+// viewerID muted or is blocked with.
+func (s *CommentStore) GetByPostID(ctx context.Context, postID, viewerID int64) ([]Comment, error) {
 	query := `
 		SELECT c.id, c.post_id, c.user_id, c.content, c.created_at, users.username, users.id
 		FROM comments c
 		JOIN users ON users.id = c.user_id
-		WHERE c.post_id = $1
+		WHERE c.post_id = $2 AND ` + hiddenAuthorCondition("c.user_id") + `
 		ORDER BY c.created_at DESC
 	`
-	rows, err := s.db.QueryContext(ctx, query, postID)
+	rows, err := s.db.QueryContext(ctx, query, viewerID, postID)
 	if err != nil {
 		return nil, err
 	}
@@ -45,11 +48,72 @@ func (s *CommentStore) GetByPostID(ctx context.Context, postID int64) ([]Comment
 	return comments, rows.Err()
 }
 
+// Create adds a comment in the language of its post. It fails with ErrNotFound
+// when the post was deleted.
 func (s *CommentStore) Create(ctx context.Context, comment *Comment) error {
 	query := `
-		INSERT INTO comments (post_id, user_id, content)
-		VALUES ($1, $2, $3)
+		INSERT INTO comments (post_id, user_id, content, language)
+		SELECT $1, $2, $3, language FROM posts WHERE id = $1
 		RETURNING id, created_at
 	`
-	return s.db.QueryRowContext(ctx, query, comment.PostID, comment.UserID, comment.Content).Scan(&comment.ID, &comment.CreatedAt)
-}
\ No newline at end of file
+	err := s.db.QueryRowContext(ctx, query, comment.PostID, comment.UserID, comment.Content).Scan(&comment.ID, &comment.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *CommentStore) GetByID(ctx context.Context, commentID int64) (*Comment, error) {
+	query := `
+		SELECT c.id, c.post_id, c.user_id, c.content, c.created_at, users.username, users.id
+		FROM comments c
+		JOIN users ON users.id = c.user_id
+		WHERE c.id = $1
+	`
+	var c Comment
+	err := s.db.QueryRowContext(ctx, query, commentID).Scan(&c.ID, &c.PostID, &c.UserID, &c.Content, &c.CreatedAt, &c.User.Username, &c.User.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *CommentStore) Update(ctx context.Context, comment *Comment) error {
+	query := `UPDATE comments SET content = $1 WHERE id = $2`
+
+	res, err := s.db.ExecContext(ctx, query, comment.Content, comment.ID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *CommentStore) Delete(ctx context.Context, commentID int64) error {
+	query := `DELETE FROM comments WHERE id = $1`
+
+	res, err := s.db.ExecContext(ctx, query, commentID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestDB connects to the Postgres database in DATABASE_URL and applies the
+// migrations of cmd/migrate in a schema of its own, dropped when the test
+// ends, so tests don't see each other's rows. Without DATABASE_URL the test
+// is skipped.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL is not set, skipping the tests against Postgres")
+	}
+
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	schema := "store_test_" + hex.EncodeToString(b)
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Errorf("dropping the test schema: %v", err)
+		}
+	})
+
+	// The extensions may already be installed in public, keep it on the path.
+	db, err := sql.Open("postgres", withSearchPath(t, dsn, schema+",public"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrations, err := filepath.Glob(filepath.Join("..", "..", "cmd", "migrate", "migrations", "*.up.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations found")
+	}
+	for _, migration := range migrations {
+		query, err := os.ReadFile(migration)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(query)); err != nil {
+			t.Fatalf("applying %s: %v", filepath.Base(migration), err)
+		}
+	}
+
+	return db
+}
+
+// withSearchPath sets the search_path run-time parameter of a connection
+// string, given as a URL or as keywords and values.
+func withSearchPath(t *testing.T, dsn, searchPath string) string {
+	t.Helper()
+
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path='" + searchPath + "'"
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", searchPath)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// createTestUser inserts an active user named username.
+func createTestUser(t *testing.T, db *sql.DB, username string) *User {
+	t.Helper()
+
+	user := &User{Username: username, Email: username + "@example.com", IsActive: true}
+	if err := user.Password.Set("password"); err != nil {
+		t.Fatal(err)
+	}
+
+	query := `INSERT INTO users (username, email, password, is_active) VALUES ($1, $2, $3, TRUE) RETURNING id, created_at`
+	err := db.QueryRowContext(context.Background(), query, user.Username, user.Email, user.Password.hash).Scan(&user.ID, &user.CreatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
@@ -0,0 +1,177 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// UserData is everything a user put on the platform, as handed to them in a data export.
+type UserData struct {
+	Posts     []Post       `json:"posts"`
+	Comments  []Comment    `json:"comments"`
+	Followers []PublicUser `json:"followers"`
+	Following []PublicUser `json:"following"`
+}
+
+// ExportStore keeps track of the data exports waiting to be downloaded.
+type ExportStore struct {
+	db *sql.DB
+}
+
+// GetUserData collects the posts, comments and follows of the user.
+func (s *ExportStore) GetUserData(ctx context.Context, userID int64) (*UserData, error) {
+	data := &UserData{Posts: []Post{}, Comments: []Comment{}}
+
+	query := `
+		SELECT id, user_id, title, content, created_at, updated_at, tags, version, language
+		FROM posts
+		WHERE user_id = $1
+		ORDER BY created_at
+	`
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p Post
+		var tags pq.StringArray
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Title, &p.Content, &p.CreatedAt, &p.UpdatedAt, &tags, &p.Version, &p.Language); err != nil {
+			return nil, err
+		}
+		p.Tags = tags
+		data.Posts = append(data.Posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT c.id, c.post_id, c.user_id, c.content, c.created_at, u.username
+		FROM comments c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.user_id = $1
+		ORDER BY c.created_at
+	`
+	rows, err = s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c Comment
+		if err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.Content, &c.CreatedAt, &c.User.Username); err != nil {
+			return nil, err
+		}
+		c.User.ID = c.UserID
+		data.Comments = append(data.Comments, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT ` + publicUserColumns + `
+		FROM followers f
+		JOIN users u ON u.id = f.follower_id
+		WHERE f.user_id = $1
+		ORDER BY f.created_at
+	`
+	if data.Followers, err = listPublicUsers(ctx, s.db, query, userID, userID); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT ` + publicUserColumns + `
+		FROM followers f
+		JOIN users u ON u.id = f.user_id
+		WHERE f.follower_id = $1
+		ORDER BY f.created_at
+	`
+	if data.Following, err = listPublicUsers(ctx, s.db, query, userID, userID); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Start records that an export of the user's data is being prepared. It returns
+// ErrConflict while another one is, unless that one started longer than
+// staleAfter ago: its instance must have stopped before finishing it.
+func (s *ExportStore) Start(ctx context.Context, userID int64, staleAfter time.Duration) error {
+	query := `
+		INSERT INTO pending_exports (user_id) VALUES ($1)
+		ON CONFLICT (user_id) DO UPDATE SET started_at = NOW()
+		WHERE pending_exports.started_at < $2
+		RETURNING user_id
+	`
+	err := s.db.QueryRowContext(ctx, query, userID, time.Now().Add(-staleAfter)).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrConflict
+		}
+		return err
+	}
+	return nil
+}
+
+// Cancel forgets the export being prepared for the user, after it failed.
+func (s *ExportStore) Cancel(ctx context.Context, userID int64) error {
+	query := `DELETE FROM pending_exports WHERE user_id = $1`
+	_, err := s.db.ExecContext(ctx, query, userID)
+	return err
+}
+
+// Create stores the hash of the token that downloads the export in fileName,
+// which finishes the export being prepared for the user.
+func (s *ExportStore) Create(ctx context.Context, userID int64, fileName, plainToken string, exp time.Duration) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `INSERT INTO data_exports (token_hash, user_id, file_name, expiry) VALUES ($1, $2, $3, $4)`
+		if _, err := tx.ExecContext(ctx, query, hashToken(plainToken), userID, fileName, time.Now().Add(exp)); err != nil { return err }
+
+		query = `DELETE FROM pending_exports WHERE user_id = $1`
+		_, err := tx.ExecContext(ctx, query, userID)
+		return err
+	})
+}
+
+// GetFileName returns the file of the export the token downloads. The token can
+// be used until it expires, in case the download is interrupted.
+func (s *ExportStore) GetFileName(ctx context.Context, plainToken string) (string, error) {
+	query := `SELECT file_name FROM data_exports WHERE token_hash = $1 AND expiry > $2`
+
+	var fileName string
+	err := s.db.QueryRowContext(ctx, query, hashToken(plainToken), time.Now()).Scan(&fileName)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	return fileName, nil
+}
+
+// DeleteExpired forgets the expired exports and returns their files, for the caller to remove.
+func (s *ExportStore) DeleteExpired(ctx context.Context) ([]string, error) {
+	query := `DELETE FROM data_exports WHERE expiry < NOW() RETURNING file_name`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fileNames []string
+	for rows.Next() {
+		var fileName string
+		if err := rows.Scan(&fileName); err != nil {
+			return nil, err
+		}
+		fileNames = append(fileNames, fileName)
+	}
+	return fileNames, rows.Err()
+}
@@ -14,12 +14,17 @@ type FollowersStore struct {
 
 func (s *FollowersStore) Follow(ctx context.Context, followedID, followerID int64) error {
 	query := `
-	INSTER INTO followers (user_id,follower_id) VALUE ($1, $2)
+	INSERT INTO followers (user_id, follower_id) VALUES ($1, $2)
 	`
 	_, err := s.db.ExecContext(ctx, query, followedID, followerID)
 	if err != nil {
-		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
-			return ErrConflict
+		if pqErr, ok := err.(*pq.Error); ok {
+			switch pqErr.Code {
+			case "23505": // unique_violation: already following
+				return ErrConflict
+			case "23503": // foreign_key_violation: no such user
+				return ErrNotFound
+			}
 		}
 		return err
 	}
@@ -33,4 +38,243 @@ func (s *FollowersStore) Unfollow(ctx context.Context, followedID, followerID in
 	`
 	_, err := s.db.ExecContext(ctx, query, followedID, followerID)
 	return err
-}
\ No newline at end of file
+}
+
+// IsFollowing reports whether followerID follows followedID.
+func (s *FollowersStore) IsFollowing(ctx context.Context, followedID, followerID int64) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM followers WHERE user_id = $1 AND follower_id = $2)`
+
+	var following bool
+	err := s.db.QueryRowContext(ctx, query, followedID, followerID).Scan(&following)
+	return following, err
+}
+
+// GetFollowers lists who follows the user, most recent first. FollowedByMe is
+// set from the point of view of viewerID.
+func (s *FollowersStore) GetFollowers(ctx context.Context, userID, viewerID int64, pq PaginatedQuery) ([]PublicUser, Page, error) {
+	cond, order := keyset(pq.Cursor, "f.created_at", "u.id", true, 5)
+	query := `
+		SELECT ` + publicUserColumns + `, f.created_at
+		FROM followers f
+		JOIN users u ON u.id = f.follower_id
+		WHERE f.user_id = $1 AND u.is_active = TRUE AND ` + cond + `
+		ORDER BY ` + order + `
+		LIMIT $3 OFFSET $4
+	`
+	return listPublicUsersPage(ctx, s.db, query, userID, viewerID, pq)
+}
+
+// GetFollowing lists who the user follows, most recent first. FollowedByMe is
+// set from the point of view of viewerID.
+func (s *FollowersStore) GetFollowing(ctx context.Context, userID, viewerID int64, pq PaginatedQuery) ([]PublicUser, Page, error) {
+	cond, order := keyset(pq.Cursor, "f.created_at", "u.id", true, 5)
+	query := `
+		SELECT ` + publicUserColumns + `, f.created_at
+		FROM followers f
+		JOIN users u ON u.id = f.user_id
+		WHERE f.follower_id = $1 AND u.is_active = TRUE AND ` + cond + `
+		ORDER BY ` + order + `
+		LIMIT $3 OFFSET $4
+	`
+	return listPublicUsersPage(ctx, s.db, query, userID, viewerID, pq)
+}
+
+// GetFollowerIDs returns the IDs of everyone who follows the user.
+func (s *FollowersStore) GetFollowerIDs(ctx context.Context, userID int64) ([]int64, error) {
+	query := `SELECT follower_id FROM followers WHERE user_id = $1`
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// publicUserColumns selects a PublicUser from users aliased u. The viewer's ID must be bound to $2.
+const publicUserColumns = `
+	u.id, u.username, u.created_at,
+	u.display_name, u.bio, u.location, u.website, u.avatar_url, u.is_private,
+	u.followers_count, u.following_count, u.posts_count,
+	EXISTS (SELECT 1 FROM followers mine WHERE mine.user_id = u.id AND mine.follower_id = $2)
+`
+
+// listPublicUsersPage reads a page of a list of users sorted by the date they
+// were added to it. The query selects publicUserColumns then that date, binds
+// the user to $1, the viewer to $2, LIMIT to $3, OFFSET to $4 and the cursor
+// to $5 and $6.
+func listPublicUsersPage(ctx context.Context, db *sql.DB, query string, userID, viewerID int64, pq PaginatedQuery) ([]PublicUser, Page, error) {
+	args := append([]any{userID, viewerID, pq.Limit + 1, pq.Offset}, cursorArgs(pq.Cursor)...)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	defer rows.Close()
+
+	type listedUser struct {
+		user     PublicUser
+		listedAt string
+	}
+	var listed []listedUser
+	for rows.Next() {
+		var l listedUser
+		u := &l.user
+		err := rows.Scan(
+			&u.ID, &u.Username, &u.CreatedAt,
+			&u.DisplayName, &u.Bio, &u.Location, &u.Website, &u.AvatarURL, &u.IsPrivate,
+			&u.Counts.Followers, &u.Counts.Following, &u.Counts.Posts,
+			&u.FollowedByMe,
+			&l.listedAt,
+		)
+		if err != nil {
+			return nil, Page{}, err
+		}
+		listed = append(listed, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, Page{}, err
+	}
+
+	listed, page := paginate(listed, pq.Limit, pq.Cursor, func(l listedUser) Cursor {
+		return Cursor{CreatedAt: l.listedAt, ID: l.user.ID}
+	})
+	users := make([]PublicUser, len(listed))
+	for i, l := range listed {
+		users[i] = l.user
+	}
+	return users, page, nil
+}
+
+// listPublicUsers runs a query selecting publicUserColumns.
+func listPublicUsers(ctx context.Context, db *sql.DB, query string, args ...any) ([]PublicUser, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []PublicUser{}
+	for rows.Next() {
+		var u PublicUser
+		err := rows.Scan(
+			&u.ID, &u.Username, &u.CreatedAt,
+			&u.DisplayName, &u.Bio, &u.Location, &u.Website, &u.AvatarURL, &u.IsPrivate,
+			&u.Counts.Followers, &u.Counts.Following, &u.Counts.Posts,
+			&u.FollowedByMe,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// Request asks to follow a private account. It fails with ErrConflict when the
+// user already follows it or already asked to.
+func (s *FollowersStore) Request(ctx context.Context, followedID, followerID int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		var following bool
+		query := `SELECT EXISTS (SELECT 1 FROM followers WHERE user_id = $1 AND follower_id = $2)`
+		if err := tx.QueryRowContext(ctx, query, followedID, followerID).Scan(&following); err != nil { return err }
+		if following { return ErrConflict }
+
+		query = `INSERT INTO follow_requests (user_id, requester_id) VALUES ($1, $2)`
+		_, err := tx.ExecContext(ctx, query, followedID, followerID)
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrConflict
+		}
+		return err
+	})
+}
+
+// CancelRequest withdraws a follow request that wasn't answered yet.
+func (s *FollowersStore) CancelRequest(ctx context.Context, followedID, followerID int64) error {
+	query := `DELETE FROM follow_requests WHERE user_id = $1 AND requester_id = $2`
+	_, err := s.db.ExecContext(ctx, query, followedID, followerID)
+	return err
+}
+
+// GetRequests lists the pending follow requests of the user, oldest first.
+func (s *FollowersStore) GetRequests(ctx context.Context, userID int64, pq PaginatedQuery) ([]PublicUser, Page, error) {
+	cond, order := keyset(pq.Cursor, "fr.created_at", "u.id", false, 5)
+	query := `
+		SELECT ` + publicUserColumns + `, fr.created_at
+		FROM follow_requests fr
+		JOIN users u ON u.id = fr.requester_id
+		WHERE fr.user_id = $1 AND u.is_active = TRUE AND ` + cond + `
+		ORDER BY ` + order + `
+		LIMIT $3 OFFSET $4
+	`
+	// The owner is the viewer: FollowedByMe tells whether they follow the requester.
+	return listPublicUsersPage(ctx, s.db, query, userID, userID, pq)
+}
+
+// ApproveRequest turns a pending follow request into a follow.
+func (s *FollowersStore) ApproveRequest(ctx context.Context, userID, requesterID int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `DELETE FROM follow_requests WHERE user_id = $1 AND requester_id = $2`
+		res, err := tx.ExecContext(ctx, query, userID, requesterID)
+		if err != nil { return err }
+		rows, err := res.RowsAffected()
+		if err != nil { return err }
+		if rows == 0 { return ErrNotFound }
+
+		query = `INSERT INTO followers (user_id, follower_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+		_, err = tx.ExecContext(ctx, query, userID, requesterID)
+		return err
+	})
+}
+
+// RejectRequest drops a pending follow request.
+func (s *FollowersStore) RejectRequest(ctx context.Context, userID, requesterID int64) error {
+	query := `DELETE FROM follow_requests WHERE user_id = $1 AND requester_id = $2`
+
+	res, err := s.db.ExecContext(ctx, query, userID, requesterID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ApproveAllRequests approves every pending request, for when an account goes
+// public. It returns the IDs of the users who now follow the account.
+func (s *FollowersStore) ApproveAllRequests(ctx context.Context, userID int64) ([]int64, error) {
+	var requesterIDs []int64
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `DELETE FROM follow_requests WHERE user_id = $1 RETURNING requester_id`
+		rows, err := tx.QueryContext(ctx, query, userID)
+		if err != nil { return err }
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil { return err }
+			requesterIDs = append(requesterIDs, id)
+		}
+		if err := rows.Err(); err != nil { return err }
+
+		query = `INSERT INTO followers (user_id, follower_id) SELECT $1, unnest($2::bigint[]) ON CONFLICT DO NOTHING`
+		_, err = tx.ExecContext(ctx, query, userID, pq.Array(requesterIDs))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return requesterIDs, nil
+}
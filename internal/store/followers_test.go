@@ -0,0 +1,210 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFollowersStore(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := NewStorage(db)
+
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+	carol := createTestUser(t, db, "carol")
+
+	for _, followerID := range []int64{bob.ID, carol.ID} {
+		if err := s.Followers.Follow(ctx, alice.ID, followerID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Followers.Follow(ctx, carol.ID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Followers.Follow(ctx, alice.ID, bob.ID); !errors.Is(err, ErrConflict) {
+		t.Fatalf("following twice: got %v, want ErrConflict", err)
+	}
+	if err := s.Followers.Follow(ctx, -1, bob.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("following an unknown user: got %v, want ErrNotFound", err)
+	}
+
+	assertCounts(t, s, alice.ID, Counts{Followers: 2, Following: 1})
+	assertCounts(t, s, bob.ID, Counts{Following: 1})
+	assertCounts(t, s, carol.ID, Counts{Followers: 1, Following: 1})
+
+	t.Run("followers, page by page", func(t *testing.T) {
+		first, page, err := s.Followers.GetFollowers(ctx, alice.ID, bob.ID, PaginatedQuery{Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(first) != 1 || page.Next == nil {
+			t.Fatalf("got %d followers and next cursor %v, want 1 and a cursor", len(first), page.Next)
+		}
+
+		second, page, err := s.Followers.GetFollowers(ctx, alice.ID, bob.ID, PaginatedQuery{Limit: 1, Cursor: page.Next})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(second) != 1 || page.Next != nil {
+			t.Fatalf("got %d followers and next cursor %v, want 1 and none", len(second), page.Next)
+		}
+
+		got := map[int64]PublicUser{first[0].ID: first[0], second[0].ID: second[0]}
+		if _, ok := got[bob.ID]; !ok {
+			t.Fatalf("bob is missing from the followers: %+v", got)
+		}
+		if _, ok := got[carol.ID]; !ok {
+			t.Fatalf("carol is missing from the followers: %+v", got)
+		}
+		// bob looks at the list: he follows neither himself nor carol.
+		if got[bob.ID].FollowedByMe || got[carol.ID].FollowedByMe {
+			t.Fatalf("FollowedByMe is set from bob's point of view: %+v", got)
+		}
+	})
+
+	t.Run("following, seen by a follower", func(t *testing.T) {
+		following, _, err := s.Followers.GetFollowing(ctx, bob.ID, carol.ID, PaginatedQuery{Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(following) != 1 || following[0].ID != alice.ID {
+			t.Fatalf("bob follows %+v, want only alice", following)
+		}
+		if !following[0].FollowedByMe {
+			t.Fatal("carol follows alice, FollowedByMe must be set")
+		}
+		if following[0].Followers != 2 {
+			t.Fatalf("alice has %d followers in the list, want 2", following[0].Followers)
+		}
+	})
+
+	if err := s.Followers.Unfollow(ctx, alice.ID, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if following, err := s.Followers.IsFollowing(ctx, alice.ID, bob.ID); err != nil || following {
+		t.Fatalf("IsFollowing after Unfollow = %v, %v; want false", following, err)
+	}
+	assertCounts(t, s, alice.ID, Counts{Followers: 1, Following: 1})
+	assertCounts(t, s, bob.ID, Counts{})
+}
+
+func TestFollowRequests(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := NewStorage(db)
+
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+	carol := createTestUser(t, db, "carol")
+	private := true
+	if _, err := s.Users.UpdateProfile(ctx, alice.ID, &ProfileUpdate{IsPrivate: &private}); err != nil {
+		t.Fatal(err)
+	}
+	post := &Post{UserID: alice.ID, Title: "Running", Content: "I went running today", Tags: []string{}, Language: "english"}
+	if err := s.Posts.Create(ctx, post); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, requester := range []*User{bob, carol} {
+		if err := s.Followers.Request(ctx, alice.ID, requester.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Followers.Request(ctx, alice.ID, bob.ID); !errors.Is(err, ErrConflict) {
+		t.Fatalf("asking twice: got %v, want ErrConflict", err)
+	}
+	requests, _, err := s.Followers.GetRequests(ctx, alice.ID, PaginatedQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 || requests[0].ID != bob.ID || requests[1].ID != carol.ID {
+		t.Fatalf("got requests %+v, want bob's then carol's", requests)
+	}
+
+	if err := s.Followers.ApproveRequest(ctx, alice.ID, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Followers.RejectRequest(ctx, alice.ID, carol.ID); err != nil {
+		t.Fatal(err)
+	}
+	if following, err := s.Followers.IsFollowing(ctx, alice.ID, bob.ID); err != nil || !following {
+		t.Fatalf("IsFollowing after ApproveRequest = %v, %v; want true", following, err)
+	}
+	if following, err := s.Followers.IsFollowing(ctx, alice.ID, carol.ID); err != nil || following {
+		t.Fatalf("IsFollowing after RejectRequest = %v, %v; want false", following, err)
+	}
+	assertCounts(t, s, alice.ID, Counts{Followers: 1, Posts: 1})
+
+	// Answered requests are gone.
+	if err := s.Followers.ApproveRequest(ctx, alice.ID, carol.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("approving a rejected request: got %v, want ErrNotFound", err)
+	}
+	if err := s.Followers.RejectRequest(ctx, alice.ID, bob.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("rejecting an approved request: got %v, want ErrNotFound", err)
+	}
+	if err := s.Followers.Request(ctx, alice.ID, bob.ID); !errors.Is(err, ErrConflict) {
+		t.Fatalf("a follower asking to follow: got %v, want ErrConflict", err)
+	}
+
+	// The private posts are only found by the followers.
+	for _, viewer := range []struct {
+		user *User
+		want int
+	}{{alice, 1}, {bob, 1}, {carol, 0}} {
+		results, err := s.Posts.Search(ctx, viewer.user.ID, SearchQuery{Query: "running", Language: "english", Tags: []string{}, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != viewer.want {
+			t.Fatalf("%s found %d posts, want %d", viewer.user.Username, len(results), viewer.want)
+		}
+	}
+
+	t.Run("going public approves the pending requests", func(t *testing.T) {
+		if err := s.Followers.Request(ctx, alice.ID, carol.ID); err != nil {
+			t.Fatal(err)
+		}
+		requesterIDs, err := s.Followers.ApproveAllRequests(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(requesterIDs) != 1 || requesterIDs[0] != carol.ID {
+			t.Fatalf("ApproveAllRequests() = %v, want carol", requesterIDs)
+		}
+		assertCounts(t, s, alice.ID, Counts{Followers: 2, Posts: 1})
+	})
+}
+
+func TestPostsCount(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := NewStorage(db)
+
+	alice := createTestUser(t, db, "alice")
+
+	post := &Post{UserID: alice.ID, Title: "title", Content: "content", Tags: []string{}, Language: "english"}
+	if err := s.Posts.Create(ctx, post); err != nil {
+		t.Fatal(err)
+	}
+	assertCounts(t, s, alice.ID, Counts{Posts: 1})
+
+	if err := s.Posts.Delete(ctx, post.ID); err != nil {
+		t.Fatal(err)
+	}
+	assertCounts(t, s, alice.ID, Counts{})
+}
+
+func assertCounts(t *testing.T, s Storage, userID int64, want Counts) {
+	t.Helper()
+
+	user, err := s.Users.GetByID(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Counts != want {
+		t.Fatalf("user %d has counts %+v, want %+v", userID, user.Counts, want)
+	}
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// IdentityStore links users to the accounts they sign in with at external
+// identity providers, and keeps the state of the logins in progress.
+type IdentityStore struct {
+	db *sql.DB
+}
+
+// CreateState stores the state of a login started at provider, with the PKCE
+// code verifier the provider's code will have to be exchanged with.
+func (s *IdentityStore) CreateState(ctx context.Context, provider, state, codeVerifier string, exp time.Duration) error {
+	query := `INSERT INTO oidc_states (state_hash, provider, code_verifier, expiry) VALUES ($1, $2, $3, $4)`
+	_, err := s.db.ExecContext(ctx, query, hashToken(state), provider, codeVerifier, time.Now().Add(exp))
+	return err
+}
+
+// ConsumeState burns the state of a login and returns its code verifier. It
+// returns ErrNotFound when the state is unknown, expired or for another provider.
+func (s *IdentityStore) ConsumeState(ctx context.Context, provider, state string) (string, error) {
+	query := `DELETE FROM oidc_states WHERE state_hash = $1 RETURNING provider, code_verifier, expiry`
+
+	var stateProvider, codeVerifier string
+	var expiry time.Time
+	err := s.db.QueryRowContext(ctx, query, hashToken(state)).Scan(&stateProvider, &codeVerifier, &expiry)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) { return "", ErrNotFound }
+		return "", err
+	}
+	if stateProvider != provider || time.Now().After(expiry) {
+		return "", ErrNotFound
+	}
+
+	return codeVerifier, nil
+}
+
+// GetUserID returns the active user the provider's subject is linked to.
+func (s *IdentityStore) GetUserID(ctx context.Context, provider, subject string) (int64, error) {
+	query := `
+		SELECT users.id
+		FROM user_identities
+		JOIN users ON users.id = user_identities.user_id
+		WHERE provider = $1 AND subject = $2 AND users.is_active = TRUE
+	`
+	var userID int64
+	err := s.db.QueryRowContext(ctx, query, provider, subject).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) { return 0, ErrNotFound }
+		return 0, err
+	}
+	return userID, nil
+}
+
+// Link links the provider's subject to the active user with the given email,
+// which the provider verified. An account with that email that was never
+// activated is deleted rather than linked: whoever signed it up never proved
+// they own the email, and could otherwise get into the account later on. It
+// returns ErrNotFound when no active user has the email, and ErrConflict when
+// the subject was linked meanwhile.
+func (s *IdentityStore) Link(ctx context.Context, provider, subject, email string) (int64, error) {
+	var userID int64
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `DELETE FROM users WHERE email = $1 AND is_active = FALSE`
+		if _, err := tx.ExecContext(ctx, query, email); err != nil { return err }
+
+		query = `SELECT id FROM users WHERE email = $1 AND is_active = TRUE FOR UPDATE`
+		err := tx.QueryRowContext(ctx, query, email).Scan(&userID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) { return ErrNotFound }
+			return err
+		}
+
+		query = `INSERT INTO user_identities (provider, subject, user_id, email) VALUES ($1, $2, $3, $4)`
+		_, err = tx.ExecContext(ctx, query, provider, subject, userID, email)
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrConflict
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// CreateUser creates an active user for the provider's subject and links them.
+func (s *IdentityStore) CreateUser(ctx context.Context, user *User, provider, subject string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `INSERT INTO users (username, password, email, is_active) VALUES ($1, $2, $3, TRUE) RETURNING id, created_at`
+		err := tx.QueryRowContext(ctx, query, user.Username, user.Password.hash, user.Email).Scan(&user.ID, &user.CreatedAt)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+				return ErrDuplicateEmail
+			case err.Error() == `pq: duplicate key value violates unique constraint "users_username_key"`:
+				return ErrDuplicateUsername
+			default:
+				return err
+			}
+		}
+		user.IsActive = true
+
+		query = `INSERT INTO user_identities (provider, subject, user_id, email) VALUES ($1, $2, $3, $4)`
+		_, err = tx.ExecContext(ctx, query, provider, subject, user.ID, user.Email)
+		return err
+	})
+}
+
+// DeleteExpiredStates purges the states of the logins that were never completed.
+func (s *IdentityStore) DeleteExpiredStates(ctx context.Context) (int64, error) {
+	query := `DELETE FROM oidc_states WHERE expiry < NOW()`
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,270 @@
+package store
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// OAuthClient is a third-party app registered to access the API on behalf of
+// users. Only the hash of its secret is stored, the secret is shown once.
+type OAuthClient struct {
+	ID           int64    `json:"-"`
+	ClientID     string   `json:"client_id"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	OwnerID      int64    `json:"owner_id"`
+	CreatedAt    string   `json:"created_at"`
+}
+
+// OAuthCode is an authorization code waiting to be exchanged for an access token.
+type OAuthCode struct {
+	ClientID      string
+	UserID        int64
+	RedirectURI   string
+	Scopes        []string
+	CodeChallenge string
+}
+
+// OAuthGrant is what a user allowed an app to do: a "connected app".
+type OAuthGrant struct {
+	ClientID   string    `json:"client_id"`
+	ClientName string    `json:"name"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type OAuthStore struct {
+	db *sql.DB
+}
+
+func (s *OAuthStore) CreateClient(ctx context.Context, client *OAuthClient, secret string) error {
+	query := `
+		INSERT INTO oauth_clients (client_id, secret_hash, name, redirect_uris, owner_id)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at
+	`
+	return s.db.QueryRowContext(
+		ctx, query, client.ClientID, hashToken(secret), client.Name, pq.Array(client.RedirectURIs), client.OwnerID,
+	).Scan(&client.ID, &client.CreatedAt)
+}
+
+func (s *OAuthStore) GetClient(ctx context.Context, clientID string) (*OAuthClient, error) {
+	client, _, err := s.getClient(ctx, clientID)
+	return client, err
+}
+
+// AuthenticateClient returns the client when the secret is right, ErrNotFound otherwise.
+func (s *OAuthStore) AuthenticateClient(ctx context.Context, clientID, secret string) (*OAuthClient, error) {
+	client, secretHash, err := s.getClient(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(secretHash, []byte(hashToken(secret))) != 1 {
+		return nil, ErrNotFound
+	}
+	return client, nil
+}
+
+func (s *OAuthStore) getClient(ctx context.Context, clientID string) (*OAuthClient, []byte, error) {
+	query := `SELECT id, client_id, secret_hash, name, redirect_uris, owner_id, created_at FROM oauth_clients WHERE client_id = $1`
+
+	var client OAuthClient
+	var secretHash []byte
+	var redirectURIs pq.StringArray
+	err := s.db.QueryRowContext(ctx, query, clientID).Scan(
+		&client.ID, &client.ClientID, &secretHash, &client.Name, &redirectURIs, &client.OwnerID, &client.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) { return nil, nil, ErrNotFound }
+		return nil, nil, err
+	}
+	client.RedirectURIs = redirectURIs
+
+	return &client, secretHash, nil
+}
+
+func (s *OAuthStore) GetClientsByOwner(ctx context.Context, ownerID int64) ([]OAuthClient, error) {
+	query := `
+		SELECT id, client_id, name, redirect_uris, owner_id, created_at
+		FROM oauth_clients
+		WHERE owner_id = $1
+		ORDER BY created_at DESC
+	`
+	rows, err := s.db.QueryContext(ctx, query, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []OAuthClient{}
+	for rows.Next() {
+		var client OAuthClient
+		var redirectURIs pq.StringArray
+		if err := rows.Scan(&client.ID, &client.ClientID, &client.Name, &redirectURIs, &client.OwnerID, &client.CreatedAt); err != nil {
+			return nil, err
+		}
+		client.RedirectURIs = redirectURIs
+		clients = append(clients, client)
+	}
+	return clients, rows.Err()
+}
+
+// DeleteClient deletes one of the owner's apps, which disconnects it from every user.
+func (s *OAuthStore) DeleteClient(ctx context.Context, clientID string, ownerID int64) error {
+	query := `DELETE FROM oauth_clients WHERE client_id = $1 AND owner_id = $2`
+
+	res, err := s.db.ExecContext(ctx, query, clientID, ownerID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Authorize records the user's consent, replacing the scopes of an earlier
+// grant, and stores the hash of the authorization code that goes with it.
+func (s *OAuthStore) Authorize(ctx context.Context, code *OAuthCode, plainCode string, exp time.Duration) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		var clientID int64
+		query := `SELECT id FROM oauth_clients WHERE client_id = $1`
+		if err := tx.QueryRowContext(ctx, query, code.ClientID).Scan(&clientID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) { return ErrNotFound }
+			return err
+		}
+
+		query = `
+			INSERT INTO oauth_grants (user_id, client_id, scopes) VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, client_id) DO UPDATE SET scopes = EXCLUDED.scopes, updated_at = NOW()
+		`
+		if _, err := tx.ExecContext(ctx, query, code.UserID, clientID, pq.Array(code.Scopes)); err != nil { return err }
+
+		query = `
+			INSERT INTO oauth_codes (code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, expiry)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`
+		_, err := tx.ExecContext(
+			ctx, query, hashToken(plainCode), clientID, code.UserID, code.RedirectURI, pq.Array(code.Scopes), code.CodeChallenge, time.Now().Add(exp),
+		)
+		return err
+	})
+}
+
+// ConsumeCode burns an authorization code and returns what it was issued for.
+// Unknown and expired codes are reported as ErrNotFound.
+func (s *OAuthStore) ConsumeCode(ctx context.Context, plainCode string) (*OAuthCode, error) {
+	query := `
+		DELETE FROM oauth_codes USING oauth_clients
+		WHERE oauth_codes.client_id = oauth_clients.id AND code_hash = $1
+		RETURNING oauth_clients.client_id, user_id, redirect_uri, scopes, code_challenge, expiry
+	`
+	var code OAuthCode
+	var scopes pq.StringArray
+	var expiry time.Time
+	err := s.db.QueryRowContext(ctx, query, hashToken(plainCode)).Scan(
+		&code.ClientID, &code.UserID, &code.RedirectURI, &scopes, &code.CodeChallenge, &expiry,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) { return nil, ErrNotFound }
+		return nil, err
+	}
+	if time.Now().After(expiry) {
+		return nil, ErrNotFound
+	}
+	code.Scopes = tokenScopes(scopes)
+
+	return &code, nil
+}
+
+func (s *OAuthStore) GetGrant(ctx context.Context, userID int64, clientID string) (*OAuthGrant, error) {
+	query := `
+		SELECT c.client_id, c.name, g.scopes, g.created_at, g.updated_at
+		FROM oauth_grants g
+		JOIN oauth_clients c ON c.id = g.client_id
+		WHERE g.user_id = $1 AND c.client_id = $2
+	`
+	var grant OAuthGrant
+	var scopes pq.StringArray
+	err := s.db.QueryRowContext(ctx, query, userID, clientID).Scan(
+		&grant.ClientID, &grant.ClientName, &scopes, &grant.CreatedAt, &grant.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) { return nil, ErrNotFound }
+		return nil, err
+	}
+	grant.Scopes = tokenScopes(scopes)
+
+	return &grant, nil
+}
+
+// GetGrantsByUser lists the apps the user connected to their account.
+func (s *OAuthStore) GetGrantsByUser(ctx context.Context, userID int64) ([]OAuthGrant, error) {
+	query := `
+		SELECT c.client_id, c.name, g.scopes, g.created_at, g.updated_at
+		FROM oauth_grants g
+		JOIN oauth_clients c ON c.id = g.client_id
+		WHERE g.user_id = $1
+		ORDER BY g.updated_at DESC
+	`
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := []OAuthGrant{}
+	for rows.Next() {
+		var grant OAuthGrant
+		var scopes pq.StringArray
+		if err := rows.Scan(&grant.ClientID, &grant.ClientName, &scopes, &grant.CreatedAt, &grant.UpdatedAt); err != nil {
+			return nil, err
+		}
+		grant.Scopes = tokenScopes(scopes)
+		grants = append(grants, grant)
+	}
+	return grants, rows.Err()
+}
+
+// DeleteGrant disconnects an app from the user's account, together with the
+// authorization codes it hasn't exchanged yet.
+func (s *OAuthStore) DeleteGrant(ctx context.Context, userID int64, clientID string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			DELETE FROM oauth_grants g USING oauth_clients c
+			WHERE g.client_id = c.id AND g.user_id = $1 AND c.client_id = $2
+		`
+		res, err := tx.ExecContext(ctx, query, userID, clientID)
+		if err != nil { return err }
+		rows, err := res.RowsAffected()
+		if err != nil { return err }
+		if rows == 0 { return ErrNotFound }
+
+		query = `
+			DELETE FROM oauth_codes o USING oauth_clients c
+			WHERE o.client_id = c.id AND o.user_id = $1 AND c.client_id = $2
+		`
+		_, err = tx.ExecContext(ctx, query, userID, clientID)
+		return err
+	})
+}
+
+// DeleteExpiredCodes purges the authorization codes that were never exchanged.
+func (s *OAuthStore) DeleteExpiredCodes(ctx context.Context) (int64, error) {
+	query := `DELETE FROM oauth_codes WHERE expiry < NOW()`
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
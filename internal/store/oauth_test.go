@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOAuthCodes(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := NewStorage(db)
+
+	alice := createTestUser(t, db, "alice")
+	developer := createTestUser(t, db, "developer")
+
+	client := &OAuthClient{ClientID: "app", Name: "App", RedirectURIs: []string{"https://app.example.com"}, OwnerID: developer.ID}
+	if err := s.OAuth.CreateClient(ctx, client, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.OAuth.AuthenticateClient(ctx, "app", "wrong"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("wrong secret: got %v, want ErrNotFound", err)
+	}
+
+	code := &OAuthCode{ClientID: "app", UserID: alice.ID, RedirectURI: "https://app.example.com", Scopes: []string{"posts:read"}, CodeChallenge: "challenge"}
+	if err := s.OAuth.Authorize(ctx, code, "code", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.OAuth.ConsumeCode(ctx, "code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ClientID != "app" || got.UserID != alice.ID || got.CodeChallenge != "challenge" {
+		t.Fatalf("got code %+v", got)
+	}
+	if _, err := s.OAuth.ConsumeCode(ctx, "code"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("replayed code: got %v, want ErrNotFound", err)
+	}
+
+	if err := s.OAuth.Authorize(ctx, code, "expired", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.OAuth.ConsumeCode(ctx, "expired"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expired code: got %v, want ErrNotFound", err)
+	}
+
+	// A grant made again after a revocation is dated after the tokens of the first one.
+	first, err := s.OAuth.GetGrant(ctx, alice.ID, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.OAuth.DeleteGrant(ctx, alice.ID, "app"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.OAuth.GetGrant(ctx, alice.ID, "app"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("revoked grant: got %v, want ErrNotFound", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := s.OAuth.Authorize(ctx, code, "again", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.OAuth.GetGrant(ctx, alice.ID, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.CreatedAt.After(first.CreatedAt) {
+		t.Fatalf("grant made again created at %v, not after the revoked one at %v", second.CreatedAt, first.CreatedAt)
+	}
+}
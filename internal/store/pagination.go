@@ -1,6 +1,7 @@
 package store
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
@@ -12,6 +13,10 @@ type PaginatedFeedQuery struct {
 	Sort   string   `validate:"oneof=asc desc"`// Sort can only be 'asc' or 'desc'
 	Tags   []string `validate:"max=5"`         // Allow a max of 5 tags
 	Search string   `validate:"max=100"`       // Search term max 100 chars
+	// The text search configuration the search is made in, only the posts
+	// written in that language match it.
+	Language string `validate:"oneof=simple arabic danish dutch english finnish french german hungarian indonesian irish italian lithuanian nepali norwegian portuguese romanian russian spanish swedish tamil turkish"`
+	Cursor *Cursor  // set instead of Offset to read the feed page by page
 }
 
 func (fq *PaginatedFeedQuery) Parse(r *http.Request) {
@@ -47,4 +52,154 @@ func (fq *PaginatedFeedQuery) Parse(r *http.Request) {
 	if searchStr := qs.Get("search"); searchStr != "" {
 		fq.Search = searchStr
 	}
-}
\ No newline at end of file
+
+	fq.Language = strings.ToLower(qs.Get("lang"))
+}
+
+// PaginatedQuery is the limit/offset pagination of the lists that can't be sorted or filtered.
+type PaginatedQuery struct {
+	Limit  int `validate:"gte=1,lte=100"`
+	Offset int `validate:"gte=0"`
+	Cursor *Cursor // set instead of Offset by the lists sorted by date
+}
+
+func (pq *PaginatedQuery) Parse(r *http.Request) {
+	pq.Limit = 20
+	pq.Offset = 0
+
+	qs := r.URL.Query()
+
+	if limitStr := qs.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil {
+			pq.Limit = l
+		}
+	}
+
+	if offsetStr := qs.Get("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil {
+			pq.Offset = o
+		}
+	}
+}
+
+// UserSearchQuery is a user search, paginated like the feed.
+type UserSearchQuery struct {
+	Query  string `validate:"required,max=100"`
+	Limit  int    `validate:"gte=1,lte=100"`
+	Offset int    `validate:"gte=0"`
+}
+
+func (sq *UserSearchQuery) Parse(r *http.Request) {
+	sq.Limit = 20
+	sq.Offset = 0
+
+	qs := r.URL.Query()
+
+	sq.Query = strings.TrimPrefix(strings.TrimSpace(qs.Get("q")), "@")
+
+	if limitStr := qs.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil {
+			sq.Limit = l
+		}
+	}
+
+	if offsetStr := qs.Get("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil {
+			sq.Offset = o
+		}
+	}
+}
+
+// UserPostsQuery pages through the posts of a user, newest first.
+type UserPostsQuery struct {
+	Limit  int      `validate:"gte=1,lte=100"`
+	Tags   []string `validate:"max=5"`
+	Cursor *Cursor  // nil for the first page
+}
+
+func (q *UserPostsQuery) Parse(r *http.Request) {
+	q.Limit = 20
+	q.Tags = []string{}
+
+	qs := r.URL.Query()
+
+	if limitStr := qs.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil {
+			q.Limit = l
+		}
+	}
+
+	if tagsStr := qs.Get("tags"); tagsStr != "" {
+		q.Tags = strings.Split(tagsStr, ",")
+	}
+}
+
+// Cursor is a position in a list sorted by (created_at, id), the key of keyset
+// pagination: pages are read from the row after it, in the list's order, or
+// backward from the row before it. Unlike offsets, cursors don't skip or repeat
+// rows when rows are added while the list is read.
+type Cursor struct {
+	CreatedAt string `json:"t"`
+	ID        int64  `json:"i"`
+	Backward  bool   `json:"b,omitempty"`
+}
+
+// Page holds the cursors of the pages around a page, nil when there is no such page.
+type Page struct {
+	Next *Cursor
+	Prev *Cursor
+}
+
+// keyset returns the condition and the ORDER BY columns of a page of a list
+// sorted by (createdAt, id), newest first when desc. The condition expects
+// the values of cursorArgs bound to $n and $n+1.
+func keyset(c *Cursor, createdAt, id string, desc bool, n int) (cond, order string) {
+	op, dir := "<", "DESC"
+	if desc == (c != nil && c.Backward) {
+		op, dir = ">", "ASC"
+	}
+
+	cond = fmt.Sprintf("($%d::timestamptz IS NULL OR (%s, %s) %s ($%d::timestamptz, $%d::bigint))", n, createdAt, id, op, n, n+1)
+	order = fmt.Sprintf("%s %s, %s %s", createdAt, dir, id, dir)
+	return cond, order
+}
+
+// cursorArgs returns the values keyset binds, a NULL date lets every row through.
+func cursorArgs(c *Cursor) []any {
+	if c == nil {
+		return []any{nil, int64(0)}
+	}
+	return []any{c.CreatedAt, c.ID}
+}
+
+// paginate takes the rows of a page, fetched with one row more than limit to
+// tell whether the list goes on. It drops that row, puts the rows of a page
+// read backward back in order, and returns the cursors around the page.
+func paginate[T any](rows []T, limit int, c *Cursor, key func(T) Cursor) ([]T, Page) {
+	backward := c != nil && c.Backward
+	more := len(rows) > limit
+	if more {
+		rows = rows[:limit]
+	}
+	if backward {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	var page Page
+	if len(rows) == 0 {
+		return rows, page
+	}
+	first, last := key(rows[0]), key(rows[len(rows)-1])
+	first.Backward = true
+
+	// Reading from a cursor means there were rows on its other side.
+	if more || backward {
+		page.Next = &last
+	}
+	if (more && backward) || (c != nil && !backward) {
+		page.Prev = &first
+	}
+	return rows, page
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PersonalAccessToken lets scripts and bots act as a user, limited to a set of scopes.
+// Only the hash of the token is stored, the plain token is shown once at creation.
+type PersonalAccessToken struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     *time.Time `json:"expires_at"`
+	CreatedAt  string     `json:"created_at"`
+}
+
+type PersonalTokenStore struct {
+	db *sql.DB
+}
+
+func (s *PersonalTokenStore) Create(ctx context.Context, pat *PersonalAccessToken, plainToken string) error {
+	query := `
+		INSERT INTO personal_access_tokens (user_id, name, token_hash, scopes, expiry)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at
+	`
+	return s.db.QueryRowContext(ctx, query, pat.UserID, pat.Name, hashToken(plainToken), pq.Array(pat.Scopes), pat.Expiry).Scan(&pat.ID, &pat.CreatedAt)
+}
+
+func (s *PersonalTokenStore) GetByUserID(ctx context.Context, userID int64) ([]PersonalAccessToken, error) {
+	query := `
+		SELECT id, user_id, name, scopes, last_used_at, expiry, created_at
+		FROM personal_access_tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []PersonalAccessToken{}
+	for rows.Next() {
+		var pat PersonalAccessToken
+		var scopes pq.StringArray
+		if err := rows.Scan(&pat.ID, &pat.UserID, &pat.Name, &scopes, &pat.LastUsedAt, &pat.Expiry, &pat.CreatedAt); err != nil {
+			return nil, err
+		}
+		pat.Scopes = tokenScopes(scopes)
+		tokens = append(tokens, pat)
+	}
+	return tokens, rows.Err()
+}
+
+// Authenticate looks a token up by its plain value and records that it was used.
+// Expired tokens are reported as ErrNotFound.
+func (s *PersonalTokenStore) Authenticate(ctx context.Context, plainToken string) (*PersonalAccessToken, error) {
+	query := `
+		UPDATE personal_access_tokens SET last_used_at = NOW()
+		WHERE token_hash = $1 AND (expiry IS NULL OR expiry > NOW())
+		RETURNING id, user_id, name, scopes, last_used_at, expiry, created_at
+	`
+	var pat PersonalAccessToken
+	var scopes pq.StringArray
+	err := s.db.QueryRowContext(ctx, query, hashToken(plainToken)).Scan(
+		&pat.ID, &pat.UserID, &pat.Name, &scopes, &pat.LastUsedAt, &pat.Expiry, &pat.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) { return nil, ErrNotFound }
+		return nil, err
+	}
+	pat.Scopes = tokenScopes(scopes)
+
+	return &pat, nil
+}
+
+// Delete revokes one of the user's tokens.
+func (s *PersonalTokenStore) Delete(ctx context.Context, tokenID, userID int64) error {
+	query := `DELETE FROM personal_access_tokens WHERE id = $1 AND user_id = $2`
+
+	res, err := s.db.ExecContext(ctx, query, tokenID, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// tokenScopes returns the scopes read from the database, which scans an empty
+// array as nil, as a slice that is never nil: a token or an app granted no
+// scope must not be taken for a session.
+func tokenScopes(scopes pq.StringArray) []string {
+	if scopes == nil {
+		return []string{}
+	}
+	return scopes
+}
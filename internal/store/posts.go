@@ -17,7 +17,21 @@ type Post struct {
 	CreatedAt string   `json:"created_at"`
 	UpdatedAt string   `json:"updated_at"`
 	Version   int      `json:"version"`
+	Language  string   `json:"language"` // the text search configuration the post is indexed with
+	// Whether the post is pushed to the cached timelines of the author's followers.
+	Pushed    bool     `json:"-"`
 	Comments  []Comment `json:"comments"`
+	// Only set in the lists of posts that don't embed the comments.
+	Author        *PostAuthor `json:"author,omitempty"`
+	CommentsCount *int        `json:"comments_count,omitempty"`
+}
+
+// PostAuthor is who wrote a post, as shown next to it.
+type PostAuthor struct {
+	ID          int64  `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	AvatarURL   string `json:"avatar_url"`
 }
 
 type PostsStore struct {
@@ -26,8 +40,8 @@ type PostsStore struct {
 
 func (s *PostsStore) Create(ctx context.Context, post *Post) error {
     query := `
-        INSERT INTO posts (content, title, user_id, tags)
-        VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at
+        INSERT INTO posts (content, title, user_id, tags, language, pushed)
+        VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at, updated_at
     `
     err := s.db.QueryRowContext(
         ctx,
@@ -36,6 +50,8 @@ func (s *PostsStore) Create(ctx context.Context, post *Post) error {
         post.Title,
         post.UserID,
         pq.Array(post.Tags),
+        post.Language,
+        post.Pushed,
     ).Scan(
         &post.ID,
         &post.CreatedAt,
@@ -51,7 +67,7 @@ func (s *PostsStore) Create(ctx context.Context, post *Post) error {
 
 func (s *PostsStore) GetByID(ctx context.Context, id int64) (*Post, error) {
 	query := `
-		SELECT id, user_id, title, content, created_at, updated_at, tags, version
+		SELECT id, user_id, title, content, created_at, updated_at, tags, version, language
 		FROM posts
 		WHERE id = $1
 	`
@@ -67,6 +83,7 @@ func (s *PostsStore) GetByID(ctx context.Context, id int64) (*Post, error) {
 		&post.UpdatedAt, 
 		&tags,
 		&post.Version,
+		&post.Language,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -120,37 +137,105 @@ func (s *PostsStore) Delete(ctx context.Context, postID int64) error {
     return nil
 }
 
-func (s *PostsStore) GetUserFeed(ctx context.Context, userID int64, fq PaginatedFeedQuery) ([]Post, error) {
+// GetUserFeed returns the posts of the user and of the users they follow. Private
+// accounts are covered too: until the owner approves it, a follow of a private
+// account is a row of follow_requests, not of followers. Muted and blocked users
+// are left out. The search only matches the posts written in its language, a
+// tsquery built for each post's own language couldn't use the search index.
+func (s *PostsStore) GetUserFeed(ctx context.Context, userID int64, fq PaginatedFeedQuery) ([]Post, Page, error) {
+    cond, order := keyset(fq.Cursor, "p.created_at", "p.id", fq.Sort == "desc", 6)
     query := `
-        SELECT p.id, p.user_id, p.title, p.content, p.created_at, p.updated_at, p.tags, p.version
+        SELECT p.id, p.user_id, p.title, p.content, p.created_at, p.updated_at, p.tags, p.version, p.language
         FROM posts p
         LEFT JOIN followers f ON f.user_id = p.user_id
         WHERE
             (f.follower_id = $1 OR p.user_id = $1) AND
-            (p.title ILIKE '%' || $4 || '%' OR p.content ILIKE '%' || $4 || '%') AND
-            (p.tags @> $5 OR array_length($5, 1) IS NULL)
+            (($4 = '' AND $8 = '') OR (p.language = $9::regconfig AND p.search_vector @@ ` + searchTsquery("$9::regconfig", "$4", "$8") + `)) AND
+            (p.tags @> $5 OR array_length($5, 1) IS NULL) AND
+            ` + hiddenAuthorCondition("p.user_id") + ` AND
+            ` + cond + `
         GROUP BY p.id
-        ORDER BY p.created_at ` + fq.Sort + `
+        ORDER BY ` + order + `
         LIMIT $2 OFFSET $3
         	`
          
-         rows, err := s.db.QueryContext(ctx, query, userID, fq.Limit, fq.Offset, fq.Search, pq.Array(fq.Tags))
+         // One more post than asked tells whether there is a next page.
+         terms, prefixes := searchTerms(fq.Search)
+         args := append([]any{userID, fq.Limit + 1, fq.Offset, terms, pq.Array(fq.Tags)}, cursorArgs(fq.Cursor)...)
+         args = append(args, prefixes, fq.Language)
+         rows, err := s.db.QueryContext(ctx, query, args...)
              if err != nil {
-                 return nil, err
+                 return nil, Page{}, err
              }
              defer rows.Close()
          
-             var feed []Post
+             feed := []Post{}
              for rows.Next() {
                  var p Post
                  var tags pq.StringArray
                  err := rows.Scan(
                      &p.ID, &p.UserID, &p.Title, &p.Content,
-                     &p.CreatedAt, &p.UpdatedAt, &tags, &p.Version,
+                     &p.CreatedAt, &p.UpdatedAt, &tags, &p.Version, &p.Language,
                  )
-                 if err != nil { return nil, err }
+                 if err != nil { return nil, Page{}, err }
                  p.Tags = tags
                  feed = append(feed, p)
              }
-             return feed, rows.Err()
+             if err := rows.Err(); err != nil { return nil, Page{}, err }
+
+             feed, page := paginate(feed, fq.Limit, fq.Cursor, postCursor)
+             return feed, page, nil
          }
+
+// GetByUser returns a page of the posts of the user, newest first, with their
+// author and how many comments they have.
+func (s *PostsStore) GetByUser(ctx context.Context, userID int64, q UserPostsQuery) ([]Post, Page, error) {
+	cond, order := keyset(q.Cursor, "p.created_at", "p.id", true, 4)
+	query := `
+		SELECT p.id, p.user_id, p.title, p.content, p.created_at, p.updated_at, p.tags, p.version, p.language,
+			u.username, u.display_name, u.avatar_url,
+			(SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id)
+		FROM posts p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.user_id = $1 AND
+			(p.tags @> $2 OR array_length($2, 1) IS NULL) AND
+			` + cond + `
+		ORDER BY ` + order + `
+		LIMIT $3
+	`
+	args := append([]any{userID, pq.Array(q.Tags), q.Limit + 1}, cursorArgs(q.Cursor)...)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		var p Post
+		var tags pq.StringArray
+		var author PostAuthor
+		var commentsCount int
+		err := rows.Scan(
+			&p.ID, &p.UserID, &p.Title, &p.Content, &p.CreatedAt, &p.UpdatedAt, &tags, &p.Version, &p.Language,
+			&author.Username, &author.DisplayName, &author.AvatarURL,
+			&commentsCount,
+		)
+		if err != nil {
+			return nil, Page{}, err
+		}
+		p.Tags = tags
+		author.ID = p.UserID
+		p.Author = &author
+		p.CommentsCount = &commentsCount
+		posts = append(posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, Page{}, err
+	}
+
+	posts, page := paginate(posts, q.Limit, q.Cursor, postCursor)
+	return posts, page, nil
+}
+
+func postCursor(p Post) Cursor { return Cursor{CreatedAt: p.CreatedAt, ID: p.ID} }
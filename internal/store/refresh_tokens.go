@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// ErrTokenReused is returned when a refresh token that was already rotated is
+// presented again. The whole token family is revoked when this happens.
+var ErrTokenReused = errors.New("refresh token has already been used")
+
+type RefreshTokenStore struct {
+	db *sql.DB
+}
+
+func hashToken(plainToken string) string {
+	hash := sha256.Sum256([]byte(plainToken))
+	return hex.EncodeToString(hash[:])
+}
+
+// Create stores the hash of a new refresh token that starts (or continues) the given family.
+func (s *RefreshTokenStore) Create(ctx context.Context, userID int64, familyID, plainToken string, exp time.Duration) error {
+	query := `INSERT INTO refresh_tokens (token_hash, user_id, family_id, expiry) VALUES ($1, $2, $3, $4)`
+	_, err := s.db.ExecContext(ctx, query, hashToken(plainToken), userID, familyID, time.Now().Add(exp))
+	return err
+}
+
+// Rotate exchanges a valid refresh token for a new one in the same family and
+// returns the owner's ID and the family. Presenting a token that was already rotated or revoked
+// is treated as theft: the whole family is revoked and ErrTokenReused is returned.
+// The token of an account that is no longer active isn't rotated, it fails with ErrNotFound.
+func (s *RefreshTokenStore) Rotate(ctx context.Context, oldPlainToken, newPlainToken string, exp time.Duration) (int64, string, error) {
+	var (
+		userID   int64
+		familyID string
+		reused   bool
+	)
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		var (
+			id        int64
+			expiry    time.Time
+			revokedAt sql.NullTime
+			active    bool
+		)
+		query := `
+			SELECT rt.id, rt.user_id, rt.family_id, rt.expiry, rt.revoked_at, u.is_active
+			FROM refresh_tokens rt
+			JOIN users u ON u.id = rt.user_id
+			WHERE rt.token_hash = $1
+			FOR UPDATE OF rt
+		`
+		err := tx.QueryRowContext(ctx, query, hashToken(oldPlainToken)).Scan(&id, &userID, &familyID, &expiry, &revokedAt, &active)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) { return ErrNotFound }
+			return err
+		}
+
+		if revokedAt.Valid {
+			// The token was already used once. Revoke every token of the family
+			// and commit, so the legitimate owner has to log in again.
+			reused = true
+			query = `UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL`
+			_, err = tx.ExecContext(ctx, query, familyID)
+			return err
+		}
+		if time.Now().After(expiry) || !active { return ErrNotFound }
+
+		query = `UPDATE refresh_tokens SET revoked_at = NOW() WHERE id = $1`
+		if _, err := tx.ExecContext(ctx, query, id); err != nil { return err }
+
+		query = `INSERT INTO refresh_tokens (token_hash, user_id, family_id, expiry) VALUES ($1, $2, $3, $4)`
+		_, err = tx.ExecContext(ctx, query, hashToken(newPlainToken), userID, familyID, time.Now().Add(exp))
+		return err
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	if reused {
+		return 0, "", ErrTokenReused
+	}
+
+	return userID, familyID, nil
+}
+
+// RevokeFamily revokes every refresh token in the family the given token
+// belongs to. Tokens of other users are left alone.
+func (s *RefreshTokenStore) RevokeFamily(ctx context.Context, userID int64, plainToken string) error {
+	query := `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE revoked_at IS NULL AND user_id = $2 AND family_id = (
+			SELECT family_id FROM refresh_tokens WHERE token_hash = $1 AND user_id = $2
+		)
+	`
+	_, err := s.db.ExecContext(ctx, query, hashToken(plainToken), userID)
+	return err
+}
+
+// DeleteExpired purges the refresh tokens that expired. Rotated and revoked
+// tokens are kept until then, to detect their reuse.
+func (s *RefreshTokenStore) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `DELETE FROM refresh_tokens WHERE expiry < NOW()`
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRefreshTokenRotate(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := NewStorage(db)
+
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+
+	if err := s.RefreshTokens.Create(ctx, alice.ID, "b7d2a2f4-6f0e-4f6b-9a55-0c2f1e0f4a01", "first", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	userID, family, err := s.RefreshTokens.Rotate(ctx, "first", "second", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != alice.ID || family != "b7d2a2f4-6f0e-4f6b-9a55-0c2f1e0f4a01" {
+		t.Fatalf("Rotate() = %d, %q; want alice's token family", userID, family)
+	}
+
+	// The rotated token is presented again: the whole family is revoked.
+	if _, _, err := s.RefreshTokens.Rotate(ctx, "first", "third", time.Hour); !errors.Is(err, ErrTokenReused) {
+		t.Fatalf("reused token: got %v, want ErrTokenReused", err)
+	}
+	if _, _, err := s.RefreshTokens.Rotate(ctx, "second", "third", time.Hour); !errors.Is(err, ErrTokenReused) {
+		t.Fatalf("latest token of a revoked family: got %v, want ErrTokenReused", err)
+	}
+
+	if _, _, err := s.RefreshTokens.Rotate(ctx, "unknown", "third", time.Hour); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("unknown token: got %v, want ErrNotFound", err)
+	}
+
+	if err := s.RefreshTokens.Create(ctx, alice.ID, "b7d2a2f4-6f0e-4f6b-9a55-0c2f1e0f4a02", "expired", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.RefreshTokens.Rotate(ctx, "expired", "third", time.Hour); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expired token: got %v, want ErrNotFound", err)
+	}
+	// Only the expired token goes, the rotated ones are kept to detect their reuse.
+	if deleted, err := s.RefreshTokens.DeleteExpired(ctx); err != nil || deleted != 1 {
+		t.Fatalf("DeleteExpired() = %d, %v; want 1", deleted, err)
+	}
+
+	t.Run("revoke family", func(t *testing.T) {
+		if err := s.RefreshTokens.Create(ctx, alice.ID, "b7d2a2f4-6f0e-4f6b-9a55-0c2f1e0f4a03", "alice", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+
+		// Only the owner can revoke a family.
+		if err := s.RefreshTokens.RevokeFamily(ctx, bob.ID, "alice"); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := s.RefreshTokens.Rotate(ctx, "alice", "alice-2", time.Hour); err != nil {
+			t.Fatalf("token revoked by someone else: %v", err)
+		}
+
+		if err := s.RefreshTokens.RevokeFamily(ctx, alice.ID, "alice"); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := s.RefreshTokens.Rotate(ctx, "alice-2", "alice-3", time.Hour); !errors.Is(err, ErrTokenReused) {
+			t.Fatalf("token of a revoked family: got %v, want ErrTokenReused", err)
+		}
+	})
+}
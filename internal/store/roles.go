@@ -0,0 +1,30 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+type Role struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Level       int    `json:"level"`
+	Description string `json:"description"`
+}
+
+type RoleStore struct {
+	db *sql.DB
+}
+
+func (s *RoleStore) GetByName(ctx context.Context, name string) (*Role, error) {
+	query := `SELECT id, name, level, description FROM roles WHERE name = $1`
+
+	var role Role
+	err := s.db.QueryRowContext(ctx, query, name).Scan(&role.ID, &role.Name, &role.Level, &role.Description)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) { return nil, ErrNotFound }
+		return nil, err
+	}
+	return &role, nil
+}
@@ -0,0 +1,248 @@
+package store
+
+import (
+	"context"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// SearchLanguages are the text search configurations of Postgres posts can be
+// written and searched in, the same as in the oneof validations.
+var SearchLanguages = []string{
+	"simple", "arabic", "danish", "dutch", "english", "finnish", "french", "german", "hungarian", "indonesian", "irish",
+	"italian", "lithuanian", "nepali", "norwegian", "portuguese", "romanian", "russian", "spanish", "swedish", "tamil", "turkish",
+}
+
+// SearchQuery is a full-text search of the posts or of the comments, best
+// matches first. Query takes the syntax of search engines: "quoted phrases",
+// or, -excluded words, plus words ending with * matched as prefixes.
+type SearchQuery struct {
+	Query string `validate:"required,max=100"`
+	// The text search configuration of Postgres to search in, only the posts
+	// and comments written in that language are searched.
+	Language string   `validate:"oneof=simple arabic danish dutch english finnish french german hungarian indonesian irish italian lithuanian nepali norwegian portuguese romanian russian spanish swedish tamil turkish"`
+	Tags     []string `validate:"max=5"`   // only filter posts
+	Author   string   `validate:"max=100"` // a username, empty for anyone
+	Limit    int      `validate:"gte=1,lte=100"`
+	Offset   int      `validate:"gte=0"`
+}
+
+func (sq *SearchQuery) Parse(r *http.Request) {
+	sq.Limit = 20
+	sq.Offset = 0
+	sq.Tags = []string{}
+
+	qs := r.URL.Query()
+
+	sq.Query = strings.TrimSpace(qs.Get("q"))
+	sq.Language = strings.ToLower(qs.Get("lang"))
+	sq.Author = strings.TrimPrefix(qs.Get("author"), "@")
+
+	if tagsStr := qs.Get("tags"); tagsStr != "" {
+		sq.Tags = strings.Split(tagsStr, ",")
+	}
+
+	if limitStr := qs.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil {
+			sq.Limit = l
+		}
+	}
+
+	if offsetStr := qs.Get("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil {
+			sq.Offset = o
+		}
+	}
+}
+
+// PostSearchResult is a post matching a search. The highlights are HTML: the
+// text is escaped and the matches are wrapped in <mark> tags.
+type PostSearchResult struct {
+	Post
+	Rank           float64 `json:"rank"`
+	TitleHighlight string  `json:"title_highlight"`
+	Snippet        string  `json:"snippet"`
+}
+
+// CommentSearchResult is a comment matching a search, highlighted like a post.
+type CommentSearchResult struct {
+	Comment
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// Search finds the posts matching the query that viewerID can see, with their
+// author. Matches in the title rank above matches in the content.
+func (s *PostsStore) Search(ctx context.Context, viewerID int64, sq SearchQuery) ([]PostSearchResult, error) {
+	query := `
+		WITH q AS (
+			SELECT ` + searchTsquery("$2::regconfig", "$3", "$4") + ` AS query
+		), matches AS (
+			SELECT p.id, ts_rank_cd(p.search_vector, q.query, 32) AS rank
+			FROM posts p
+			CROSS JOIN q
+			JOIN users u ON u.id = p.user_id
+			WHERE p.language = $2::regconfig AND p.search_vector @@ q.query AND
+				(p.tags @> $5 OR array_length($5, 1) IS NULL) AND
+				($6 = '' OR u.username = $6) AND
+				` + visiblePostsCondition("u") + ` AND
+				` + hiddenAuthorCondition("p.user_id") + `
+			ORDER BY rank DESC, p.created_at DESC, p.id DESC
+			LIMIT $7 OFFSET $8
+		)
+		SELECT p.id, p.user_id, p.title, p.content, p.created_at, p.updated_at, p.tags, p.version, p.language,
+			u.username, u.display_name, u.avatar_url,
+			m.rank,
+			ts_headline(p.language, p.title, q.query, '` + titleHeadlineOptions + `'),
+			ts_headline(p.language, p.content, q.query, '` + snippetHeadlineOptions + `')
+		FROM matches m
+		JOIN posts p ON p.id = m.id
+		JOIN users u ON u.id = p.user_id
+		CROSS JOIN q
+		ORDER BY m.rank DESC, p.created_at DESC, p.id DESC
+	`
+	terms, prefixes := searchTerms(sq.Query)
+	rows, err := s.db.QueryContext(ctx, query, viewerID, sq.Language, terms, prefixes, pq.Array(sq.Tags), sq.Author, sq.Limit, sq.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []PostSearchResult{}
+	for rows.Next() {
+		var res PostSearchResult
+		var tags pq.StringArray
+		var author PostAuthor
+		err := rows.Scan(
+			&res.ID, &res.UserID, &res.Title, &res.Content, &res.CreatedAt, &res.UpdatedAt, &tags, &res.Version, &res.Language,
+			&author.Username, &author.DisplayName, &author.AvatarURL,
+			&res.Rank, &res.TitleHighlight, &res.Snippet,
+		)
+		if err != nil {
+			return nil, err
+		}
+		res.Tags = tags
+		author.ID = res.UserID
+		res.Author = &author
+		res.TitleHighlight = highlight(res.TitleHighlight)
+		res.Snippet = highlight(res.Snippet)
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+// Search finds the comments matching the query on the posts viewerID can see.
+func (s *CommentStore) Search(ctx context.Context, viewerID int64, sq SearchQuery) ([]CommentSearchResult, error) {
+	query := `
+		WITH q AS (
+			SELECT ` + searchTsquery("$2::regconfig", "$3", "$4") + ` AS query
+		), matches AS (
+			SELECT c.id, ts_rank_cd(c.search_vector, q.query, 32) AS rank
+			FROM comments c
+			CROSS JOIN q
+			JOIN users u ON u.id = c.user_id
+			JOIN posts p ON p.id = c.post_id
+			JOIN users pu ON pu.id = p.user_id
+			WHERE c.language = $2::regconfig AND c.search_vector @@ q.query AND
+				($5 = '' OR u.username = $5) AND
+				` + visiblePostsCondition("pu") + ` AND
+				` + hiddenAuthorCondition("c.user_id") + ` AND
+				` + hiddenAuthorCondition("p.user_id") + `
+			ORDER BY rank DESC, c.created_at DESC, c.id DESC
+			LIMIT $6 OFFSET $7
+		)
+		SELECT c.id, c.post_id, c.user_id, c.content, c.created_at, u.username,
+			m.rank,
+			ts_headline(c.language, c.content, q.query, '` + snippetHeadlineOptions + `')
+		FROM matches m
+		JOIN comments c ON c.id = m.id
+		JOIN users u ON u.id = c.user_id
+		CROSS JOIN q
+		ORDER BY m.rank DESC, c.created_at DESC, c.id DESC
+	`
+	terms, prefixes := searchTerms(sq.Query)
+	rows, err := s.db.QueryContext(ctx, query, viewerID, sq.Language, terms, prefixes, sq.Author, sq.Limit, sq.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []CommentSearchResult{}
+	for rows.Next() {
+		var res CommentSearchResult
+		err := rows.Scan(&res.ID, &res.PostID, &res.UserID, &res.Content, &res.CreatedAt, &res.User.Username, &res.Rank, &res.Snippet)
+		if err != nil {
+			return nil, err
+		}
+		res.User.ID = res.UserID
+		res.Snippet = highlight(res.Snippet)
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+// searchTerms splits a search into the part websearch_to_tsquery understands
+// and the words ending with *, returned as a to_tsquery expression matching
+// them as prefixes. The prefixes are reduced to letters and digits, so the
+// expression is always valid.
+func searchTerms(search string) (terms, prefixes string) {
+	var rest, words []string
+	inPhrase := false
+	for _, field := range strings.Fields(search) {
+		if !inPhrase && !strings.Contains(field, `"`) && strings.HasSuffix(field, "*") {
+			word := strings.Map(func(r rune) rune {
+				if unicode.IsLetter(r) || unicode.IsDigit(r) {
+					return r
+				}
+				return -1
+			}, field)
+			if word != "" {
+				if strings.HasPrefix(field, "-") {
+					word = "!" + word
+				}
+				words = append(words, word+":*")
+			}
+			continue
+		}
+		if strings.Count(field, `"`)%2 == 1 {
+			inPhrase = !inPhrase
+		}
+		rest = append(rest, field)
+	}
+	return strings.Join(rest, " "), strings.Join(words, " & ")
+}
+
+// searchTsquery is the tsquery of a search in the language lang, the terms and
+// prefixes of searchTerms being bound to the given parameters. An empty part
+// is left out of it.
+func searchTsquery(lang, terms, prefixes string) string {
+	return `(websearch_to_tsquery(` + lang + `, ` + terms + `) && to_tsquery(` + lang + `, ` + prefixes + `))`
+}
+
+// visiblePostsCondition keeps the rows whose post author, the users aliased
+// author, shows their posts to the viewer bound to $1.
+func visiblePostsCondition(author string) string {
+	return `(
+		NOT ` + author + `.is_private OR ` + author + `.id = $1 OR
+		EXISTS (SELECT 1 FROM followers vf WHERE vf.user_id = ` + author + `.id AND vf.follower_id = $1)
+	)`
+}
+
+// ts_headline marks the matches with characters of the private use area, that
+// highlight turns into tags once the text is escaped.
+const (
+	matchStart = "\uE000"
+	matchStop  = "\uE001"
+
+	titleHeadlineOptions   = "HighlightAll=true, StartSel=" + matchStart + ", StopSel=" + matchStop
+	snippetHeadlineOptions = "MaxFragments=2, MaxWords=30, MinWords=10, FragmentDelimiter=\" … \", StartSel=" + matchStart + ", StopSel=" + matchStop
+)
+
+func highlight(headline string) string {
+	return strings.NewReplacer(matchStart, "<mark>", matchStop, "</mark>").Replace(html.EscapeString(headline))
+}
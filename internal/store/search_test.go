@@ -0,0 +1,83 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The startup check of the search language and the validations must agree.
+func TestSearchLanguagesMatchValidation(t *testing.T) {
+	want := "oneof=" + strings.Join(SearchLanguages, " ")
+	for _, v := range []any{SearchQuery{}, PaginatedFeedQuery{}} {
+		field, _ := reflect.TypeOf(v).FieldByName("Language")
+		if tag := field.Tag.Get("validate"); tag != want {
+			t.Errorf("%T.Language is validated with %q, want %q", v, tag, want)
+		}
+	}
+}
+
+func TestFeedSearch(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := NewStorage(db)
+
+	alice := createTestUser(t, db, "alice")
+	english := &Post{UserID: alice.ID, Title: "Running", Content: "I went running today", Tags: []string{}, Language: "english"}
+	french := &Post{UserID: alice.ID, Title: "Courir", Content: "Je suis allée courir", Tags: []string{}, Language: "french"}
+	for _, post := range []*Post{english, french} {
+		if err := s.Posts.Create(ctx, post); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		search   string
+		language string
+		want     []int64
+	}{
+		{"runs", "english", []int64{english.ID}},
+		{"courir", "french", []int64{french.ID}},
+		// Only the posts written in the language of the search match it.
+		{"courir", "english", nil},
+		{"", "english", []int64{french.ID, english.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.search+" in "+tt.language, func(t *testing.T) {
+			feed, _, err := s.Posts.GetUserFeed(ctx, alice.ID, PaginatedFeedQuery{Limit: 10, Sort: "desc", Tags: []string{}, Search: tt.search, Language: tt.language})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(feed) != len(tt.want) {
+				t.Fatalf("got %d posts, want %v", len(feed), tt.want)
+			}
+			for i, p := range feed {
+				if p.ID != tt.want[i] {
+					t.Fatalf("post %d is %d, want %d", i, p.ID, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCommentOnDeletedPost(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := NewStorage(db)
+
+	alice := createTestUser(t, db, "alice")
+	post := &Post{UserID: alice.ID, Title: "title", Content: "content", Tags: []string{}, Language: "english"}
+	if err := s.Posts.Create(ctx, post); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Posts.Delete(ctx, post.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	err := s.Comments.Create(ctx, &Comment{PostID: post.ID, UserID: alice.ID, Content: "nice"})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+}
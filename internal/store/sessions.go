@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Session is a login on a device. Its ID is the family of the refresh tokens
+// the device rotates.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int64     `json:"-"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"` // whether the request was made from this session
+}
+
+type SessionStore struct {
+	db *sql.DB
+}
+
+func (s *SessionStore) Create(ctx context.Context, session *Session, exp time.Duration) error {
+	query := `
+		INSERT INTO sessions (id, user_id, device, user_agent, ip, expiry)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING created_at, last_seen_at
+	`
+	return s.db.QueryRowContext(
+		ctx, query, session.ID, session.UserID, session.Device, session.UserAgent, session.IP, time.Now().Add(exp),
+	).Scan(&session.CreatedAt, &session.LastSeenAt)
+}
+
+// Touch records that the session was just used from ip, and extends it like
+// the refresh token it was used with.
+func (s *SessionStore) Touch(ctx context.Context, sessionID, ip string, exp time.Duration) error {
+	query := `UPDATE sessions SET last_seen_at = NOW(), ip = $1, expiry = $2 WHERE id = $3 AND revoked_at IS NULL`
+	_, err := s.db.ExecContext(ctx, query, ip, time.Now().Add(exp), sessionID)
+	return err
+}
+
+// GetByUserID lists the sessions of the user that are still active, most recently used first.
+func (s *SessionStore) GetByUserID(ctx context.Context, userID int64) ([]Session, error) {
+	query := `
+		SELECT id, user_id, device, user_agent, ip, created_at, last_seen_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expiry > NOW()
+		ORDER BY last_seen_at DESC
+	`
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		if err := rows.Scan(&session.ID, &session.UserID, &session.Device, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastSeenAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// Revoke ends one of the user's sessions and revokes its refresh tokens.
+func (s *SessionStore) Revoke(ctx context.Context, sessionID string, userID int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+		res, err := tx.ExecContext(ctx, query, sessionID, userID)
+		if err != nil { return err }
+		rows, err := res.RowsAffected()
+		if err != nil { return err }
+		if rows == 0 { return ErrNotFound }
+
+		query = `UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL`
+		_, err = tx.ExecContext(ctx, query, sessionID)
+		return err
+	})
+}
+
+// RevokeAll ends every session of the user and revokes all their refresh tokens.
+func (s *SessionStore) RevokeAll(ctx context.Context, userID int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil { return err }
+
+		query = `UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`
+		_, err := tx.ExecContext(ctx, query, userID)
+		return err
+	})
+}
+
+// DeleteExpired purges the sessions that ended.
+func (s *SessionStore) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `DELETE FROM sessions WHERE expiry < NOW() OR revoked_at IS NOT NULL`
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -13,22 +13,139 @@ type Storage struct {
 		GetByID(context.Context, int64)	(*Post, error)
 		Update(context.Context, *Post) error
 		Delete(context.Context, int64) error
-		GetUserFeed(context.Context, int64, PaginatedFeedQuery) ([]Post, error)
+		GetUserFeed(context.Context, int64, PaginatedFeedQuery) ([]Post, Page, error)
+		GetByUser(ctx context.Context, userID int64, q UserPostsQuery) ([]Post, Page, error)
+		GetByIDs(ctx context.Context, viewerID int64, ids []int64) ([]Post, error)
+		Search(ctx context.Context, viewerID int64, sq SearchQuery) ([]PostSearchResult, error)
+		GetTimelineEntries(ctx context.Context, userID int64, limit int) ([]TimelineEntry, error)
+		GetUnpushedEntries(ctx context.Context, userID int64, c *Cursor, limit int) ([]TimelineEntry, error)
 	}
 	Users interface {
 		CreateAndInvite(ctx context.Context, user *User, token string, exp time.Duration) error
 		GetByEmail(context.Context, string) (*User, error)
 		GetByID(context.Context, int64) (*User, error) 
+		GetForLogin(context.Context, int64) (*User, error)
 		Activate(context.Context, string) error
+		CreatePasswordReset(ctx context.Context, userID int64, token string, exp time.Duration) error
+		ResetPassword(ctx context.Context, token, password string) (int64, error)
+		SetRole(ctx context.Context, userID, roleID int64) error
+		CreateUnlockToken(ctx context.Context, userID int64, token string, exp time.Duration) error
+		ConsumeUnlockToken(context.Context, string) (*User, error)
+		Reinvite(ctx context.Context, email, token string, exp time.Duration) (*User, error)
+		DeleteExpiredInvitations(context.Context) (int64, error)
+		DeleteExpiredTokens(context.Context) (int64, error)
+		DeleteUnactivated(ctx context.Context, gracePeriod time.Duration) (int64, error)
+		CreateMagicLink(ctx context.Context, userID int64, token string, exp time.Duration) error
+		ConsumeMagicLink(context.Context, string) (int64, error)
+		CreateEmailChange(ctx context.Context, userID int64, newEmail, token string, exp time.Duration) error
+		ConfirmEmailChange(context.Context, string) (int64, error)
+		UpdateProfile(ctx context.Context, userID int64, update *ProfileUpdate) (*Profile, error)
+		ScheduleDeletion(ctx context.Context, userID int64, gracePeriod time.Duration) (time.Time, error)
+		CancelDeletion(context.Context, int64) (bool, error)
+		DeleteScheduled(context.Context) ([]int64, error)
+		GetIDByUsername(context.Context, string) (int64, error)
+		Search(ctx context.Context, viewerID int64, sq UserSearchQuery) ([]PublicUser, error)
 	}
 	Followers interface {
         Follow(ctx context.Context, followedID, followerID int64) error
         Unfollow(ctx context.Context, followedID, followerID int64) error
+        IsFollowing(ctx context.Context, followedID, followerID int64) (bool, error)
+        GetFollowers(ctx context.Context, userID, viewerID int64, pq PaginatedQuery) ([]PublicUser, Page, error)
+        GetFollowing(ctx context.Context, userID, viewerID int64, pq PaginatedQuery) ([]PublicUser, Page, error)
+        GetFollowerIDs(context.Context, int64) ([]int64, error)
+        Request(ctx context.Context, followedID, followerID int64) error
+        CancelRequest(ctx context.Context, followedID, followerID int64) error
+        GetRequests(ctx context.Context, userID int64, pq PaginatedQuery) ([]PublicUser, Page, error)
+        ApproveRequest(ctx context.Context, userID, requesterID int64) error
+        RejectRequest(ctx context.Context, userID, requesterID int64) error
+        ApproveAllRequests(context.Context, int64) ([]int64, error)
+    }
+    Blocks interface {
+        Block(ctx context.Context, userID, blockedID int64) error
+        Unblock(ctx context.Context, userID, blockedID int64) error
+        IsBlocked(ctx context.Context, userID, otherID int64) (bool, error)
+        GetBlocked(ctx context.Context, userID int64, pq PaginatedQuery) ([]PublicUser, Page, error)
+        Mute(ctx context.Context, userID, mutedID int64) error
+        Unmute(ctx context.Context, userID, mutedID int64) error
+        GetMuted(ctx context.Context, userID int64, pq PaginatedQuery) ([]PublicUser, Page, error)
     }
     Comments interface {
             Create(context.Context, *Comment) error
-            GetByPostID(context.Context, int64) ([]Comment, error)
+            GetByPostID(ctx context.Context, postID, viewerID int64) ([]Comment, error)
+            Search(ctx context.Context, viewerID int64, sq SearchQuery) ([]CommentSearchResult, error)
+            GetByID(context.Context, int64) (*Comment, error)
+            Update(context.Context, *Comment) error
+            Delete(context.Context, int64) error
         }
+	RefreshTokens interface {
+		Create(ctx context.Context, userID int64, familyID, token string, exp time.Duration) error
+		Rotate(ctx context.Context, oldToken, newToken string, exp time.Duration) (int64, string, error)
+		RevokeFamily(ctx context.Context, userID int64, token string) error
+		DeleteExpired(context.Context) (int64, error)
+	}
+	TwoFactor interface {
+		Enroll(ctx context.Context, userID int64, secret []byte, recoveryCodes []string) error
+		Get(context.Context, int64) (*TwoFactor, error)
+		Enable(ctx context.Context, userID, step int64) error
+		Disable(context.Context, int64) error
+		UseStep(ctx context.Context, userID, step int64) error
+		UseRecoveryCode(ctx context.Context, userID int64, code string) error
+	}
+	Roles interface {
+		GetByName(context.Context, string) (*Role, error)
+	}
+	PersonalTokens interface {
+		Create(ctx context.Context, pat *PersonalAccessToken, token string) error
+		GetByUserID(context.Context, int64) ([]PersonalAccessToken, error)
+		Authenticate(context.Context, string) (*PersonalAccessToken, error)
+		Delete(ctx context.Context, tokenID, userID int64) error
+	}
+	Audit interface {
+		Log(context.Context, *AuditEntry) error
+	}
+	Identities interface {
+		CreateState(ctx context.Context, provider, state, codeVerifier string, exp time.Duration) error
+		ConsumeState(ctx context.Context, provider, state string) (string, error)
+		GetUserID(ctx context.Context, provider, subject string) (int64, error)
+		Link(ctx context.Context, provider, subject, email string) (int64, error)
+		CreateUser(ctx context.Context, user *User, provider, subject string) error
+		DeleteExpiredStates(context.Context) (int64, error)
+	}
+	Sessions interface {
+		Create(ctx context.Context, session *Session, exp time.Duration) error
+		Touch(ctx context.Context, sessionID, ip string, exp time.Duration) error
+		GetByUserID(context.Context, int64) ([]Session, error)
+		Revoke(ctx context.Context, sessionID string, userID int64) error
+		RevokeAll(context.Context, int64) error
+		DeleteExpired(context.Context) (int64, error)
+	}
+	Suggestions interface {
+		Recompute(ctx context.Context, perUser, perTag int) (int64, error)
+		GetForUser(ctx context.Context, userID int64, pq PaginatedQuery) ([]Suggestion, error)
+		GetPopular(ctx context.Context, userID int64, pq PaginatedQuery) ([]Suggestion, error)
+		Dismiss(ctx context.Context, userID, dismissedID int64) error
+	}
+	Exports interface {
+		GetUserData(context.Context, int64) (*UserData, error)
+		Start(ctx context.Context, userID int64, staleAfter time.Duration) error
+		Cancel(context.Context, int64) error
+		Create(ctx context.Context, userID int64, fileName, token string, exp time.Duration) error
+		GetFileName(context.Context, string) (string, error)
+		DeleteExpired(context.Context) ([]string, error)
+	}
+	OAuth interface {
+		CreateClient(ctx context.Context, client *OAuthClient, secret string) error
+		GetClient(context.Context, string) (*OAuthClient, error)
+		AuthenticateClient(ctx context.Context, clientID, secret string) (*OAuthClient, error)
+		GetClientsByOwner(context.Context, int64) ([]OAuthClient, error)
+		DeleteClient(ctx context.Context, clientID string, ownerID int64) error
+		Authorize(ctx context.Context, code *OAuthCode, plainCode string, exp time.Duration) error
+		ConsumeCode(context.Context, string) (*OAuthCode, error)
+		GetGrant(ctx context.Context, userID int64, clientID string) (*OAuthGrant, error)
+		GetGrantsByUser(context.Context, int64) ([]OAuthGrant, error)
+		DeleteGrant(ctx context.Context, userID int64, clientID string) error
+		DeleteExpiredCodes(context.Context) (int64, error)
+	}
 }
 
 var (
@@ -41,7 +158,18 @@ func NewStorage(db *sql.DB) Storage {
 		Posts:     &PostsStore{db},
 		Users: 	   &UsersStore{db},
 		Followers: &FollowersStore{db},
+		Blocks:    &BlockStore{db},
 		Comments:  &CommentStore{db},
+		RefreshTokens: &RefreshTokenStore{db},
+		TwoFactor: &TwoFactorStore{db},
+		Roles:     &RoleStore{db},
+		PersonalTokens: &PersonalTokenStore{db},
+		Audit:     &AuditStore{db},
+		Identities: &IdentityStore{db},
+		OAuth:     &OAuthStore{db},
+		Sessions:  &SessionStore{db},
+		Exports:   &ExportStore{db},
+		Suggestions: &SuggestionStore{db},
 	}
 }
 
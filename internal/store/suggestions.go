@@ -0,0 +1,226 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// Suggestion is a user worth following, with what the suggestion is based on.
+type Suggestion struct {
+	PublicUser
+	Score           float64 `json:"score"`
+	MutualFollowers int     `json:"mutual_followers"` // followed users who follow them
+}
+
+// SuggestionStore computes who each user could follow. Suggestions are
+// recomputed periodically into follow_suggestions, computing them on every
+// request would cost too much.
+type SuggestionStore struct {
+	db *sql.DB
+}
+
+// suggestableCondition leaves out the candidates the user already follows or
+// asked to, dismissed, or is blocked with, and the accounts that can't be followed.
+func suggestableCondition(user, candidate string) string {
+	return `
+		` + candidate + ` <> ` + user + ` AND
+		EXISTS (SELECT 1 FROM users cu WHERE cu.id = ` + candidate + ` AND cu.is_active = TRUE AND cu.deletion_scheduled_at IS NULL) AND
+		NOT EXISTS (SELECT 1 FROM followers sf WHERE sf.user_id = ` + candidate + ` AND sf.follower_id = ` + user + `) AND
+		NOT EXISTS (SELECT 1 FROM follow_requests sr WHERE sr.user_id = ` + candidate + ` AND sr.requester_id = ` + user + `) AND
+		NOT EXISTS (SELECT 1 FROM dismissed_suggestions sd WHERE sd.user_id = ` + user + ` AND sd.dismissed_id = ` + candidate + `) AND
+		NOT EXISTS (
+			SELECT 1 FROM blocks sb
+			WHERE (sb.user_id = ` + user + ` AND sb.blocked_id = ` + candidate + `) OR (sb.user_id = ` + candidate + ` AND sb.blocked_id = ` + user + `)
+		)
+	`
+}
+
+// suggestionsLockKey is the Postgres advisory lock held while the suggestions are
+// recomputed, so only one instance of the API does it at a time.
+const suggestionsLockKey = 7461726
+
+// suggestionsBatchSize is how many users get their suggestions recomputed per statement.
+const suggestionsBatchSize = 500
+
+// ErrRecomputing is returned when another instance is already recomputing the suggestions.
+var ErrRecomputing = errors.New("suggestions are being recomputed by another instance")
+
+// Recompute replaces every user's suggestions with their perUser best candidates,
+// and returns how many suggestions were stored. Candidates score:
+//   - 1 per followed user who follows them (friends of friends),
+//   - 0.5 per tag both users posted with (shared interests), among the perTag
+//     authors who posted the most with the tag,
+//   - 0.2 * ln(1 + followers) if they are among the most followed accounts (popularity).
+//
+// Users are handled in batches, each one replacing the suggestions of its users
+// in place: the others keep theirs meanwhile.
+func (s *SuggestionStore) Recompute(ctx context.Context, perUser, perTag int) (int64, error) {
+	// Session-level advisory locks belong to a connection, the batches all run on this one.
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, suggestionsLockKey).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, ErrRecomputing
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, suggestionsLockKey)
+
+	var stored int64
+	var lastID int64
+	for {
+		var userIDs pq.Int64Array
+		query := `SELECT COALESCE(array_agg(id), '{}') FROM (SELECT id FROM users WHERE is_active = TRUE AND id > $1 ORDER BY id LIMIT $2) batch`
+		if err := conn.QueryRowContext(ctx, query, lastID, suggestionsBatchSize).Scan(&userIDs); err != nil {
+			return stored, err
+		}
+		if len(userIDs) == 0 {
+			return stored, nil
+		}
+		lastID = userIDs[len(userIDs)-1]
+
+		var batchStored int64
+		if err := conn.QueryRowContext(ctx, recomputeQuery, userIDs, perUser, perTag).Scan(&batchStored); err != nil {
+			return stored, err
+		}
+		stored += batchStored
+	}
+}
+
+// recomputeQuery replaces the suggestions of the users in $1 with their $2 best
+// candidates, taking the $3 most active authors of each tag into account.
+var recomputeQuery = `
+	WITH batch AS (
+		SELECT unnest($1::bigint[]) AS user_id
+	), user_tags AS (
+		SELECT DISTINCT p.user_id, t.tag
+		FROM posts p, unnest(p.tags) AS t(tag)
+		WHERE p.user_id = ANY($1)
+	), tag_authors AS (
+		-- Popular tags would otherwise make everyone who used them a candidate.
+		SELECT user_id, tag FROM (
+			SELECT p.user_id, t.tag, row_number() OVER (PARTITION BY t.tag ORDER BY COUNT(*) DESC, p.user_id) AS rank
+			FROM posts p, unnest(p.tags) AS t(tag)
+			WHERE t.tag IN (SELECT tag FROM user_tags)
+			GROUP BY p.user_id, t.tag
+		) authors
+		WHERE rank <= $3
+	), popular AS (
+		SELECT id, followers_count FROM users
+		WHERE is_active = TRUE AND followers_count > 0
+		ORDER BY followers_count DESC
+		LIMIT 50
+	), candidates AS (
+		SELECT f1.follower_id AS user_id, f2.user_id AS suggested_id, COUNT(*) AS mutuals, COUNT(*)::float8 AS score
+		FROM followers f1
+		JOIN followers f2 ON f2.follower_id = f1.user_id
+		WHERE f1.follower_id = ANY($1)
+		GROUP BY 1, 2
+		UNION ALL
+		SELECT a.user_id, b.user_id, 0, COUNT(*) * 0.5
+		FROM user_tags a
+		JOIN tag_authors b ON b.tag = a.tag AND b.user_id <> a.user_id
+		GROUP BY 1, 2
+		UNION ALL
+		SELECT b.user_id, p.id, 0, 0.2 * ln(1 + p.followers_count)
+		FROM batch b CROSS JOIN popular p
+	), ranked AS (
+		SELECT user_id, suggested_id, SUM(mutuals) AS mutuals, SUM(score) AS score,
+			row_number() OVER (PARTITION BY user_id ORDER BY SUM(score) DESC, suggested_id) AS rank
+		FROM candidates c
+		WHERE ` + suggestableCondition("c.user_id", "c.suggested_id") + `
+		GROUP BY user_id, suggested_id
+	), fresh AS (
+		SELECT user_id, suggested_id, score, mutuals FROM ranked WHERE rank <= $2
+	), upserted AS (
+		INSERT INTO follow_suggestions (user_id, suggested_id, score, mutual_followers)
+		SELECT user_id, suggested_id, score, mutuals FROM fresh
+		ON CONFLICT (user_id, suggested_id) DO UPDATE SET score = EXCLUDED.score, mutual_followers = EXCLUDED.mutual_followers
+		RETURNING 1
+	), stale AS (
+		DELETE FROM follow_suggestions fs
+		WHERE fs.user_id = ANY($1) AND NOT EXISTS (
+			SELECT 1 FROM fresh f WHERE f.user_id = fs.user_id AND f.suggested_id = fs.suggested_id
+		)
+	)
+	SELECT COUNT(*) FROM upserted
+`
+
+// GetForUser returns the computed suggestions of the user, best first. The ones
+// followed, dismissed or blocked since they were computed are left out.
+func (s *SuggestionStore) GetForUser(ctx context.Context, userID int64, pq PaginatedQuery) ([]Suggestion, error) {
+	query := `
+		SELECT ` + publicUserColumns + `, fs.score, fs.mutual_followers
+		FROM follow_suggestions fs
+		JOIN users u ON u.id = fs.suggested_id
+		WHERE fs.user_id = $1 AND ` + suggestableCondition("fs.user_id", "fs.suggested_id") + `
+		ORDER BY fs.score DESC, u.id
+		LIMIT $3 OFFSET $4
+	`
+	return s.list(ctx, query, userID, userID, pq.Limit, pq.Offset)
+}
+
+// GetPopular returns the most followed accounts the user could follow, for the
+// users who registered since the suggestions were last computed.
+func (s *SuggestionStore) GetPopular(ctx context.Context, userID int64, pq PaginatedQuery) ([]Suggestion, error) {
+	query := `
+		SELECT ` + publicUserColumns + `, 0.2 * ln(1 + u.followers_count), 0
+		FROM users u
+		WHERE ` + suggestableCondition("$1::bigint", "u.id") + `
+		ORDER BY u.followers_count DESC, u.id
+		LIMIT $3 OFFSET $4
+	`
+	return s.list(ctx, query, userID, userID, pq.Limit, pq.Offset)
+}
+
+// Dismiss stops suggesting dismissedID to the user. It fails with ErrNotFound
+// when there is no such user.
+func (s *SuggestionStore) Dismiss(ctx context.Context, userID, dismissedID int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `INSERT INTO dismissed_suggestions (user_id, dismissed_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+		if _, err := tx.ExecContext(ctx, query, userID, dismissedID); err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		query = `DELETE FROM follow_suggestions WHERE user_id = $1 AND suggested_id = $2`
+		_, err := tx.ExecContext(ctx, query, userID, dismissedID)
+		return err
+	})
+}
+
+func (s *SuggestionStore) list(ctx context.Context, query string, args ...any) ([]Suggestion, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []Suggestion{}
+	for rows.Next() {
+		var sg Suggestion
+		u := &sg.PublicUser
+		err := rows.Scan(
+			&u.ID, &u.Username, &u.CreatedAt,
+			&u.DisplayName, &u.Bio, &u.Location, &u.Website, &u.AvatarURL, &u.IsPrivate,
+			&u.Counts.Followers, &u.Counts.Following, &u.Counts.Posts,
+			&u.FollowedByMe,
+			&sg.Score, &sg.MutualFollowers,
+		)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, sg)
+	}
+	return suggestions, rows.Err()
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSuggestionsRecompute(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := NewStorage(db)
+
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+	carol := createTestUser(t, db, "carol")
+	dave := createTestUser(t, db, "dave")
+	erin := createTestUser(t, db, "erin")
+
+	// Carol is followed by someone alice follows.
+	if err := s.Followers.Follow(ctx, bob.ID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Followers.Follow(ctx, carol.ID, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	// Dave and erin post with the same tag as alice, dave the most.
+	for _, userID := range []int64{alice.ID, dave.ID, dave.ID, erin.ID} {
+		post := &Post{UserID: userID, Title: "title", Content: "content", Tags: []string{"go"}, Language: "english"}
+		if err := s.Posts.Create(ctx, post); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := s.Suggestions.Recompute(ctx, 10, 1); err != nil {
+		t.Fatal(err)
+	}
+	assertSuggested(t, s, alice.ID, carol.ID, dave.ID)
+
+	// Following a suggestion drops it at the next recomputation.
+	if err := s.Followers.Follow(ctx, carol.ID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Suggestions.Recompute(ctx, 10, 1); err != nil {
+		t.Fatal(err)
+	}
+	var stale int
+	query := `SELECT COUNT(*) FROM follow_suggestions WHERE user_id = $1 AND suggested_id = $2`
+	if err := db.QueryRowContext(ctx, query, alice.ID, carol.ID).Scan(&stale); err != nil {
+		t.Fatal(err)
+	}
+	if stale != 0 {
+		t.Fatal("a followed user is still stored as a suggestion")
+	}
+
+	t.Run("another instance is recomputing", func(t *testing.T) {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, suggestionsLockKey); err != nil {
+			t.Fatal(err)
+		}
+		defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, suggestionsLockKey)
+
+		if _, err := s.Suggestions.Recompute(ctx, 10, 1); !errors.Is(err, ErrRecomputing) {
+			t.Fatalf("got %v, want ErrRecomputing", err)
+		}
+	})
+}
+
+func assertSuggested(t *testing.T, s Storage, userID int64, want ...int64) {
+	t.Helper()
+
+	suggestions, err := s.Suggestions.GetForUser(context.Background(), userID, PaginatedQuery{Limit: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[int64]bool)
+	for _, sg := range suggestions {
+		got[sg.ID] = true
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got suggestions %v, want %v", got, want)
+	}
+	for _, id := range want {
+		if !got[id] {
+			t.Fatalf("got suggestions %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// TimelineEntry is a post of a home timeline, without its content.
+type TimelineEntry struct {
+	PostID    int64
+	CreatedAt time.Time
+}
+
+// Older reports whether e comes after o in a timeline, by creation date then ID.
+func (e TimelineEntry) Older(o TimelineEntry) bool {
+	if !e.CreatedAt.Equal(o.CreatedAt) {
+		return e.CreatedAt.Before(o.CreatedAt)
+	}
+	return e.PostID < o.PostID
+}
+
+// GetTimelineEntries returns the newest entries of the user's cached home
+// timeline: their own posts and the posts pushed by the users they follow. The
+// posts that weren't pushed are read separately.
+func (s *PostsStore) GetTimelineEntries(ctx context.Context, userID int64, limit int) ([]TimelineEntry, error) {
+	query := `
+		SELECT p.id, p.created_at
+		FROM posts p
+		WHERE p.user_id = $1 OR (
+			p.pushed AND
+			p.user_id IN (SELECT user_id FROM followers WHERE follower_id = $1)
+		)
+		ORDER BY p.created_at DESC, p.id DESC
+		LIMIT $2
+	`
+	return s.listTimelineEntries(ctx, query, userID, limit)
+}
+
+// GetUnpushedEntries returns a page of the posts of the authors followed by
+// the user that weren't pushed to their followers' timelines, read from the cursor.
+func (s *PostsStore) GetUnpushedEntries(ctx context.Context, userID int64, c *Cursor, limit int) ([]TimelineEntry, error) {
+	cond, order := keyset(c, "p.created_at", "p.id", true, 3)
+	query := `
+		SELECT p.id, p.created_at
+		FROM posts p
+		JOIN followers f ON f.user_id = p.user_id AND f.follower_id = $1
+		WHERE NOT p.pushed AND ` + cond + `
+		ORDER BY ` + order + `
+		LIMIT $2
+	`
+	args := append([]any{userID, limit}, cursorArgs(c)...)
+	return s.listTimelineEntries(ctx, query, args...)
+}
+
+func (s *PostsStore) listTimelineEntries(ctx context.Context, query string, args ...any) ([]TimelineEntry, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []TimelineEntry
+	for rows.Next() {
+		var e TimelineEntry
+		if err := rows.Scan(&e.PostID, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// GetByIDs loads the posts with the given IDs in one query, in no particular
+// order. Posts that were deleted, or whose author the viewer muted or is
+// blocked with, are left out.
+func (s *PostsStore) GetByIDs(ctx context.Context, viewerID int64, ids []int64) ([]Post, error) {
+	query := `
+		SELECT p.id, p.user_id, p.title, p.content, p.created_at, p.updated_at, p.tags, p.version, p.language
+		FROM posts p
+		WHERE p.id = ANY($2) AND ` + hiddenAuthorCondition("p.user_id") + `
+	`
+	rows, err := s.db.QueryContext(ctx, query, viewerID, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []Post
+	for rows.Next() {
+		var p Post
+		var tags pq.StringArray
+		err := rows.Scan(&p.ID, &p.UserID, &p.Title, &p.Content, &p.CreatedAt, &p.UpdatedAt, &tags, &p.Version, &p.Language)
+		if err != nil {
+			return nil, err
+		}
+		p.Tags = tags
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
+
+// PaginateTimeline reads a page of a home timeline from entries gathered from
+// several sources: it drops the duplicates and the entries on the wrong side
+// of the cursor, sorts the rest newest first and paginates them like a query
+// would.
+func PaginateTimeline(entries []TimelineEntry, c *Cursor, limit int) ([]TimelineEntry, Page, error) {
+	backward := c != nil && c.Backward
+
+	var at TimelineEntry
+	if c != nil {
+		createdAt, err := time.Parse(time.RFC3339Nano, c.CreatedAt)
+		if err != nil {
+			return nil, Page{}, err
+		}
+		at = TimelineEntry{PostID: c.ID, CreatedAt: createdAt}
+	}
+
+	seen := make(map[int64]bool, len(entries))
+	var rows []TimelineEntry
+	for _, e := range entries {
+		if seen[e.PostID] {
+			continue
+		}
+		seen[e.PostID] = true
+
+		if c != nil && ((backward && !at.Older(e)) || (!backward && !e.Older(at))) {
+			continue
+		}
+		rows = append(rows, e)
+	}
+
+	// Like keyset, a page read backward is sorted oldest first until paginate puts it back in order.
+	sort.Slice(rows, func(i, j int) bool {
+		if backward {
+			return rows[i].Older(rows[j])
+		}
+		return rows[j].Older(rows[i])
+	})
+	if len(rows) > limit+1 {
+		rows = rows[:limit+1]
+	}
+
+	rows, page := paginate(rows, limit, c, func(e TimelineEntry) Cursor {
+		return Cursor{CreatedAt: e.CreatedAt.UTC().Format(time.RFC3339Nano), ID: e.PostID}
+	})
+	return rows, page, nil
+}
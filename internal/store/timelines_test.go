@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTimelineEntries(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := NewStorage(db)
+
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+	star := createTestUser(t, db, "star")
+	for _, followedID := range []int64{bob.ID, star.ID} {
+		if err := s.Followers.Follow(ctx, followedID, alice.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	create := func(userID int64, pushed bool) int64 {
+		post := &Post{UserID: userID, Title: "title", Content: "content", Tags: []string{}, Language: "english", Pushed: pushed}
+		if err := s.Posts.Create(ctx, post); err != nil {
+			t.Fatal(err)
+		}
+		return post.ID
+	}
+	own := create(alice.ID, false)
+	pushed := create(bob.ID, true)
+	unpushed := create(star.ID, false)
+
+	entries, err := s.Posts.GetTimelineEntries(ctx, alice.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEntries(t, entries, pushed, own)
+
+	// Whatever the follower count of its author now, a post that wasn't pushed
+	// is read with the unpushed ones.
+	entries, err = s.Posts.GetUnpushedEntries(ctx, alice.ID, nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEntries(t, entries, unpushed)
+}
+
+func assertEntries(t *testing.T, entries []TimelineEntry, want ...int64) {
+	t.Helper()
+
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %v", len(entries), want)
+	}
+	for i, e := range entries {
+		if e.PostID != want[i] {
+			t.Fatalf("entry %d is post %d, want %d", i, e.PostID, want[i])
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// ErrCodeUsed is returned when a one-time code is presented a second time.
+var ErrCodeUsed = errors.New("code has already been used")
+
+// TwoFactor is the TOTP state of a user. Secret is encrypted by the caller.
+type TwoFactor struct {
+	Secret   []byte
+	Enabled  bool
+	LastStep int64
+}
+
+type TwoFactorStore struct {
+	db *sql.DB
+}
+
+// Enroll stores a new (not yet enabled) secret and replaces the recovery codes.
+// It fails with ErrConflict when two-factor authentication is already enabled.
+func (s *TwoFactorStore) Enroll(ctx context.Context, userID int64, secret []byte, recoveryCodes []string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `UPDATE users SET totp_secret = $1, totp_last_step = 0 WHERE id = $2 AND totp_enabled = FALSE`
+		res, err := tx.ExecContext(ctx, query, secret, userID)
+		if err != nil { return err }
+		rows, err := res.RowsAffected()
+		if err != nil { return err }
+		if rows == 0 { return ErrConflict }
+
+		query = `DELETE FROM user_recovery_codes WHERE user_id = $1`
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil { return err }
+
+		query = `INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`
+		for _, code := range recoveryCodes {
+			if _, err := tx.ExecContext(ctx, query, userID, hashToken(code)); err != nil { return err }
+		}
+		return nil
+	})
+}
+
+func (s *TwoFactorStore) Get(ctx context.Context, userID int64) (*TwoFactor, error) {
+	query := `SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE id = $1 AND totp_secret IS NOT NULL`
+
+	var tf TwoFactor
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&tf.Secret, &tf.Enabled, &tf.LastStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) { return nil, ErrNotFound }
+		return nil, err
+	}
+	return &tf, nil
+}
+
+// Enable turns two-factor authentication on once the user proved they can
+// produce codes; step is the time step of that first code.
+func (s *TwoFactorStore) Enable(ctx context.Context, userID, step int64) error {
+	query := `UPDATE users SET totp_enabled = TRUE, totp_last_step = $1 WHERE id = $2 AND totp_secret IS NOT NULL`
+	res, err := s.db.ExecContext(ctx, query, step, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *TwoFactorStore) Disable(ctx context.Context, userID int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `UPDATE users SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = 0 WHERE id = $1`
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil { return err }
+
+		query = `DELETE FROM user_recovery_codes WHERE user_id = $1`
+		_, err := tx.ExecContext(ctx, query, userID)
+		return err
+	})
+}
+
+// UseStep records the time step of an accepted code. A code of the same or an
+// earlier step is refused with ErrCodeUsed, so a code can't be replayed.
+func (s *TwoFactorStore) UseStep(ctx context.Context, userID, step int64) error {
+	query := `UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1`
+	res, err := s.db.ExecContext(ctx, query, step, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrCodeUsed
+	}
+	return nil
+}
+
+// UseRecoveryCode burns one of the user's recovery codes.
+func (s *TwoFactorStore) UseRecoveryCode(ctx context.Context, userID int64, code string) error {
+	query := `UPDATE user_recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	res, err := s.db.ExecContext(ctx, query, userID, hashToken(code))
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"errors"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -48,6 +49,44 @@ type User struct {
 	Password  password `json:"-"`
 	CreatedAt string `json:"created_at"`
 	IsActive  bool     `json:"is_active"`
+	TwoFactorEnabled bool `json:"two_factor_enabled"`
+	RoleID    int64    `json:"role_id"`
+	Role      Role     `json:"role"`
+	Profile
+	Counts
+}
+
+// Profile is what users tell about themselves, and who they show their posts to.
+type Profile struct {
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	Location    string `json:"location"`
+	Website     string `json:"website"`
+	AvatarURL   string `json:"avatar_url"`
+	// The posts of private accounts are only shown to the followers they approved.
+	IsPrivate bool `json:"is_private"`
+}
+
+// Counts are maintained by database triggers, see migration 000024.
+type Counts struct {
+	Followers int64 `json:"followers_count"`
+	Following int64 `json:"following_count"`
+	Posts     int64 `json:"posts_count"`
+}
+
+// PublicUser is how a user is shown to other users: it never carries the email.
+type PublicUser struct {
+	ID        int64  `json:"id"`
+	Username  string `json:"username"`
+	CreatedAt string `json:"created_at"`
+	Profile
+	Counts
+	// FollowedByMe tells whether the user looking at this one follows them.
+	FollowedByMe bool `json:"followed_by_me"`
+}
+
+func (u *User) Public() *PublicUser {
+	return &PublicUser{ID: u.ID, Username: u.Username, CreatedAt: u.CreatedAt, Profile: u.Profile, Counts: u.Counts}
 }
 
 type UsersStore struct {
@@ -87,26 +126,63 @@ func (s *UsersStore) Create(ctx context.Context, user *User) error {
 	return nil
 }
 
-
+// GetByEmail returns an active user, including an account waiting for its
+// deletion: logging in with the email is how its owner keeps it.
 func (s *UsersStore) GetByEmail(ctx context.Context, email string) (*User, error) {
-	query := `SELECT id, username, email, password, created_at FROM users WHERE email = $1 AND is_active = TRUE`
+	query := `
+		SELECT users.id, username, email, password, created_at, totp_enabled, roles.id, roles.name, roles.level, roles.description,
+			display_name, bio, location, website, avatar_url, is_private, followers_count, following_count, posts_count
+		FROM users
+		JOIN roles ON (users.role_id = roles.id)
+		WHERE email = $1 AND is_active = TRUE
+	`
 	var user User
-	err := s.db.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Username, &user.Email, &user.Password.hash, &user.CreatedAt)
+	err := s.db.QueryRowContext(ctx, query, email).Scan(
+		&user.ID, &user.Username, &user.Email, &user.Password.hash, &user.CreatedAt, &user.TwoFactorEnabled,
+		&user.Role.ID, &user.Role.Name, &user.Role.Level, &user.Role.Description,
+		&user.DisplayName, &user.Bio, &user.Location, &user.Website, &user.AvatarURL, &user.IsPrivate,
+		&user.Counts.Followers, &user.Counts.Following, &user.Counts.Posts,
+	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) { return nil, ErrNotFound }
 		return nil, err
 	}
+	user.RoleID = user.Role.ID
 	return &user, nil
 }
     
+// GetByID returns an active user. Accounts waiting for their deletion are not
+// found: they can't be used until their owner logs in again.
 func (s *UsersStore) GetByID(ctx context.Context, userID int64) (*User, error) {
-    query := `SELECT id, username, email, password, created_at FROM users WHERE id = $1 AND is_active = TRUE`
+	return s.getByID(ctx, userID, false)
+}
+
+// GetForLogin returns the active user a login is for, including an account
+// waiting for its deletion, which the login cancels.
+func (s *UsersStore) GetForLogin(ctx context.Context, userID int64) (*User, error) {
+	return s.getByID(ctx, userID, true)
+}
+
+func (s *UsersStore) getByID(ctx context.Context, userID int64, withScheduled bool) (*User, error) {
+    query := `
+        SELECT users.id, username, email, password, created_at, totp_enabled, roles.id, roles.name, roles.level, roles.description,
+            display_name, bio, location, website, avatar_url, is_private, followers_count, following_count, posts_count
+        FROM users
+        JOIN roles ON (users.role_id = roles.id)
+        WHERE users.id = $1 AND is_active = TRUE AND ($2 OR deletion_scheduled_at IS NULL)
+    `
     var user User
-    err := s.db.QueryRowContext(ctx, query, userID).Scan(&user.ID, &user.Username, &user.Email, &user.Password.hash, &user.CreatedAt)
+    err := s.db.QueryRowContext(ctx, query, userID, withScheduled).Scan(
+        &user.ID, &user.Username, &user.Email, &user.Password.hash, &user.CreatedAt, &user.TwoFactorEnabled,
+        &user.Role.ID, &user.Role.Name, &user.Role.Level, &user.Role.Description,
+        &user.DisplayName, &user.Bio, &user.Location, &user.Website, &user.AvatarURL, &user.IsPrivate,
+        &user.Counts.Followers, &user.Counts.Following, &user.Counts.Posts,
+    )
     if err != nil {
         if errors.Is(err, sql.ErrNoRows) { return nil, ErrNotFound }
         return nil, err
     }
+    user.RoleID = user.Role.ID
     return &user, nil
 }
 
@@ -151,4 +227,429 @@ func (s *UsersStore) CreateAndInvite(ctx context.Context, user *User, plainToken
 		_, err = tx.ExecContext(ctx, query, tokenHash, user.ID, time.Now().Add(exp))
 		return err
 	})
-}
\ No newline at end of file
+}
+
+// CreatePasswordReset stores the hash of a single-use password reset token for the user.
+func (s *UsersStore) CreatePasswordReset(ctx context.Context, userID int64, plainToken string, exp time.Duration) error {
+	hash := sha256.Sum256([]byte(plainToken))
+	tokenHash := hex.EncodeToString(hash[:])
+
+	query := `INSERT INTO password_resets (token_hash, user_id, expiry) VALUES ($1, $2, $3)`
+	_, err := s.db.ExecContext(ctx, query, tokenHash, userID, time.Now().Add(exp))
+	return err
+}
+
+// ResetPassword consumes a password reset token, sets the new password and ends
+// every session of the user. It returns the ID of the user whose password changed.
+func (s *UsersStore) ResetPassword(ctx context.Context, plainToken, newPassword string) (int64, error) {
+	hash := sha256.Sum256([]byte(plainToken))
+	tokenHash := hex.EncodeToString(hash[:])
+
+	var pw password
+	if err := pw.Set(newPassword); err != nil {
+		return 0, err
+	}
+
+	var userID int64
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		// Deleting the token first makes it single-use even under concurrent confirmations.
+		query := `DELETE FROM password_resets WHERE token_hash = $1 AND expiry > $2 RETURNING user_id`
+		err := tx.QueryRowContext(ctx, query, tokenHash, time.Now()).Scan(&userID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) { return ErrNotFound }
+			return err
+		}
+
+		query = `UPDATE users SET password = $1 WHERE id = $2`
+		_, err = tx.ExecContext(ctx, query, pw.hash, userID)
+		if err != nil { return err }
+
+		query = `UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`
+		_, err = tx.ExecContext(ctx, query, userID)
+		if err != nil { return err }
+
+		query = `UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`
+		_, err = tx.ExecContext(ctx, query, userID)
+		if err != nil { return err }
+
+		query = `DELETE FROM password_resets WHERE user_id = $1`
+		_, err = tx.ExecContext(ctx, query, userID)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// SetRole changes the role of an active user.
+func (s *UsersStore) SetRole(ctx context.Context, userID, roleID int64) error {
+	query := `UPDATE users SET role_id = $1 WHERE id = $2 AND is_active = TRUE`
+	res, err := s.db.ExecContext(ctx, query, roleID, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CreateUnlockToken stores the hash of a single-use token that lifts a login lockout.
+func (s *UsersStore) CreateUnlockToken(ctx context.Context, userID int64, plainToken string, exp time.Duration) error {
+	query := `INSERT INTO account_unlocks (token_hash, user_id, expiry) VALUES ($1, $2, $3)`
+	_, err := s.db.ExecContext(ctx, query, hashToken(plainToken), userID, time.Now().Add(exp))
+	return err
+}
+
+// ConsumeUnlockToken burns an unlock token, with the other unlock tokens of its
+// user, and returns the user it was issued to. Consuming it is a single
+// statement so a token can't be used twice concurrently.
+func (s *UsersStore) ConsumeUnlockToken(ctx context.Context, plainToken string) (*User, error) {
+	query := `
+		WITH consumed AS (
+			DELETE FROM account_unlocks WHERE token_hash = $1 AND expiry > $2
+			RETURNING user_id
+		), others AS (
+			DELETE FROM account_unlocks
+			WHERE user_id IN (SELECT user_id FROM consumed) AND token_hash <> $1
+		)
+		SELECT users.id, users.username, users.email
+		FROM consumed
+		JOIN users ON users.id = consumed.user_id
+	`
+	var user User
+	err := s.db.QueryRowContext(ctx, query, hashToken(plainToken), time.Now()).Scan(&user.ID, &user.Username, &user.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) { return nil, ErrNotFound }
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// Reinvite replaces the invitation of a user who never activated their account,
+// found by email. It returns ErrNotFound when there is no such pending account.
+func (s *UsersStore) Reinvite(ctx context.Context, email, plainToken string, exp time.Duration) (*User, error) {
+	var user User
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `SELECT id, username, email, created_at FROM users WHERE email = $1 AND is_active = FALSE`
+		err := tx.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Username, &user.Email, &user.CreatedAt)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) { return ErrNotFound }
+			return err
+		}
+
+		query = `DELETE FROM user_invitations WHERE user_id = $1`
+		if _, err := tx.ExecContext(ctx, query, user.ID); err != nil { return err }
+
+		query = `INSERT INTO user_invitations (token_hash, user_id, expiry) VALUES ($1, $2, $3)`
+		_, err = tx.ExecContext(ctx, query, hashToken(plainToken), user.ID, time.Now().Add(exp))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// DeleteExpiredInvitations purges the invitations that can no longer be used.
+func (s *UsersStore) DeleteExpiredInvitations(ctx context.Context) (int64, error) {
+	query := `DELETE FROM user_invitations WHERE expiry < NOW()`
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteExpiredTokens purges the emailed tokens that can no longer be used:
+// password resets, magic links, email changes and account unlocks.
+func (s *UsersStore) DeleteExpiredTokens(ctx context.Context) (int64, error) {
+	var deleted int64
+	for _, table := range []string{"password_resets", "magic_links", "email_changes", "account_unlocks"} {
+		res, err := s.db.ExecContext(ctx, `DELETE FROM `+table+` WHERE expiry < NOW()`)
+		if err != nil {
+			return deleted, err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += rows
+	}
+	return deleted, nil
+}
+
+// DeleteUnactivated deletes the accounts that were never activated and are older
+// than gracePeriod, which frees their email and username again. Accounts with an
+// invitation still pending (e.g. one that was just resent) are kept.
+func (s *UsersStore) DeleteUnactivated(ctx context.Context, gracePeriod time.Duration) (int64, error) {
+	query := `
+		DELETE FROM users
+		WHERE is_active = FALSE AND created_at < $1 AND NOT EXISTS (
+			SELECT 1 FROM user_invitations i WHERE i.user_id = users.id AND i.expiry > NOW()
+		)
+	`
+	res, err := s.db.ExecContext(ctx, query, time.Now().Add(-gracePeriod))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// CreateMagicLink stores the hash of a single-use passwordless login token for the user.
+func (s *UsersStore) CreateMagicLink(ctx context.Context, userID int64, plainToken string, exp time.Duration) error {
+	query := `INSERT INTO magic_links (token_hash, user_id, expiry) VALUES ($1, $2, $3)`
+	_, err := s.db.ExecContext(ctx, query, hashToken(plainToken), userID, time.Now().Add(exp))
+	return err
+}
+
+// ConsumeMagicLink burns a magic link and returns the ID of the user it logs in.
+// The other links sent to the user are burnt too, the first one used wins. It
+// is a single statement, so two requests can't both consume the same link.
+func (s *UsersStore) ConsumeMagicLink(ctx context.Context, plainToken string) (int64, error) {
+	query := `
+		WITH consumed AS (
+			DELETE FROM magic_links WHERE token_hash = $1 AND expiry > $2
+			RETURNING user_id
+		), others AS (
+			DELETE FROM magic_links
+			WHERE user_id IN (SELECT user_id FROM consumed) AND token_hash <> $1
+		)
+		SELECT user_id FROM consumed
+	`
+	var userID int64
+	err := s.db.QueryRowContext(ctx, query, hashToken(plainToken), time.Now()).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) { return 0, ErrNotFound }
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// CreateEmailChange stores the hash of a token confirming the user's new email,
+// replacing any change still pending. It fails with ErrDuplicateEmail when the
+// new email already belongs to an account.
+func (s *UsersStore) CreateEmailChange(ctx context.Context, userID int64, newEmail, plainToken string, exp time.Duration) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		var taken bool
+		query := `SELECT EXISTS (SELECT 1 FROM users WHERE email = $1)`
+		if err := tx.QueryRowContext(ctx, query, newEmail).Scan(&taken); err != nil { return err }
+		if taken { return ErrDuplicateEmail }
+
+		query = `DELETE FROM email_changes WHERE user_id = $1`
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil { return err }
+
+		query = `INSERT INTO email_changes (token_hash, user_id, new_email, expiry) VALUES ($1, $2, $3, $4)`
+		_, err := tx.ExecContext(ctx, query, hashToken(plainToken), userID, newEmail, time.Now().Add(exp))
+		return err
+	})
+}
+
+// ConfirmEmailChange consumes an email change token and swaps the user's email
+// for the confirmed one. It returns the ID of the user whose email changed.
+func (s *UsersStore) ConfirmEmailChange(ctx context.Context, plainToken string) (int64, error) {
+	var userID int64
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		var newEmail string
+		query := `SELECT user_id, new_email FROM email_changes WHERE token_hash = $1 AND expiry > $2`
+		err := tx.QueryRowContext(ctx, query, hashToken(plainToken), time.Now()).Scan(&userID, &newEmail)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) { return ErrNotFound }
+			return err
+		}
+
+		// The email may have been taken since the change was asked for.
+		query = `UPDATE users SET email = $1 WHERE id = $2`
+		if _, err := tx.ExecContext(ctx, query, newEmail, userID); err != nil {
+			if err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"` {
+				return ErrDuplicateEmail
+			}
+			return err
+		}
+
+		query = `DELETE FROM email_changes WHERE user_id = $1`
+		_, err = tx.ExecContext(ctx, query, userID)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// ProfileUpdate changes the fields of a profile that are not nil.
+type ProfileUpdate struct {
+	DisplayName *string
+	Bio         *string
+	Location    *string
+	Website     *string
+	AvatarURL   *string
+	IsPrivate   *bool
+}
+
+// UpdateProfile changes the profile of an active user and returns it as saved.
+// The fields left out are kept as they are in the database, so concurrent
+// updates of different fields don't undo each other.
+func (s *UsersStore) UpdateProfile(ctx context.Context, userID int64, update *ProfileUpdate) (*Profile, error) {
+	query := `
+		UPDATE users SET
+			display_name = COALESCE($1, display_name),
+			bio = COALESCE($2, bio),
+			location = COALESCE($3, location),
+			website = COALESCE($4, website),
+			avatar_url = COALESCE($5, avatar_url),
+			is_private = COALESCE($6, is_private)
+		WHERE id = $7 AND is_active = TRUE
+		RETURNING display_name, bio, location, website, avatar_url, is_private
+	`
+	var profile Profile
+	err := s.db.QueryRowContext(
+		ctx, query, update.DisplayName, update.Bio, update.Location, update.Website, update.AvatarURL, update.IsPrivate, userID,
+	).Scan(&profile.DisplayName, &profile.Bio, &profile.Location, &profile.Website, &profile.AvatarURL, &profile.IsPrivate)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) { return nil, ErrNotFound }
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// ScheduleDeletion marks the account for deletion once the grace period is
+// over, and returns when it will be deleted. Everything that can act for the
+// user goes at once: sessions, refresh tokens, personal access tokens and the
+// apps they connected.
+func (s *UsersStore) ScheduleDeletion(ctx context.Context, userID int64, gracePeriod time.Duration) (time.Time, error) {
+	deleteAt := time.Now().Add(gracePeriod)
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `UPDATE users SET deletion_scheduled_at = $1 WHERE id = $2`
+		res, err := tx.ExecContext(ctx, query, deleteAt, userID)
+		if err != nil { return err }
+		rows, err := res.RowsAffected()
+		if err != nil { return err }
+		if rows == 0 { return ErrNotFound }
+
+		queries := []string{
+			`UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`,
+			`UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`,
+			`DELETE FROM personal_access_tokens WHERE user_id = $1`,
+			`DELETE FROM oauth_grants WHERE user_id = $1`,
+			`DELETE FROM oauth_codes WHERE user_id = $1`,
+		}
+		for _, query := range queries {
+			if _, err := tx.ExecContext(ctx, query, userID); err != nil { return err }
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return deleteAt, nil
+}
+
+// CancelDeletion keeps an account that was scheduled for deletion. It reports
+// whether there was a deletion to cancel.
+func (s *UsersStore) CancelDeletion(ctx context.Context, userID int64) (bool, error) {
+	query := `UPDATE users SET deletion_scheduled_at = NULL WHERE id = $1 AND deletion_scheduled_at IS NOT NULL`
+
+	res, err := s.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	return rows > 0, err
+}
+
+// DeleteScheduled deletes the accounts whose grace period is over and returns
+// their IDs. Everything they own goes with them; the audit log keeps its
+// entries for the record, without anything tying them to the person.
+func (s *UsersStore) DeleteScheduled(ctx context.Context) ([]int64, error) {
+	var userIDs []int64
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		// user_id is set to NULL by the foreign key, the email has to go by hand.
+		query := `
+			UPDATE audit_log a SET email = NULL
+			FROM users u
+			WHERE u.deletion_scheduled_at < NOW() AND (a.user_id = u.id OR a.email = u.email)
+		`
+		if _, err := tx.ExecContext(ctx, query); err != nil { return err }
+
+		query = `DELETE FROM users WHERE deletion_scheduled_at < NOW() RETURNING id`
+		rows, err := tx.QueryContext(ctx, query)
+		if err != nil { return err }
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil { return err }
+			userIDs = append(userIDs, id)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return userIDs, nil
+}
+
+// GetIDByUsername returns the ID of the active user with the given username.
+func (s *UsersStore) GetIDByUsername(ctx context.Context, username string) (int64, error) {
+	query := `SELECT id FROM users WHERE username = $1 AND is_active = TRUE`
+
+	var id int64
+	err := s.db.QueryRowContext(ctx, query, username).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+	return id, nil
+}
+
+// Search finds the users whose username or display name starts with, or looks
+// like, the query. Exact and prefix matches come first, then the closest ones;
+// the users viewerID already interacts with get a boost. Inactive users, users
+// being deleted and users blocked either way are left out.
+func (s *UsersStore) Search(ctx context.Context, viewerID int64, sq UserSearchQuery) ([]PublicUser, error) {
+	query := `
+		SELECT ` + publicUserColumns + `
+		FROM users u
+		WHERE u.is_active = TRUE AND u.deletion_scheduled_at IS NULL AND u.id <> $2 AND
+			(u.username ILIKE $5 OR u.display_name ILIKE $5 OR u.username % $1 OR u.display_name % $1) AND
+			NOT EXISTS (
+				SELECT 1 FROM blocks b
+				WHERE (b.user_id = $2 AND b.blocked_id = u.id) OR (b.user_id = u.id AND b.blocked_id = $2)
+			)
+		ORDER BY
+			CASE
+				WHEN lower(u.username) = lower($1) THEN 2
+				WHEN u.username ILIKE $5 OR u.display_name ILIKE $5 THEN 1
+				ELSE 0
+			END +
+			GREATEST(similarity(u.username, $1), similarity(u.display_name, $1)) +
+			CASE WHEN EXISTS (SELECT 1 FROM followers f WHERE f.user_id = u.id AND f.follower_id = $2) THEN 0.5 ELSE 0 END +
+			CASE WHEN EXISTS (SELECT 1 FROM followers f WHERE f.user_id = $2 AND f.follower_id = u.id) THEN 0.3 ELSE 0 END +
+			CASE WHEN EXISTS (
+				SELECT 1 FROM comments c JOIN posts p ON p.id = c.post_id
+				WHERE (c.user_id = $2 AND p.user_id = u.id) OR (c.user_id = u.id AND p.user_id = $2)
+			) THEN 0.3 ELSE 0 END DESC,
+			u.followers_count DESC, u.id
+		LIMIT $3 OFFSET $4
+	`
+	return listPublicUsers(ctx, s.db, query, sq.Query, viewerID, sq.Limit, sq.Offset, escapeLike(sq.Query)+"%")
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
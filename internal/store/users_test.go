@@ -0,0 +1,318 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResetPassword(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := NewStorage(db)
+
+	alice := createTestUser(t, db, "alice")
+	if err := s.RefreshTokens.Create(ctx, alice.ID, "c1a6c1b2-3d4e-4f50-8a6b-7c8d9e0f1a2b", "refresh", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{"reset", "other-reset"} {
+		if err := s.Users.CreatePasswordReset(ctx, alice.ID, token, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	userID, err := s.Users.ResetPassword(ctx, "reset", "new password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != alice.ID {
+		t.Fatalf("reset the password of user %d, want %d", userID, alice.ID)
+	}
+	user, err := s.Users.GetByEmail(ctx, alice.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := user.Password.Compare("new password"); err != nil {
+		t.Fatal("the password wasn't changed")
+	}
+	if _, _, err := s.RefreshTokens.Rotate(ctx, "refresh", "refresh-2", time.Hour); !errors.Is(err, ErrTokenReused) {
+		t.Fatalf("refresh token after a reset: got %v, want ErrTokenReused", err)
+	}
+
+	// The token is single-use, and the other tokens of the user went with it.
+	for _, token := range []string{"reset", "other-reset"} {
+		if _, err := s.Users.ResetPassword(ctx, token, "another password"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%s used again: got %v, want ErrNotFound", token, err)
+		}
+	}
+
+	if err := s.Users.CreatePasswordReset(ctx, alice.ID, "expired", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Users.ResetPassword(ctx, "expired", "another password"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expired token: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestConsumeMagicLink(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := NewStorage(db)
+
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+	for _, token := range []string{"link", "other-link"} {
+		if err := s.Users.CreateMagicLink(ctx, alice.ID, token, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Users.CreateMagicLink(ctx, bob.ID, "bob-link", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	userID, err := s.Users.ConsumeMagicLink(ctx, "link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != alice.ID {
+		t.Fatalf("logged in user %d, want %d", userID, alice.ID)
+	}
+
+	// The link is single-use and the first one used burns the others.
+	for _, token := range []string{"link", "other-link"} {
+		if _, err := s.Users.ConsumeMagicLink(ctx, token); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%s used again: got %v, want ErrNotFound", token, err)
+		}
+	}
+	if userID, err := s.Users.ConsumeMagicLink(ctx, "bob-link"); err != nil || userID != bob.ID {
+		t.Fatalf("another user's link: got %d, %v; want %d", userID, err, bob.ID)
+	}
+
+	if err := s.Users.CreateMagicLink(ctx, alice.ID, "expired", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Users.ConsumeMagicLink(ctx, "expired"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expired link: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestConfirmEmailChange(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := NewStorage(db)
+
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+
+	if err := s.Users.CreateEmailChange(ctx, alice.ID, bob.Email, "taken", time.Hour); !errors.Is(err, ErrDuplicateEmail) {
+		t.Fatalf("change to a taken email: got %v, want ErrDuplicateEmail", err)
+	}
+
+	// A new change replaces the one still pending.
+	if err := s.Users.CreateEmailChange(ctx, alice.ID, "alice@old.example.com", "first", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Users.CreateEmailChange(ctx, alice.ID, "alice@new.example.com", "second", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Users.ConfirmEmailChange(ctx, "first"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("replaced change: got %v, want ErrNotFound", err)
+	}
+
+	userID, err := s.Users.ConfirmEmailChange(ctx, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != alice.ID {
+		t.Fatalf("changed the email of user %d, want %d", userID, alice.ID)
+	}
+	if _, err := s.Users.GetByEmail(ctx, "alice@new.example.com"); err != nil {
+		t.Fatalf("the email wasn't changed: %v", err)
+	}
+	if _, err := s.Users.ConfirmEmailChange(ctx, "second"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("change confirmed again: got %v, want ErrNotFound", err)
+	}
+
+	// The email was taken between the request and its confirmation.
+	if err := s.Users.CreateEmailChange(ctx, bob.ID, "carol@example.com", "bob", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	createTestUser(t, db, "carol")
+	if _, err := s.Users.ConfirmEmailChange(ctx, "bob"); !errors.Is(err, ErrDuplicateEmail) {
+		t.Fatalf("change to an email taken since: got %v, want ErrDuplicateEmail", err)
+	}
+
+	if err := s.Users.CreateEmailChange(ctx, bob.ID, "bob@new.example.com", "expired", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Users.ConfirmEmailChange(ctx, "expired"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expired change: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := NewStorage(db)
+
+	alice := createTestUser(t, db, "alice")
+	name, bio, location, private := "Alice", "bio", "Paris", true
+
+	if _, err := s.Users.UpdateProfile(ctx, alice.ID, &ProfileUpdate{DisplayName: &name, Bio: &bio}); err != nil {
+		t.Fatal(err)
+	}
+	// The fields left out keep their value.
+	profile, err := s.Users.UpdateProfile(ctx, alice.ID, &ProfileUpdate{Location: &location, IsPrivate: &private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Profile{DisplayName: "Alice", Bio: "bio", Location: "Paris", IsPrivate: true}
+	if *profile != want {
+		t.Fatalf("UpdateProfile() = %+v, want %+v", *profile, want)
+	}
+
+	// An empty string clears a field.
+	empty := ""
+	profile, err = s.Users.UpdateProfile(ctx, alice.ID, &ProfileUpdate{Bio: &empty})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.Bio = ""
+	if *profile != want {
+		t.Fatalf("UpdateProfile() = %+v, want %+v", *profile, want)
+	}
+	user, err := s.Users.GetByID(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Profile != want {
+		t.Fatalf("saved profile %+v, want %+v", user.Profile, want)
+	}
+
+	if _, err := db.ExecContext(ctx, `UPDATE users SET is_active = FALSE WHERE id = $1`, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Users.UpdateProfile(ctx, alice.ID, &ProfileUpdate{Bio: &bio}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("inactive user: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestGetIDByUsername(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := NewStorage(db)
+
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+	if _, err := db.ExecContext(ctx, `UPDATE users SET is_active = FALSE WHERE id = $1`, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if id, err := s.Users.GetIDByUsername(ctx, "alice"); err != nil || id != alice.ID {
+		t.Fatalf("GetIDByUsername(alice) = %d, %v; want %d", id, err, alice.ID)
+	}
+	for _, username := range []string{"bob", "carol", "ali", "ali%"} {
+		if _, err := s.Users.GetIDByUsername(ctx, username); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetIDByUsername(%s): got %v, want ErrNotFound", username, err)
+		}
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := NewStorage(db)
+
+	// The viewer matches the query, but doesn't find themselves.
+	viewer := createTestUser(t, db, "jonathanviewer")
+	fuzzy := createTestUser(t, db, "jonathen")
+	notFollowed := createTestUser(t, db, "jonathan3")
+	followed := createTestUser(t, db, "jonathan2")
+	exact := createTestUser(t, db, "jonathan")
+	byName := createTestUser(t, db, "jsmith")
+	blocked := createTestUser(t, db, "jonathan_blocked")
+	inactive := createTestUser(t, db, "jonathan_inactive")
+	createTestUser(t, db, "someone")
+
+	displayName := "Jonathan Smith"
+	if _, err := s.Users.UpdateProfile(ctx, byName.ID, &ProfileUpdate{DisplayName: &displayName}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Followers.Follow(ctx, followed.ID, viewer.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Blocks.Block(ctx, blocked.ID, viewer.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, `UPDATE users SET is_active = FALSE WHERE id = $1`, inactive.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The exact match comes first, then the prefixes of the username or the
+	// display name, the users the viewer follows first, then the lookalikes.
+	want := []int64{exact.ID, followed.ID, notFollowed.ID, byName.ID, fuzzy.ID}
+	users, err := s.Users.Search(ctx, viewer.ID, UserSearchQuery{Query: "jonathan", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]int64, len(users))
+	for i, u := range users {
+		got[i] = u.ID
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Search() = %v, want %v", got, want)
+	}
+
+	// The wildcards of LIKE are matched as they are.
+	users, err = s.Users.Search(ctx, viewer.ID, UserSearchQuery{Query: "j%", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 0 {
+		t.Fatalf("Search(j%%) found %d users, want none", len(users))
+	}
+}
+
+func TestDeleteExpiredTokens(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := NewStorage(db)
+
+	alice := createTestUser(t, db, "alice")
+
+	for _, exp := range []time.Duration{-time.Second, time.Hour} {
+		suffix := exp.String()
+		if err := s.Users.CreatePasswordReset(ctx, alice.ID, "reset"+suffix, exp); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Users.CreateMagicLink(ctx, alice.ID, "magic"+suffix, exp); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Users.CreateUnlockToken(ctx, alice.ID, "unlock"+suffix, exp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only the latest email change of a user is kept, the expired one is alone.
+	if err := s.Users.CreateEmailChange(ctx, alice.ID, "new@example.com", "change", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := s.Users.DeleteExpiredTokens(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 4 {
+		t.Fatalf("deleted %d tokens, want 4", deleted)
+	}
+	for _, table := range []string{"password_resets", "magic_links", "account_unlocks"} {
+		var left int
+		if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+table+` WHERE expiry > NOW()`).Scan(&left); err != nil {
+			t.Fatal(err)
+		}
+		if left != 1 {
+			t.Fatalf("%s: %d valid tokens left, want 1", table, left)
+		}
+	}
+}
@@ -0,0 +1,4 @@
+-- The store tests migrate a schema of their own per test, the extensions are
+-- shared in public so that dropping a test schema doesn't drop them.
+CREATE EXTENSION IF NOT EXISTS citext;
+CREATE EXTENSION IF NOT EXISTS pg_trgm;